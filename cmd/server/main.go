@@ -7,12 +7,14 @@ import (
 	"os"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/mark3labs/mcp-go/server"
 	"github.com/rs/zerolog"
 	"github.com/walteh/cloudstack-mcp/pkg/cloudstack"
 	"github.com/walteh/cloudstack-mcp/pkg/lmcp"
 	"github.com/walteh/cloudstack-mcp/pkg/mcp"
+	errors "gitlab.com/tozd/go/errors"
 )
 
 func main() {
@@ -23,8 +25,34 @@ func main() {
 	apiURL := flag.String("api-url", getEnv("CLOUDSTACK_API_URL", "http://localhost:8080/client/api"), "CloudStack API URL")
 	apiKey := flag.String("api-key", getEnv("CLOUDSTACK_API_KEY", ""), "CloudStack API Key")
 	secretKey := flag.String("secret-key", getEnv("CLOUDSTACK_SECRET_KEY", ""), "CloudStack Secret Key")
-	username := flag.String("username", getEnv("CLOUDSTACK_USERNAME", "admin"), "CloudStack Username (if API keys not provided)")
-	password := flag.String("password", getEnv("CLOUDSTACK_PASSWORD", "password"), "CloudStack Password (if API keys not provided)")
+	secretKeyFile := flag.String("secret-key-file", getEnv("CLOUDSTACK_SECRET_KEY_FILE", ""), "File holding the CloudStack Secret Key, e.g. a docker or kubernetes secret")
+	username := flag.String("username", getEnv("CLOUDSTACK_USERNAME", ""), "CloudStack Username (if API keys not provided)")
+	password := flag.String("password", getEnv("CLOUDSTACK_PASSWORD", ""), "CloudStack Password (if API keys not provided)")
+	passwordFile := flag.String("password-file", getEnv("CLOUDSTACK_PASSWORD_FILE", ""), "File holding the CloudStack Password, e.g. a docker or kubernetes secret")
+	keychainService := flag.String("keychain-service", getEnv("CLOUDSTACK_KEYCHAIN_SERVICE", ""), "Keychain service to look up the password (account: the username) or secret key (account: the API key) in when they are not given, with security on macOS and secret-tool elsewhere")
+	keysFile := flag.String("keys-file", getEnv("CLOUDSTACK_KEYS_FILE", ""), "File the API keys obtained with the username and password are kept in between runs (defaults to one per endpoint and user in the user config directory)")
+	rotateKeys := flag.Bool("rotate-keys", false, "Register new API keys for the username, replacing the current ones for every client, save them to the keys file and exit")
+	envFile := flag.String("env-file", getEnv("CLOUDSTACK_ENV_FILE", ""), "File of KEY=VALUE lines setting the CLOUDSTACK_* variables of the flags not given on the command line or in the environment")
+	authModeStr := flag.String("auth-mode", getEnv("CLOUDSTACK_AUTH_MODE", ""), "CloudStack authentication mode: password or apikey (defaults to apikey when API keys are provided)")
+	profilesPath := flag.String("profiles", getEnv("CLOUDSTACK_PROFILES", ""), "CloudMonkey style config file of CloudStack profiles (e.g. ~/.cmk/config); tools take a profile argument to call any of them")
+	profileName := flag.String("profile", getEnv("CLOUDSTACK_PROFILE", ""), "Profile the server's own endpoint and credentials come from (defaults to the profile set in the config file)")
+	signatureVersion := flag.Int("signature-version", 0, "Signature version for apikey mode (3 adds an expiry to every signed request)")
+	signatureExpires := flag.Duration("signature-expires", 10*time.Minute, "How long signed requests stay valid when using signature version 3")
+	jobTimeout := flag.Duration("job-timeout", 5*time.Minute, "How long to wait for CloudStack async jobs before returning the job id")
+	maxListItems := flag.Int("max-list-items", 2000, "Maximum number of items returned by an auto-paginated list call")
+	maxResponseBytes := flag.Int("max-response-bytes", 100_000, "Truncate tool results larger than this many bytes (0 disables the limit)")
+	apiPolicy := flag.String("api-policy", getEnv("CLOUDSTACK_API_POLICY", "full"), "Which APIs become tools: a YAML/JSON policy file, or the builtin \"full\" or \"readonly\" policy")
+	includeApis := flag.String("include-apis", "", "Comma separated API name globs to include, added to the policy")
+	excludeApis := flag.String("exclude-apis", "", "Comma separated API name globs to exclude, added to the policy")
+	includeVerbs := flag.String("include-verbs", "", "Comma separated API verbs (list, create, update, delete, ...) to include, added to the policy")
+	excludeVerbs := flag.String("exclude-verbs", "", "Comma separated API verbs to exclude, added to the policy")
+	safeMode := flag.Bool("safe-mode", false, "Require a dry_run preview and confirm: true before any call that changes or destroys resources")
+	sessionCredentials := flag.Bool("session-credentials", false, "Make every HTTP client call CloudStack with its own API key pair, sent in the "+cloudstack.APIKeyHeader+" and "+cloudstack.SecretKeyHeader+" headers or through the cs_authenticate tool")
+	resolveNames := flag.Bool("resolve-names", false, "Accept resource names for id parameters like zoneid and look up their ids with the matching list API")
+	catalogCacheDir := flag.String("catalog-cache-dir", getEnv("CLOUDSTACK_CATALOG_CACHE_DIR", ""), "Directory to cache the CloudStack API catalog in (defaults to the user cache directory)")
+	catalogRefreshInterval := flag.Duration("catalog-refresh-interval", 0, "How often to fetch the CloudStack API catalog again and update the tools (0 only refreshes through the cs_refresh_api_catalog tool)")
+	toolModeStr := flag.String("tool-mode", getEnv("CLOUDSTACK_TOOL_MODE", ""), "How APIs become tools: flat (one tool per API) or grouped (search, describe and call meta-tools)")
+	resourcePollInterval := flag.Duration("resource-poll-interval", time.Minute, "How often subscribed MCP resources are read again to notify clients of changes")
 	timeoutStr := flag.String("timeout", getEnv("CLOUDSTACK_TIMEOUT", "60"), "CloudStack API Timeout in seconds")
 	addr := flag.String("addr", getEnv("MCP_ADDR", ":8250"), "Address to listen on")
 	disableLogFile := flag.Bool("disable-log-file", false, "Disable log file")
@@ -32,6 +60,33 @@ func main() {
 	http := flag.Bool("http", true, "Run in HTTP mode")
 	flag.Parse()
 
+	if *envFile != "" {
+		if err := applyEnvFile(*envFile); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	}
+
+	if err := readSecret(password, *passwordFile, "password"); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	if err := readSecret(secretKey, *secretKeyFile, "secret-key"); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	if *keychainService != "" {
+		if err := lookupKeychain(ctx, password, *keychainService, *username); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		if err := lookupKeychain(ctx, secretKey, *keychainService, *apiKey); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	}
+
 	if *printLogDir {
 		logdir, err := lmcp.MyLogFileDir()
 		if err != nil {
@@ -47,6 +102,43 @@ func main() {
 		fmt.Println(err)
 	}
 
+	if *authModeStr == "" && *apiKey != "" && *secretKey != "" {
+		*authModeStr = string(cloudstack.AuthModeAPIKey)
+	}
+
+	authMode, err := cloudstack.ParseAuthMode(*authModeStr)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	toolMode, err := mcp.ParseToolMode(*toolModeStr)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	policy, err := mcp.LoadPolicy(*apiPolicy)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	policy.Include.Names = append(policy.Include.Names, splitList(*includeApis)...)
+	policy.Exclude.Names = append(policy.Exclude.Names, splitList(*excludeApis)...)
+	policy.Include.Verbs = append(policy.Include.Verbs, splitList(*includeVerbs)...)
+	policy.Exclude.Verbs = append(policy.Exclude.Verbs, splitList(*excludeVerbs)...)
+
+	if err := policy.Validate(); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	if *sessionCredentials && !*http {
+		fmt.Println("--session-credentials requires --http")
+		os.Exit(1)
+	}
+
 	// Create CloudStack client config
 	config := &cloudstack.Config{
 		APIURL:    *apiURL,
@@ -55,19 +147,91 @@ func main() {
 		Timeout:   timeout,
 	}
 
-	logfunc, err := lmcp.WrapMCPServerWithLogging(ctx, lmcp.LMCPOpts{
+	creds := cloudstack.Credentials{
+		Mode:             authMode,
+		Username:         *username,
+		Password:         *password,
+		SignatureVersion: *signatureVersion,
+		Expires:          *signatureExpires,
+	}
+
+	var profiles *cloudstack.Profiles
+	if *profilesPath != "" {
+		profiles, err = cloudstack.LoadProfiles(*profilesPath)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+
+		if *profileName == "" {
+			*profileName = profiles.Default
+		}
+		profile, ok := profiles.Get(*profileName)
+		if !ok {
+			fmt.Printf("unknown profile %q, the config defines %s\n", *profileName, strings.Join(profiles.Names(), ", "))
+			os.Exit(1)
+		}
+
+		// the selected profile is the server's own endpoint, and the default of the profile argument
+		profiles.Default = profile.Name
+		config.APIURL = profile.APIURL
+		config.APIKey = profile.Credentials.APIKey
+		config.SecretKey = profile.Credentials.SecretKey
+		creds = profile.Credentials
+	}
+
+	lmcpOpts := lmcp.LMCPOpts{
 		HTTPMode:       *http,
 		HTTPAddr:       *addr,
 		DisableLogFile: *disableLogFile,
 		LogLevelStr:    "trace",
-	})
+	}
+	if *sessionCredentials {
+		lmcpOpts.SSEContextFunc = mcp.SessionCredentialsContextFunc
+	}
+
+	keysPath := *keysFile
+	if keysPath == "" && creds.Username != "" {
+		if keysPath, err = cloudstack.DefaultKeysPath(config.APIURL, creds.Username); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	}
+
+	if *rotateKeys {
+		if creds.Username == "" || creds.Password == "" {
+			fmt.Println("--rotate-keys requires a username and password")
+			os.Exit(1)
+		}
+		if _, _, err := cloudstack.BootstrapAPIKeys(ctx, config.APIURL, creds.Username, creds.Password, keysPath, true); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		fmt.Printf("Registered new API keys for %s and saved them to %s\n", creds.Username, keysPath)
+		os.Exit(0)
+	}
+
+	logfunc, err := lmcp.WrapMCPServerWithLogging(ctx, lmcpOpts)
 	if err != nil {
 		fmt.Println(err)
 	}
 
 	// Start the server
 	if err := logfunc(ctx, func(ctx context.Context) (*server.MCPServer, error) {
-		server, err := setupServer(ctx, config, username, password, apiURL)
+		server, err := setupServer(ctx, config, creds, keysPath, mcp.ServerOpts{
+			JobTimeout:             *jobTimeout,
+			MaxListItems:           *maxListItems,
+			MaxResponseBytes:       *maxResponseBytes,
+			Policy:                 policy,
+			SafeMode:               *safeMode,
+			CatalogCacheDir:        *catalogCacheDir,
+			CatalogRefreshInterval: *catalogRefreshInterval,
+			ToolMode:               toolMode,
+			ResourcePollInterval:   *resourcePollInterval,
+			ResolveNames:           *resolveNames,
+			Profiles:               profiles,
+			SessionCredentials:     *sessionCredentials,
+		})
 		if err != nil {
 			return nil, err
 		}
@@ -77,6 +241,84 @@ func main() {
 	}
 }
 
+// envFlags are the flags defaulting to an environment variable that an --env-file can set
+var envFlags = map[string]string{
+	"api-url":           "CLOUDSTACK_API_URL",
+	"api-key":           "CLOUDSTACK_API_KEY",
+	"secret-key":        "CLOUDSTACK_SECRET_KEY",
+	"secret-key-file":   "CLOUDSTACK_SECRET_KEY_FILE",
+	"username":          "CLOUDSTACK_USERNAME",
+	"password":          "CLOUDSTACK_PASSWORD",
+	"password-file":     "CLOUDSTACK_PASSWORD_FILE",
+	"keychain-service":  "CLOUDSTACK_KEYCHAIN_SERVICE",
+	"keys-file":         "CLOUDSTACK_KEYS_FILE",
+	"auth-mode":         "CLOUDSTACK_AUTH_MODE",
+	"profiles":          "CLOUDSTACK_PROFILES",
+	"profile":           "CLOUDSTACK_PROFILE",
+	"api-policy":        "CLOUDSTACK_API_POLICY",
+	"catalog-cache-dir": "CLOUDSTACK_CATALOG_CACHE_DIR",
+	"tool-mode":         "CLOUDSTACK_TOOL_MODE",
+	"timeout":           "CLOUDSTACK_TIMEOUT",
+	"addr":              "MCP_ADDR",
+}
+
+// applyEnvFile sets the flags given neither on the command line nor in the
+// environment from the variables of the env file at path
+func applyEnvFile(path string) error {
+	env, err := cloudstack.LoadEnvFile(path)
+	if err != nil {
+		return err
+	}
+
+	given := map[string]bool{}
+	flag.Visit(func(f *flag.Flag) {
+		given[f.Name] = true
+	})
+
+	for name, key := range envFlags {
+		value, ok := env[key]
+		if !ok || given[name] || os.Getenv(key) != "" {
+			continue
+		}
+		if err := flag.Set(name, value); err != nil {
+			return errors.Errorf("setting --%s from %s in %s: %w", name, key, path, err)
+		}
+	}
+
+	return nil
+}
+
+// readSecret sets value to the contents of the file at path, if one is given
+func readSecret(value *string, path string, name string) error {
+	if path == "" {
+		return nil
+	}
+	if *value != "" {
+		return errors.Errorf("--%s and --%s-file cannot both be given", name, name)
+	}
+
+	secret, err := cloudstack.ReadSecretFile(path)
+	if err != nil {
+		return errors.Errorf("reading --%s-file: %w", name, err)
+	}
+	*value = secret
+	return nil
+}
+
+// lookupKeychain sets an unset value to the secret the keychain holds for account
+func lookupKeychain(ctx context.Context, value *string, service, account string) error {
+	if *value != "" || account == "" {
+		return nil
+	}
+
+	secret, err := cloudstack.KeychainSecret(ctx, service, account)
+	if err != nil {
+		return err
+	}
+	*value = secret
+	return nil
+}
+
 func getEnv(key, fallback string) string {
 	value := os.Getenv(key)
 	if len(value) == 0 {
@@ -85,21 +327,32 @@ func getEnv(key, fallback string) string {
 	return value
 }
 
+// splitList splits a comma separated flag value, dropping empty entries
+func splitList(s string) []string {
+	list := []string{}
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
 // getAPICredentials tries to obtain API keys using username/password authentication
 
 // http://localhost:8080/client/api?command=registerUserKeys&id=1952b104-acce-11ef-ae80-0242ac110002&response=json&sessionkey=s2c6DH5nJO-b7s1TbK80w_CCTTk
 // http://localhost:8080/client/api?command=registerUserKeys&id=1952b104-acce-11ef-ae80-0242ac110002&sessionkey=52m3oEDfr-6gbbkhHdKC3BtSraI&response=json
 
-func setupServer(ctx context.Context, config *cloudstack.Config, username, password, apiURL *string) (*mcp.Server, error) {
+func setupServer(ctx context.Context, config *cloudstack.Config, creds cloudstack.Credentials, keysPath string, opts mcp.ServerOpts) (*mcp.Server, error) {
 
 	logger := zerolog.Ctx(ctx)
 
 	// Check if we need to obtain API keys using username/password
-	if (config.APIKey == "" || config.SecretKey == "") && *username != "" && *password != "" {
+	if creds.Mode == cloudstack.AuthModeAPIKey && (config.APIKey == "" || config.SecretKey == "") && creds.Username != "" && creds.Password != "" {
 		logger.Info().Msg("API keys not provided, attempting to get them using username/password")
 
-		// Get API keys using the CloudStack Go SDK directly
-		apiKey, secretKey, err := cloudstack.GetAPICredentials(ctx, *apiURL, *username, *password)
+		// reuse the keys of an earlier run or of the user, registering new ones only when there are none
+		apiKey, secretKey, err := cloudstack.BootstrapAPIKeys(ctx, config.APIURL, creds.Username, creds.Password, keysPath, false)
 		if err != nil {
 			logger.Fatal().Err(err).Msg("Failed to get API credentials")
 		}
@@ -109,6 +362,9 @@ func setupServer(ctx context.Context, config *cloudstack.Config, username, passw
 		config.SecretKey = secretKey
 	}
 
+	creds.APIKey = config.APIKey
+	creds.SecretKey = config.SecretKey
+
 	// Create CloudStack client
 	// client, err := cloudstack.NewClient(config)
 	// if err != nil {
@@ -124,7 +380,7 @@ func setupServer(ctx context.Context, config *cloudstack.Config, username, passw
 	ctx = loggerd.WithContext(ctx)
 
 	// Create and start MCP server
-	server, err := mcp.NewServer(ctx, *username, *password, *apiURL)
+	server, err := mcp.NewServer(ctx, config.APIURL, creds, opts)
 	if err != nil {
 		logger.Fatal().Err(err).Msg("Failed to create MCP server")
 	}
@@ -396,7 +396,7 @@ func (r *TaskRegistry) executeTaskHandler(ctx context.Context, request mcp.CallT
 	// Extract variable values from the request
 	varsFromTask := extractVars(task)
 	for varName := range varsFromTask {
-		if val, ok := request.Params.Arguments[varName].(string); ok {
+		if val, ok := request.GetArguments()[varName].(string); ok {
 			vars[varName] = val
 			logger.Debug().
 				Str("task", taskName).
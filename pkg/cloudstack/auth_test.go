@@ -0,0 +1,90 @@
+package cloudstack_test
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/walteh/cloudstack-mcp/pkg/cloudstack"
+)
+
+func Test_SignedRequest(t *testing.T) {
+	var query url.Values
+
+	cs := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query = r.URL.Query()
+		fmt.Fprint(w, `{"listzonesresponse":{}}`)
+	}))
+	defer cs.Close()
+
+	params := map[string]string{"name": "zone 1", "tags[0].key": "env"}
+
+	t.Run("known signature", func(t *testing.T) {
+		creds := cloudstack.Credentials{Mode: cloudstack.AuthModeAPIKey, APIKey: "key", SecretKey: "secret"}
+
+		_, err := cloudstack.DoRawCloudStackRequest(t.Context(), cs.URL, "listZones", creds, params)
+		require.NoError(t, err)
+
+		// the string to sign is apikey=key&command=listzones&name=zone%201&response=json&tags[0].key=env
+		assert.Equal(t, "KgcLh8lAUtZg6TBI8XJuK9bnPfg=", query.Get("signature"))
+	})
+
+	t.Run("signature version 3", func(t *testing.T) {
+		creds := cloudstack.Credentials{Mode: cloudstack.AuthModeAPIKey, APIKey: "key", SecretKey: "secret", SignatureVersion: 3, Expires: time.Hour}
+
+		_, err := cloudstack.DoRawCloudStackRequest(t.Context(), cs.URL, "listZones", creds, params)
+		require.NoError(t, err)
+
+		// CloudStack parses expires with yyyy-MM-dd'T'HH:mm:ssZ, which takes +0000 but not Z
+		expires := query.Get("expires")
+		assert.Regexp(t, `^\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}\+0000$`, expires)
+
+		parsed, err := time.Parse("2006-01-02T15:04:05-0700", expires)
+		require.NoError(t, err)
+		assert.WithinDuration(t, time.Now().Add(time.Hour), parsed, time.Minute)
+
+		toSign := "apikey=key&command=listzones&expires=" + url.QueryEscape(expires) + "&name=zone%201&response=json&signatureversion=3&tags[0].key=env"
+		mac := hmac.New(sha1.New, []byte("secret"))
+		mac.Write([]byte(strings.ToLower(toSign)))
+		assert.Equal(t, base64.StdEncoding.EncodeToString(mac.Sum(nil)), query.Get("signature"))
+		assert.Equal(t, "3", query.Get("signatureversion"))
+	})
+}
+
+func Test_CredentialsFromContext(t *testing.T) {
+	var apiKey string
+
+	cs := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		apiKey = r.URL.Query().Get("apiKey")
+		fmt.Fprint(w, `{"listzonesresponse":{}}`)
+	}))
+	defer cs.Close()
+
+	server := cloudstack.Credentials{Mode: cloudstack.AuthModeAPIKey, APIKey: "server", SecretKey: "secret"}
+
+	h := http.Header{}
+	h.Set(cloudstack.APIKeyHeader, "client")
+	_, ok := cloudstack.CredentialsFromHeader(h)
+	assert.False(t, ok, "the secret key is missing")
+
+	h.Set(cloudstack.SecretKeyHeader, "secret")
+	client, ok := cloudstack.CredentialsFromHeader(h)
+	require.True(t, ok)
+
+	_, err := cloudstack.DoRawCloudStackRequest(t.Context(), cs.URL, "listZones", server, map[string]string{})
+	require.NoError(t, err)
+	assert.Equal(t, "server", apiKey)
+
+	_, err = cloudstack.DoRawCloudStackRequest(cloudstack.WithCredentials(t.Context(), client), cs.URL, "listZones", server, map[string]string{})
+	require.NoError(t, err)
+	assert.Equal(t, "client", apiKey)
+}
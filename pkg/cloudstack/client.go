@@ -228,38 +228,36 @@ func (c *Client) ListZones() ([]map[string]string, error) {
 	return result, nil
 }
 
-// callNewRequest is a helper function to call the unexported newRequest method
-// on the CloudStack client using reflection
-
-// encodeURLValues creates a URL encoded string of the given values and signs it
+// signURLValues sets the apiKey parameter on the given values and signs them
 // with the given secret key
-func encodeURLValues(values url.Values, apiKey, secretKey string) string {
+func signURLValues(values url.Values, apiKey, secretKey string) {
 	// Set the apiKey parameter
 	values.Set("apiKey", apiKey)
 
-	// Generate signature for the request
-	// Sort the parameters alphabetically
-	var keys []string
-	for k := range values {
-		keys = append(keys, k)
+	// The signature is calculated over the query sorted by lower cased name and
+	// then lower cased, with values escaped (spaces as %20 instead of +) but
+	// names left as they are, so that map parameters like tags[0].key match
+	// what CloudStack signs
+	values.Del("signature")
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
 	}
-	sort.Strings(keys)
-
-	// Create a string of sorted parameters
-	var signatureParams strings.Builder
-	for _, k := range keys {
-		signatureParams.WriteString(k)
-		signatureParams.WriteString("=")
-		signatureParams.WriteString(url.QueryEscape(values.Get(k)))
+	sort.Slice(names, func(i, j int) bool { return strings.ToLower(names[i]) < strings.ToLower(names[j]) })
+
+	pairs := make([]string, 0, len(names))
+	for _, name := range names {
+		for _, value := range values[name] {
+			pairs = append(pairs, name+"="+strings.ReplaceAll(url.QueryEscape(value), "+", "%20"))
+		}
 	}
+	signatureParams := strings.ToLower(strings.Join(pairs, "&"))
 
 	// Calculate signature
 	mac := hmac.New(sha1.New, []byte(secretKey))
-	mac.Write([]byte(strings.ToLower(signatureParams.String())))
+	mac.Write([]byte(signatureParams))
 	signature := base64.StdEncoding.EncodeToString(mac.Sum(nil))
 
 	// Add the signature to the query parameters
 	values.Set("signature", signature)
-
-	return values.Encode()
 }
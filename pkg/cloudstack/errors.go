@@ -0,0 +1,163 @@
+package cloudstack
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// CloudStack error codes, from org.apache.cloudstack.api.ApiErrorCode
+const (
+	ErrorCodeUnauthorized         = 401
+	ErrorCodeMethodNotAllowed     = 405
+	ErrorCodeAPILimitExceeded     = 429
+	ErrorCodeMalformedParameter   = 430
+	ErrorCodeParamError           = 431
+	ErrorCodeUnsupportedAction    = 432
+	ErrorCodeInternalError        = 530
+	ErrorCodeAccountError         = 531
+	ErrorCodeAccountResourceLimit = 532
+	ErrorCodeInsufficientCapacity = 533
+	ErrorCodeResourceUnavailable  = 534
+	ErrorCodeResourceAllocation   = 535
+	ErrorCodeResourceInUse        = 536
+	ErrorCodeNetworkRuleConflict  = 537
+)
+
+// ErrorKind groups CloudStack errors by what the caller can do about them
+type ErrorKind string
+
+const (
+	ErrorKindMissingParameter     ErrorKind = "missing_parameter"
+	ErrorKindInvalidParameter     ErrorKind = "invalid_parameter"
+	ErrorKindPermissionDenied     ErrorKind = "permission_denied"
+	ErrorKindNotFound             ErrorKind = "not_found"
+	ErrorKindInsufficientCapacity ErrorKind = "insufficient_capacity"
+	ErrorKindResourceLimit        ErrorKind = "resource_limit"
+	ErrorKindResourceInUse        ErrorKind = "resource_in_use"
+	ErrorKindRateLimited          ErrorKind = "rate_limited"
+	ErrorKindOther                ErrorKind = "other"
+)
+
+var (
+	missingParamPattern = regexp.MustCompile(`(?i)missing parameter:? ?(\w+)`)
+	invalidParamPattern = regexp.MustCompile(`(?i)invalid parameter:? ?(\w+)`)
+	notFoundPattern     = regexp.MustCompile(`(?i)unable to find|not found|does not exist|doesn't exist|entity does not exist|no .* with (specified )?id`)
+	permissionPattern   = regexp.MustCompile(`(?i)permission|not allowed|access denied|unauthori[sz]ed|does not have access`)
+)
+
+// APIError is an error response of CloudStack, like
+// {"deployvirtualmachineresponse":{"errorcode":431,"cserrorcode":9999,"errortext":"..."}}
+type APIError struct {
+	Command     string `json:"command,omitempty"`
+	StatusCode  int    `json:"-"`
+	ErrorCode   int    `json:"errorcode"`
+	CSErrorCode int    `json:"cserrorcode,omitempty"`
+	ErrorText   string `json:"errortext"`
+	// Param is the parameter the error is about, when CloudStack names one
+	Param string `json:"param,omitempty"`
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("CloudStack error %d: %s", e.ErrorCode, e.ErrorText)
+}
+
+// Kind classifies the error by its code and text
+func (e *APIError) Kind() ErrorKind {
+	return classifyError(e.ErrorCode, e.ErrorText)
+}
+
+// Hint suggests how to correct the request that failed
+func (e *APIError) Hint() string {
+	return errorHint(e.Kind(), e.Param)
+}
+
+// parseAPIError parses a CloudStack error response body, returning nil if it is not one
+func parseAPIError(statusCode int, body []byte) *APIError {
+	var envelope map[string]json.RawMessage
+	if err := json.Unmarshal(body, &envelope); err != nil {
+		return nil
+	}
+
+	for key, value := range envelope {
+		if !strings.HasSuffix(key, "response") {
+			continue
+		}
+
+		apiErr := &APIError{}
+		if err := json.Unmarshal(value, apiErr); err != nil || (apiErr.ErrorCode == 0 && apiErr.ErrorText == "") {
+			return nil
+		}
+		apiErr.StatusCode = statusCode
+		apiErr.Param = errorParam(apiErr.ErrorText)
+		// the envelope is named after the lower cased command, e.g. deployvirtualmachineresponse
+		if key != "errorresponse" {
+			apiErr.Command = strings.TrimSuffix(key, "response")
+		}
+		return apiErr
+	}
+
+	return nil
+}
+
+func errorParam(text string) string {
+	for _, pattern := range []*regexp.Regexp{missingParamPattern, invalidParamPattern} {
+		if m := pattern.FindStringSubmatch(text); m != nil {
+			return strings.ToLower(m[1])
+		}
+	}
+	return ""
+}
+
+func classifyError(code int, text string) ErrorKind {
+	switch {
+	case missingParamPattern.MatchString(text):
+		return ErrorKindMissingParameter
+	case code == ErrorCodeAPILimitExceeded:
+		return ErrorKindRateLimited
+	case code == ErrorCodeAccountResourceLimit:
+		return ErrorKindResourceLimit
+	case code == ErrorCodeInsufficientCapacity:
+		return ErrorKindInsufficientCapacity
+	case code == ErrorCodeResourceInUse:
+		return ErrorKindResourceInUse
+	case notFoundPattern.MatchString(text):
+		return ErrorKindNotFound
+	case code == ErrorCodeUnauthorized || code == ErrorCodeAccountError || permissionPattern.MatchString(text):
+		return ErrorKindPermissionDenied
+	case code == ErrorCodeParamError || code == ErrorCodeMalformedParameter:
+		return ErrorKindInvalidParameter
+	default:
+		return ErrorKindOther
+	}
+}
+
+func errorHint(kind ErrorKind, param string) string {
+	switch kind {
+	case ErrorKindMissingParameter:
+		if param != "" {
+			return fmt.Sprintf("the required parameter %s is missing, add it and call again", param)
+		}
+		return "a required parameter is missing, check the API's required parameters and call again"
+	case ErrorKindInvalidParameter:
+		if param != "" {
+			return fmt.Sprintf("the value of %s is invalid, check its type and allowed values", param)
+		}
+		return "a parameter value is invalid, check the parameter types and allowed values"
+	case ErrorKindPermissionDenied:
+		return "the account is not allowed to do this, use a resource it owns or ask an administrator"
+	case ErrorKindNotFound:
+		return "the resource does not exist or the account cannot see it, look up its id with the matching list API"
+	case ErrorKindInsufficientCapacity:
+		return "there is not enough capacity, try another zone, offering or smaller size"
+	case ErrorKindResourceLimit:
+		return "the account reached a resource limit, free up resources or ask an administrator to raise the limit"
+	case ErrorKindResourceInUse:
+		return "the resource is in use, stop, detach or delete what uses it first"
+	case ErrorKindRateLimited:
+		return "too many API calls, wait a moment before calling again"
+	default:
+		return ""
+	}
+}
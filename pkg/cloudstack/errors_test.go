@@ -0,0 +1,109 @@
+package cloudstack_test
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/walteh/cloudstack-mcp/pkg/cloudstack"
+	errors "gitlab.com/tozd/go/errors"
+)
+
+func Test_APIError(t *testing.T) {
+	tests := []struct {
+		name      string
+		status    int
+		body      string
+		wantKind  cloudstack.ErrorKind
+		wantParam string
+		wantCode  int
+	}{
+		{
+			name:      "missing parameter",
+			status:    431,
+			body:      `{"deployvirtualmachineresponse":{"uuidList":[],"errorcode":431,"cserrorcode":9999,"errortext":"Unable to execute API command deployvirtualmachine due to missing parameter zoneid"}}`,
+			wantKind:  cloudstack.ErrorKindMissingParameter,
+			wantParam: "zoneid",
+			wantCode:  431,
+		},
+		{
+			name:      "unknown id",
+			status:    431,
+			body:      `{"listvirtualmachinesresponse":{"errorcode":431,"cserrorcode":4350,"errortext":"Unable to execute API command listvirtualmachines due to invalid value. Invalid parameter id value=nope due to incorrect long value format, or entity does not exist or due to incorrect parameter annotation for the field in api cmd class."}}`,
+			wantKind:  cloudstack.ErrorKindNotFound,
+			wantParam: "id",
+			wantCode:  431,
+		},
+		{
+			name:     "permission denied",
+			status:   531,
+			body:     `{"deletezoneresponse":{"errorcode":531,"cserrorcode":4365,"errortext":"Account 'user' does not have permission to operate within domain id=1"}}`,
+			wantKind: cloudstack.ErrorKindPermissionDenied,
+			wantCode: 531,
+		},
+		{
+			name:     "insufficient capacity",
+			status:   533,
+			body:     `{"deployvirtualmachineresponse":{"errorcode":533,"cserrorcode":4250,"errortext":"Unable to create a deployment for VM instance"}}`,
+			wantKind: cloudstack.ErrorKindInsufficientCapacity,
+			wantCode: 533,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cs := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.status)
+				fmt.Fprint(w, tt.body)
+			}))
+			defer cs.Close()
+
+			_, err := cloudstack.DoRawCloudStackRequest(t.Context(), cs.URL, "someCommand", cloudstack.Credentials{Mode: cloudstack.AuthModeAPIKey, APIKey: "key", SecretKey: "secret"}, nil)
+
+			var aerr *cloudstack.APIError
+			require.True(t, errors.As(err, &aerr), "%v", err)
+			assert.Equal(t, tt.wantCode, aerr.ErrorCode)
+			assert.Equal(t, tt.wantKind, aerr.Kind())
+			assert.Equal(t, tt.wantParam, aerr.Param)
+			assert.NotEmpty(t, aerr.Hint())
+		})
+	}
+}
+
+func Test_APIError_Hint(t *testing.T) {
+	tests := []struct {
+		name string
+		err  *cloudstack.APIError
+		want string
+	}{
+		{
+			name: "missing parameter",
+			err:  &cloudstack.APIError{ErrorCode: 431, ErrorText: "Unable to execute API command createzone due to missing parameter dns1", Param: "dns1"},
+			want: "the required parameter dns1 is missing, add it and call again",
+		},
+		{
+			name: "missing parameter without a name",
+			err:  &cloudstack.APIError{ErrorCode: 431, ErrorText: "Unable to execute API command createzone due to missing parameter dns1"},
+			want: "a required parameter is missing, check the API's required parameters and call again",
+		},
+		{
+			name: "invalid parameter without a name",
+			err:  &cloudstack.APIError{ErrorCode: 431, ErrorText: "Unable to execute API command createzone due to invalid value"},
+			want: "a parameter value is invalid, check the parameter types and allowed values",
+		},
+		{
+			name: "other",
+			err:  &cloudstack.APIError{ErrorCode: 530, ErrorText: "Internal error executing command"},
+			want: "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, tt.err.Hint())
+		})
+	}
+}
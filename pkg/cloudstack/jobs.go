@@ -0,0 +1,233 @@
+package cloudstack
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog"
+	errors "gitlab.com/tozd/go/errors"
+)
+
+// RequestFunc calls a single CloudStack command and returns the raw response body
+type RequestFunc func(ctx context.Context, command string, params map[string]string) (json.RawMessage, error)
+
+// Async job statuses as reported in the jobstatus field
+const (
+	JobStatusPending   = 0
+	JobStatusSucceeded = 1
+	JobStatusFailed    = 2
+)
+
+// AsyncJob is the result of queryAsyncJobResult
+type AsyncJob struct {
+	JobID           string          `json:"jobid"`
+	Cmd             string          `json:"cmd,omitempty"`
+	JobStatus       int             `json:"jobstatus"`
+	JobProcStatus   int             `json:"jobprocstatus"`
+	JobResultCode   int             `json:"jobresultcode"`
+	JobResultType   string          `json:"jobresulttype,omitempty"`
+	JobResult       json.RawMessage `json:"jobresult,omitempty"`
+	JobInstanceType string          `json:"jobinstancetype,omitempty"`
+	JobInstanceID   string          `json:"jobinstanceid,omitempty"`
+	Created         string          `json:"created,omitempty"`
+	Completed       string          `json:"completed,omitempty"`
+}
+
+// Done reports whether the job has finished, successfully or not
+func (j *AsyncJob) Done() bool {
+	return j.JobStatus != JobStatusPending
+}
+
+// JobFailedError is returned when an async job finishes with a failed status
+type JobFailedError struct {
+	JobID         string `json:"jobid"`
+	Cmd           string `json:"cmd,omitempty"`
+	JobResultCode int    `json:"jobresultcode"`
+	ErrorCode     int    `json:"errorcode"`
+	ErrorText     string `json:"errortext"`
+	// Kind and Hint classify the failure like for an APIError
+	Kind ErrorKind `json:"kind,omitempty"`
+	Hint string    `json:"hint,omitempty"`
+}
+
+func (e *JobFailedError) Error() string {
+	return fmt.Sprintf("async job %s failed: %d: %s", e.JobID, e.ErrorCode, e.ErrorText)
+}
+
+// JobTimeoutError is returned when an async job is still pending after the wait timeout
+type JobTimeoutError struct {
+	Job     *AsyncJob
+	Timeout time.Duration
+}
+
+func (e *JobTimeoutError) Error() string {
+	return fmt.Sprintf("async job %s still pending after %s", e.Job.JobID, e.Timeout)
+}
+
+// WaitOptions configures WaitForAsyncJob
+type WaitOptions struct {
+	// Timeout is how long to wait for the job before giving up; zero means no timeout
+	Timeout time.Duration
+	// InitialInterval is the first delay between polls, doubled after every poll up to MaxInterval
+	InitialInterval time.Duration
+	MaxInterval     time.Duration
+	// OnPoll is called after every poll that finds the job still pending
+	OnPoll func(job *AsyncJob, elapsed time.Duration)
+}
+
+func (o WaitOptions) withDefaults() WaitOptions {
+	if o.InitialInterval <= 0 {
+		o.InitialInterval = 500 * time.Millisecond
+	}
+	if o.MaxInterval <= 0 {
+		o.MaxInterval = 5 * time.Second
+	}
+	return o
+}
+
+// QueryAsyncJob fetches the current state of an async job
+func QueryAsyncJob(ctx context.Context, do RequestFunc, jobID string) (*AsyncJob, error) {
+	raw, err := do(ctx, "queryAsyncJobResult", map[string]string{"jobid": jobID})
+	if err != nil {
+		return nil, errors.Errorf("querying async job %s: %w", jobID, err)
+	}
+
+	body, err := UnwrapResponse(raw)
+	if err != nil {
+		return nil, errors.Errorf("unwrapping async job %s: %w", jobID, err)
+	}
+
+	var job AsyncJob
+	if err := json.Unmarshal(body, &job); err != nil {
+		return nil, errors.Errorf("unmarshalling async job %s: %w", jobID, err)
+	}
+
+	if job.JobID == "" {
+		job.JobID = jobID
+	}
+
+	return &job, nil
+}
+
+// WaitForAsyncJob polls queryAsyncJobResult with exponential backoff until the
+// job finishes, the timeout passes or ctx is cancelled. A failed job is
+// returned alongside a *JobFailedError and a timed out job alongside a
+// *JobTimeoutError.
+func WaitForAsyncJob(ctx context.Context, do RequestFunc, jobID string, opts WaitOptions) (*AsyncJob, error) {
+	logger := zerolog.Ctx(ctx).With().Str("jobid", jobID).Logger()
+
+	opts = opts.withDefaults()
+
+	if opts.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.Timeout)
+		defer cancel()
+	}
+
+	interval := opts.InitialInterval
+	start := time.Now()
+	last := &AsyncJob{JobID: jobID}
+
+	for {
+		job, err := QueryAsyncJob(ctx, do, jobID)
+		if err != nil {
+			if ctx.Err() != nil {
+				return last, waitDoneError(ctx, last, opts.Timeout)
+			}
+			return nil, err
+		}
+
+		last = job
+
+		logger.Debug().Int("jobstatus", job.JobStatus).Int("jobprocstatus", job.JobProcStatus).Msg("Polled async job")
+
+		if job.Done() {
+			if job.JobStatus == JobStatusFailed {
+				return job, errors.WithStack(jobFailedError(job))
+			}
+			return job, nil
+		}
+
+		if opts.OnPoll != nil {
+			opts.OnPoll(job, time.Since(start))
+		}
+
+		select {
+		case <-ctx.Done():
+			return job, waitDoneError(ctx, job, opts.Timeout)
+		case <-time.After(interval):
+		}
+
+		interval *= 2
+		if interval > opts.MaxInterval {
+			interval = opts.MaxInterval
+		}
+	}
+}
+
+// waitDoneError explains why waiting stopped once ctx is done
+func waitDoneError(ctx context.Context, job *AsyncJob, timeout time.Duration) error {
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return errors.WithStack(&JobTimeoutError{Job: job, Timeout: timeout})
+	}
+	return errors.Errorf("waiting for async job %s: %w", job.JobID, ctx.Err())
+}
+
+func jobFailedError(job *AsyncJob) *JobFailedError {
+	ferr := &JobFailedError{
+		JobID:         job.JobID,
+		Cmd:           job.Cmd,
+		JobResultCode: job.JobResultCode,
+	}
+
+	var result struct {
+		ErrorCode int    `json:"errorcode"`
+		ErrorText string `json:"errortext"`
+	}
+	if err := json.Unmarshal(job.JobResult, &result); err == nil {
+		ferr.ErrorCode = result.ErrorCode
+		ferr.ErrorText = result.ErrorText
+	}
+
+	ferr.Kind = classifyError(ferr.ErrorCode, ferr.ErrorText)
+	ferr.Hint = errorHint(ferr.Kind, errorParam(ferr.ErrorText))
+
+	return ferr
+}
+
+// JobIDFromResponse returns the jobid of an async API response, if it has one
+func JobIDFromResponse(raw json.RawMessage) (string, bool) {
+	body, err := UnwrapResponse(raw)
+	if err != nil {
+		return "", false
+	}
+
+	var res struct {
+		JobID string `json:"jobid"`
+	}
+	if err := json.Unmarshal(body, &res); err != nil || res.JobID == "" {
+		return "", false
+	}
+
+	return res.JobID, true
+}
+
+// UnwrapResponse strips the {"<command>response": {...}} envelope CloudStack
+// puts around every response
+func UnwrapResponse(raw json.RawMessage) (json.RawMessage, error) {
+	var envelope map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &envelope); err != nil {
+		return nil, errors.Errorf("unmarshalling response envelope: %w", err)
+	}
+
+	for k, v := range envelope {
+		if strings.HasSuffix(k, "response") {
+			return v, nil
+		}
+	}
+
+	return nil, errors.New("response envelope not found")
+}
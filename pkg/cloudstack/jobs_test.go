@@ -0,0 +1,122 @@
+package cloudstack_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/walteh/cloudstack-mcp/pkg/cloudstack"
+	errors "gitlab.com/tozd/go/errors"
+)
+
+// jobResponses returns a RequestFunc answering queryAsyncJobResult with the
+// given job bodies in turn, repeating the last one, and recording when it was called
+func jobResponses(bodies ...string) (cloudstack.RequestFunc, func() []time.Time) {
+	var mu sync.Mutex
+	calls := []time.Time{}
+
+	do := func(ctx context.Context, command string, params map[string]string) (json.RawMessage, error) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		if command != "queryAsyncJobResult" || params["jobid"] != "job1" {
+			return nil, errors.Errorf("unexpected call %s %v", command, params)
+		}
+
+		body := bodies[min(len(calls), len(bodies)-1)]
+		calls = append(calls, time.Now())
+		return json.RawMessage(fmt.Sprintf(`{"queryasyncjobresultresponse":%s}`, body)), nil
+	}
+
+	return do, func() []time.Time {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]time.Time{}, calls...)
+	}
+}
+
+const pendingJob = `{"jobid":"job1","jobstatus":0,"jobprocstatus":1}`
+
+func Test_WaitForAsyncJob_Backoff(t *testing.T) {
+	do, calls := jobResponses(pendingJob, pendingJob, pendingJob, pendingJob, pendingJob, `{"jobid":"job1","jobstatus":1,"jobresult":{"virtualmachine":{"id":"vm1"}}}`)
+
+	polls := 0
+	job, err := cloudstack.WaitForAsyncJob(t.Context(), do, "job1", cloudstack.WaitOptions{
+		InitialInterval: 10 * time.Millisecond,
+		MaxInterval:     40 * time.Millisecond,
+		OnPoll: func(job *cloudstack.AsyncJob, elapsed time.Duration) {
+			polls++
+			assert.Equal(t, 1, job.JobProcStatus)
+		},
+	})
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"virtualmachine":{"id":"vm1"}}`, string(job.JobResult))
+	assert.Equal(t, 5, polls)
+
+	// the interval doubles after every poll until it reaches the maximum
+	times := calls()
+	require.Len(t, times, 6)
+	for i, want := range []time.Duration{10, 20, 40, 40, 40} {
+		assert.GreaterOrEqual(t, times[i+1].Sub(times[i]), want*time.Millisecond, "interval %d", i)
+	}
+}
+
+func Test_WaitForAsyncJob_Failed(t *testing.T) {
+	do, _ := jobResponses(`{"jobid":"job1","cmd":"org.apache.cloudstack.api.command.user.vm.DeployVMCmd","jobstatus":2,"jobresultcode":530,"jobresult":{"errorcode":533,"errortext":"Unable to create a deployment for VM instance"}}`)
+
+	job, err := cloudstack.WaitForAsyncJob(t.Context(), do, "job1", cloudstack.WaitOptions{})
+
+	var ferr *cloudstack.JobFailedError
+	require.True(t, errors.As(err, &ferr), "%v", err)
+	assert.Equal(t, "job1", ferr.JobID)
+	assert.Equal(t, 530, ferr.JobResultCode)
+	assert.Equal(t, 533, ferr.ErrorCode)
+	assert.Equal(t, "Unable to create a deployment for VM instance", ferr.ErrorText)
+	assert.Equal(t, cloudstack.ErrorKindInsufficientCapacity, ferr.Kind)
+	assert.NotEmpty(t, ferr.Hint)
+
+	require.NotNil(t, job)
+	assert.Equal(t, cloudstack.JobStatusFailed, job.JobStatus)
+}
+
+func Test_WaitForAsyncJob_Timeout(t *testing.T) {
+	do, _ := jobResponses(pendingJob)
+
+	job, err := cloudstack.WaitForAsyncJob(t.Context(), do, "job1", cloudstack.WaitOptions{
+		Timeout:         50 * time.Millisecond,
+		InitialInterval: 10 * time.Millisecond,
+	})
+
+	var terr *cloudstack.JobTimeoutError
+	require.True(t, errors.As(err, &terr), "%v", err)
+	assert.Equal(t, 50*time.Millisecond, terr.Timeout)
+	assert.Equal(t, "job1", terr.Job.JobID)
+	assert.Equal(t, cloudstack.JobStatusPending, job.JobStatus)
+}
+
+func Test_WaitForAsyncJob_Cancelled(t *testing.T) {
+	do, _ := jobResponses(pendingJob)
+
+	ctx, cancel := context.WithCancel(t.Context())
+	time.AfterFunc(30*time.Millisecond, cancel)
+
+	_, err := cloudstack.WaitForAsyncJob(ctx, do, "job1", cloudstack.WaitOptions{InitialInterval: 10 * time.Millisecond})
+	require.ErrorIs(t, err, context.Canceled)
+
+	var terr *cloudstack.JobTimeoutError
+	assert.False(t, errors.As(err, &terr))
+}
+
+func Test_WaitForAsyncJob_QueryError(t *testing.T) {
+	do := func(ctx context.Context, command string, params map[string]string) (json.RawMessage, error) {
+		return nil, errors.New("connection refused")
+	}
+
+	_, err := cloudstack.WaitForAsyncJob(t.Context(), do, "job1", cloudstack.WaitOptions{})
+	require.ErrorContains(t, err, "connection refused")
+}
@@ -0,0 +1,116 @@
+package cloudstack
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/rs/zerolog"
+	errors "gitlab.com/tozd/go/errors"
+)
+
+// Variables of a keys file, the same an --env-file sets the API keys with
+const (
+	keysFileAPIKey    = "CLOUDSTACK_API_KEY"
+	keysFileSecretKey = "CLOUDSTACK_SECRET_KEY"
+)
+
+// DefaultKeysPath is where the API keys of username at apiURL are kept
+// between runs, in the user config directory
+func DefaultKeysPath(apiURL, username string) (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", errors.Errorf("finding user config directory: %w", err)
+	}
+
+	sum := sha256.Sum256([]byte(apiURL + "\x00" + username))
+	return filepath.Join(dir, "cloudstack-mcp", "keys", hex.EncodeToString(sum[:8])+".env"), nil
+}
+
+// LoadKeys reads an API key pair saved by SaveKeys
+func LoadKeys(path string) (string, string, error) {
+	env, err := LoadEnvFile(path)
+	if err != nil {
+		return "", "", err
+	}
+
+	apiKey, secretKey := env[keysFileAPIKey], env[keysFileSecretKey]
+	if apiKey == "" || secretKey == "" {
+		return "", "", errors.Errorf("keys file %s does not set %s and %s", path, keysFileAPIKey, keysFileSecretKey)
+	}
+	return apiKey, secretKey, nil
+}
+
+// SaveKeys writes an API key pair to path, readable by the current user only
+func SaveKeys(path, apiKey, secretKey string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return errors.Errorf("creating keys directory: %w", err)
+	}
+
+	// written to a temporary file first, so the keys are never readable by others
+	// and a crash cannot leave a truncated file behind
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".keys-*")
+	if err != nil {
+		return errors.Errorf("creating keys file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := tmp.Chmod(0o600); err != nil {
+		tmp.Close()
+		return errors.Errorf("restricting keys file: %w", err)
+	}
+	if _, err := fmt.Fprintf(tmp, "%s=%s\n%s=%s\n", keysFileAPIKey, apiKey, keysFileSecretKey, secretKey); err != nil {
+		tmp.Close()
+		return errors.Errorf("writing keys file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return errors.Errorf("writing keys file: %w", err)
+	}
+
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return errors.Errorf("saving keys file: %w", err)
+	}
+	return nil
+}
+
+// BootstrapAPIKeys returns the API key pair calls as username are signed
+// with. The keys saved at path by an earlier run are used while CloudStack
+// accepts them; otherwise they come from GetAPICredentials and are saved at
+// path for the next run. An empty path keeps nothing between runs.
+func BootstrapAPIKeys(ctx context.Context, apiURL, username, password, path string, rotate bool) (string, string, error) {
+	logger := zerolog.Ctx(ctx)
+
+	if path != "" && !rotate {
+		apiKey, secretKey, err := LoadKeys(path)
+		switch {
+		case err == nil:
+			creds := Credentials{Mode: AuthModeAPIKey, APIKey: apiKey, SecretKey: secretKey}
+			_, err := doSignedCloudStackRequest(ctx, apiURL, "listCapabilities", creds, map[string]string{})
+			if err == nil {
+				logger.Info().Str("path", path).Msg("Using saved API keys")
+				return apiKey, secretKey, nil
+			}
+			logger.Warn().Err(err).Str("path", path).Msg("Saved API keys were rejected, logging in again")
+		case !errors.Is(err, fs.ErrNotExist):
+			logger.Warn().Err(err).Str("path", path).Msg("Ignoring unreadable keys file")
+		}
+	}
+
+	apiKey, secretKey, err := GetAPICredentials(ctx, apiURL, username, password, rotate)
+	if err != nil {
+		return "", "", err
+	}
+
+	if path != "" {
+		if err := SaveKeys(path, apiKey, secretKey); err != nil {
+			return "", "", err
+		}
+		logger.Info().Str("path", path).Msg("Saved API keys")
+	}
+
+	return apiKey, secretKey, nil
+}
@@ -0,0 +1,130 @@
+package cloudstack_test
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/walteh/cloudstack-mcp/pkg/cloudstack"
+)
+
+// keysStub is a CloudStack endpoint with a single user whose API keys can be
+// read, registered and used to sign calls
+type keysStub struct {
+	*httptest.Server
+
+	mu        sync.Mutex
+	apiKey    string
+	secretKey string
+	commands  []string
+}
+
+func newKeysStub(t *testing.T, apiKey, secretKey string) *keysStub {
+	cs := &keysStub{apiKey: apiKey, secretKey: secretKey}
+
+	cs.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cs.mu.Lock()
+		defer cs.mu.Unlock()
+
+		q := r.URL.Query()
+		cs.commands = append(cs.commands, q.Get("command"))
+
+		switch q.Get("command") {
+		case "login":
+			fmt.Fprint(w, `{"loginresponse":{"userid":"u1","sessionkey":"sk"}}`)
+		case "getUserKeys":
+			fmt.Fprintf(w, `{"getuserkeysresponse":{"userkeys":{"apikey":%q,"secretkey":%q}}}`, cs.apiKey, cs.secretKey)
+		case "registerUserKeys":
+			cs.apiKey, cs.secretKey = fmt.Sprintf("key%d", len(cs.commands)), fmt.Sprintf("secret%d", len(cs.commands))
+			fmt.Fprintf(w, `{"registeruserkeysresponse":{"userkeys":{"apikey":%q,"secretkey":%q}}}`, cs.apiKey, cs.secretKey)
+		case "listCapabilities":
+			if q.Get("apiKey") != cs.apiKey {
+				w.WriteHeader(http.StatusUnauthorized)
+				fmt.Fprint(w, `{"listcapabilitiesresponse":{"errorcode":401,"errortext":"unable to verify user credentials"}}`)
+				return
+			}
+			fmt.Fprint(w, `{"listcapabilitiesresponse":{"capability":{}}}`)
+		}
+	}))
+	t.Cleanup(cs.Close)
+
+	return cs
+}
+
+// calls returns the commands called since the last calls
+func (cs *keysStub) calls() []string {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	commands := cs.commands
+	cs.commands = nil
+	return commands
+}
+
+func Test_BootstrapAPIKeys(t *testing.T) {
+	t.Run("existing keys are reused and saved", func(t *testing.T) {
+		cs := newKeysStub(t, "existing", "s3cr3t")
+		path := filepath.Join(t.TempDir(), "keys", "admin.env")
+
+		apiKey, secretKey, err := cloudstack.BootstrapAPIKeys(t.Context(), cs.URL, "admin", "password", path, false)
+		require.NoError(t, err)
+		assert.Equal(t, "existing", apiKey)
+		assert.Equal(t, "s3cr3t", secretKey)
+		assert.Equal(t, []string{"login", "getUserKeys"}, cs.calls())
+
+		info, err := os.Stat(path)
+		require.NoError(t, err)
+		assert.Equal(t, os.FileMode(0o600), info.Mode().Perm())
+
+		// the next run only checks the saved keys still work
+		apiKey, _, err = cloudstack.BootstrapAPIKeys(t.Context(), cs.URL, "admin", "password", path, false)
+		require.NoError(t, err)
+		assert.Equal(t, "existing", apiKey)
+		assert.Equal(t, []string{"listCapabilities"}, cs.calls())
+	})
+
+	t.Run("keys are registered when there are none", func(t *testing.T) {
+		cs := newKeysStub(t, "", "")
+
+		apiKey, secretKey, err := cloudstack.BootstrapAPIKeys(t.Context(), cs.URL, "admin", "password", "", false)
+		require.NoError(t, err)
+		assert.Equal(t, "key3", apiKey)
+		assert.Equal(t, "secret3", secretKey)
+		assert.Equal(t, []string{"login", "getUserKeys", "registerUserKeys"}, cs.calls())
+	})
+
+	t.Run("rejected saved keys are replaced", func(t *testing.T) {
+		cs := newKeysStub(t, "current", "s3cr3t")
+		path := filepath.Join(t.TempDir(), "admin.env")
+		require.NoError(t, cloudstack.SaveKeys(path, "stale", "old"))
+
+		apiKey, _, err := cloudstack.BootstrapAPIKeys(t.Context(), cs.URL, "admin", "password", path, false)
+		require.NoError(t, err)
+		assert.Equal(t, "current", apiKey)
+		assert.Equal(t, []string{"listCapabilities", "login", "getUserKeys"}, cs.calls())
+
+		saved, _, err := cloudstack.LoadKeys(path)
+		require.NoError(t, err)
+		assert.Equal(t, "current", saved)
+	})
+
+	t.Run("rotate registers new keys", func(t *testing.T) {
+		cs := newKeysStub(t, "existing", "s3cr3t")
+		path := filepath.Join(t.TempDir(), "admin.env")
+		require.NoError(t, cloudstack.SaveKeys(path, "existing", "s3cr3t"))
+
+		apiKey, _, err := cloudstack.BootstrapAPIKeys(t.Context(), cs.URL, "admin", "password", path, true)
+		require.NoError(t, err)
+		assert.Equal(t, "key2", apiKey)
+		assert.Equal(t, []string{"login", "registerUserKeys"}, cs.calls())
+
+		saved, _, err := cloudstack.LoadKeys(path)
+		require.NoError(t, err)
+		assert.Equal(t, "key2", saved)
+	})
+}
@@ -0,0 +1,167 @@
+package cloudstack
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	csgo "github.com/apache/cloudstack-go/v2/cloudstack"
+	errors "gitlab.com/tozd/go/errors"
+)
+
+// EncodeParams converts decoded JSON arguments into CloudStack query parameters,
+// using the parameter types from listApis. Map parameters are sent in CloudStack's
+// indexed form, e.g. tags[0].key=env&tags[0].value=prod, and list parameters
+// comma separated. Arguments that api does not declare are encoded by their JSON type.
+func EncodeParams(api *csgo.Api, args map[string]any) (map[string]string, error) {
+	types := map[string]string{}
+	if api != nil {
+		for _, param := range api.Params {
+			types[strings.ToLower(param.Name)] = param.Type
+		}
+	}
+
+	params := map[string]string{}
+
+	for _, name := range sortedArgNames(args) {
+		value := args[name]
+		if value == nil {
+			continue
+		}
+
+		var err error
+		switch types[strings.ToLower(name)] {
+		case "map", "object":
+			err = encodeMapParam(params, name, value)
+		case "list", "array":
+			err = encodeListParam(params, name, value)
+		default:
+			err = encodeUntypedParam(params, name, value)
+		}
+		if err != nil {
+			return nil, errors.Errorf("encoding parameter %s: %w", name, err)
+		}
+	}
+
+	return params, nil
+}
+
+// encodeMapParam encodes a list of objects, or a single object, as name[i].key=value
+func encodeMapParam(params map[string]string, name string, value any) error {
+	switch v := value.(type) {
+	case map[string]any:
+		return encodeMapEntry(params, name, 0, v)
+	case []any:
+		for i, item := range v {
+			entry, ok := item.(map[string]any)
+			if !ok {
+				return errors.Errorf("item %d is a %T, not an object", i, item)
+			}
+			if err := encodeMapEntry(params, name, i, entry); err != nil {
+				return err
+			}
+		}
+		return nil
+	case string:
+		// already encoded by the caller, e.g. as a JSON string; pass it through
+		params[name] = v
+		return nil
+	default:
+		return errors.Errorf("expected a list of objects, got %T", value)
+	}
+}
+
+func encodeMapEntry(params map[string]string, name string, index int, entry map[string]any) error {
+	for _, key := range sortedArgNames(entry) {
+		if entry[key] == nil {
+			continue
+		}
+		str, err := scalarString(entry[key])
+		if err != nil {
+			return errors.Errorf("item %d key %s: %w", index, key, err)
+		}
+		params[fmt.Sprintf("%s[%d].%s", name, index, key)] = str
+	}
+	return nil
+}
+
+// encodeListParam encodes a list of scalars comma separated
+func encodeListParam(params map[string]string, name string, value any) error {
+	items, ok := value.([]any)
+	if !ok {
+		str, err := scalarString(value)
+		if err != nil {
+			return err
+		}
+		params[name] = str
+		return nil
+	}
+
+	strs := make([]string, 0, len(items))
+	for i, item := range items {
+		str, err := scalarString(item)
+		if err != nil {
+			return errors.Errorf("item %d: %w", i, err)
+		}
+		strs = append(strs, str)
+	}
+	params[name] = strings.Join(strs, ",")
+	return nil
+}
+
+// encodeUntypedParam encodes a parameter that listApis gives no container type
+// for: objects and lists of objects as maps, other lists comma separated
+func encodeUntypedParam(params map[string]string, name string, value any) error {
+	switch v := value.(type) {
+	case map[string]any:
+		return encodeMapParam(params, name, v)
+	case []any:
+		for _, item := range v {
+			if _, ok := item.(map[string]any); ok {
+				return encodeMapParam(params, name, v)
+			}
+		}
+		return encodeListParam(params, name, v)
+	default:
+		str, err := scalarString(v)
+		if err != nil {
+			return err
+		}
+		params[name] = str
+		return nil
+	}
+}
+
+// scalarString formats a JSON scalar the way CloudStack expects it
+func scalarString(value any) (string, error) {
+	switch v := value.(type) {
+	case string:
+		return v, nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case float64:
+		// 'f' keeps large ids and sizes out of exponent notation
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	case json.Number:
+		return v.String(), nil
+	case int:
+		return strconv.Itoa(v), nil
+	case int64:
+		return strconv.FormatInt(v, 10), nil
+	case []any, map[string]any:
+		return "", errors.Errorf("expected a scalar, got %T", value)
+	default:
+		return fmt.Sprintf("%v", v), nil
+	}
+}
+
+func sortedArgNames(args map[string]any) []string {
+	names := make([]string, 0, len(args))
+	for name := range args {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,132 @@
+package cloudstack_test
+
+import (
+	"testing"
+
+	csgo "github.com/apache/cloudstack-go/v2/cloudstack"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/walteh/cloudstack-mcp/pkg/cloudstack"
+)
+
+func Test_EncodeParams(t *testing.T) {
+	createTags := &csgo.Api{Name: "createTags", Params: []csgo.ApiParams{
+		{Name: "resourceids", Type: "list"},
+		{Name: "resourcetype", Type: "string"},
+		{Name: "tags", Type: "map"},
+	}}
+	deployVirtualMachine := &csgo.Api{Name: "deployVirtualMachine", Params: []csgo.ApiParams{
+		{Name: "zoneid", Type: "uuid"},
+		{Name: "rootdisksize", Type: "long"},
+		{Name: "startvm", Type: "boolean"},
+		{Name: "networkids", Type: "list"},
+		{Name: "details", Type: "map"},
+	}}
+	createNetworkOffering := &csgo.Api{Name: "createNetworkOffering", Params: []csgo.ApiParams{
+		{Name: "supportedservices", Type: "list"},
+		{Name: "serviceproviderlist", Type: "map"},
+	}}
+
+	tests := []struct {
+		name    string
+		api     *csgo.Api
+		args    map[string]any
+		want    map[string]string
+		wantErr bool
+	}{
+		{
+			name: "createTags key value pairs",
+			api:  createTags,
+			args: map[string]any{
+				"resourceids":  []any{"a", "b"},
+				"resourcetype": "UserVm",
+				"tags": []any{
+					map[string]any{"key": "env", "value": "prod"},
+					map[string]any{"key": "team", "value": "infra"},
+				},
+			},
+			want: map[string]string{
+				"resourceids":   "a,b",
+				"resourcetype":  "UserVm",
+				"tags[0].key":   "env",
+				"tags[0].value": "prod",
+				"tags[1].key":   "team",
+				"tags[1].value": "infra",
+			},
+		},
+		{
+			name: "deployVirtualMachine details as a single object",
+			api:  deployVirtualMachine,
+			args: map[string]any{
+				"zoneid":       "z1",
+				"rootdisksize": float64(2048000),
+				"startvm":      false,
+				"networkids":   []any{"n1"},
+				"details":      map[string]any{"cpuNumber": float64(2), "memory": "4096"},
+			},
+			want: map[string]string{
+				"zoneid":               "z1",
+				"rootdisksize":         "2048000",
+				"startvm":              "false",
+				"networkids":           "n1",
+				"details[0].cpuNumber": "2",
+				"details[0].memory":    "4096",
+			},
+		},
+		{
+			name: "createNetworkOffering service providers",
+			api:  createNetworkOffering,
+			args: map[string]any{
+				"supportedservices": []any{"Dhcp", "Dns"},
+				"serviceproviderlist": []any{
+					map[string]any{"service": "Dhcp", "provider": "VirtualRouter"},
+					map[string]any{"service": "Dns", "provider": "VirtualRouter"},
+				},
+			},
+			want: map[string]string{
+				"supportedservices":               "Dhcp,Dns",
+				"serviceproviderlist[0].provider": "VirtualRouter",
+				"serviceproviderlist[0].service":  "Dhcp",
+				"serviceproviderlist[1].provider": "VirtualRouter",
+				"serviceproviderlist[1].service":  "Dns",
+			},
+		},
+		{
+			name: "undeclared parameters are encoded by their JSON type",
+			api:  nil,
+			args: map[string]any{
+				"ids":  []any{"a", "b"},
+				"tags": []any{map[string]any{"key": "k", "value": "v"}},
+				"skip": nil,
+			},
+			want: map[string]string{
+				"ids":           "a,b",
+				"tags[0].key":   "k",
+				"tags[0].value": "v",
+			},
+		},
+		{
+			name:    "map item that is not an object",
+			api:     createTags,
+			args:    map[string]any{"tags": []any{"env=prod"}},
+			wantErr: true,
+		},
+		{
+			name:    "list of objects for a list parameter",
+			api:     createTags,
+			args:    map[string]any{"resourceids": []any{map[string]any{"id": "a"}}},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := cloudstack.EncodeParams(tt.api, tt.args)
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
@@ -0,0 +1,200 @@
+package cloudstack
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	errors "gitlab.com/tozd/go/errors"
+)
+
+// Profile is a named CloudStack endpoint and the user calls to it are made as
+type Profile struct {
+	Name        string
+	APIURL      string
+	Credentials Credentials
+}
+
+// Validate checks that the profile names an endpoint and has usable credentials
+func (p Profile) Validate() error {
+	if p.APIURL == "" {
+		return errors.Errorf("profile %s: url is required", p.Name)
+	}
+	if err := p.Credentials.Validate(); err != nil {
+		return errors.Errorf("profile %s: %w", p.Name, err)
+	}
+	return nil
+}
+
+// Profiles are the profiles of a config file, in the order they are defined
+type Profiles struct {
+	// Default is the profile used when none is chosen
+	Default  string
+	Profiles []Profile
+}
+
+// Get returns the named profile
+func (p *Profiles) Get(name string) (Profile, bool) {
+	for _, profile := range p.Profiles {
+		if profile.Name == name {
+			return profile, true
+		}
+	}
+	return Profile{}, false
+}
+
+// Names returns the names of the profiles
+func (p *Profiles) Names() []string {
+	names := make([]string, 0, len(p.Profiles))
+	for _, profile := range p.Profiles {
+		names = append(names, profile.Name)
+	}
+	return names
+}
+
+// DefaultProfilesPath is where CloudMonkey keeps its config, ~/.cmk/config
+func DefaultProfilesPath() (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", errors.Errorf("finding home directory: %w", err)
+	}
+	return filepath.Join(home, ".cmk", "config"), nil
+}
+
+// LoadProfiles reads a profiles config file
+func LoadProfiles(path string) (*Profiles, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, errors.Errorf("opening profiles: %w", err)
+	}
+	defer f.Close()
+
+	profiles, err := ParseProfiles(f)
+	if err != nil {
+		return nil, errors.Errorf("reading profiles from %s: %w", path, err)
+	}
+
+	return profiles, nil
+}
+
+// ParseProfiles parses a config in CloudMonkey's INI format: core settings
+// naming the default profile, and a section per profile, e.g.
+//
+//	profile = prod
+//	asyncblock = true
+//
+//	[prod]
+//	url = https://cloud.example.com/client/api
+//	apikey = ...
+//	secretkey = ...
+//
+// The core settings can also be in a [core] section, as the older Python
+// CloudMonkey writes them. A profile with an apikey and secretkey uses API key
+// authentication, any other logs in with its username, password and domain.
+// Settings CloudMonkey uses that do not apply here, like output or timeout,
+// are ignored.
+func ParseProfiles(r io.Reader) (*Profiles, error) {
+	profiles := &Profiles{}
+
+	// cmk writes its core settings at the top, before the first section
+	section := "core"
+	sections := map[string]map[string]string{section: {}}
+	order := []string{section}
+
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") || strings.HasPrefix(text, ";") {
+			continue
+		}
+
+		if strings.HasPrefix(text, "[") && strings.HasSuffix(text, "]") {
+			section = strings.TrimSpace(text[1 : len(text)-1])
+			if _, ok := sections[section]; !ok {
+				sections[section] = map[string]string{}
+				order = append(order, section)
+			}
+			continue
+		}
+
+		key, value, ok := strings.Cut(text, "=")
+		if !ok {
+			return nil, errors.Errorf("line %d: expected key = value", line)
+		}
+		sections[section][strings.ToLower(strings.TrimSpace(key))] = strings.TrimSpace(value)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, errors.Errorf("reading profiles: %w", err)
+	}
+
+	for _, name := range order {
+		if name == "core" {
+			profiles.Default = sections[name]["profile"]
+			continue
+		}
+
+		profile, err := parseProfile(name, sections[name])
+		if err != nil {
+			return nil, err
+		}
+		profiles.Profiles = append(profiles.Profiles, profile)
+	}
+
+	if len(profiles.Profiles) == 0 {
+		return nil, errors.New("no profiles defined")
+	}
+
+	if profiles.Default == "" {
+		profiles.Default = profiles.Profiles[0].Name
+	}
+	if _, ok := profiles.Get(profiles.Default); !ok {
+		return nil, errors.Errorf("default profile %s is not defined", profiles.Default)
+	}
+
+	return profiles, nil
+}
+
+func parseProfile(name string, settings map[string]string) (Profile, error) {
+	profile := Profile{
+		Name:   name,
+		APIURL: settings["url"],
+		Credentials: Credentials{
+			Mode:      AuthModePassword,
+			Username:  settings["username"],
+			Password:  settings["password"],
+			Domain:    settings["domain"],
+			APIKey:    settings["apikey"],
+			SecretKey: settings["secretkey"],
+		},
+	}
+
+	if profile.Credentials.APIKey != "" && profile.Credentials.SecretKey != "" {
+		profile.Credentials.Mode = AuthModeAPIKey
+	}
+
+	if v := settings["signatureversion"]; v != "" {
+		version, err := strconv.Atoi(v)
+		if err != nil {
+			return Profile{}, errors.Errorf("profile %s: signatureversion: %w", name, err)
+		}
+		profile.Credentials.SignatureVersion = version
+	}
+
+	if v := settings["expires"]; v != "" {
+		seconds, err := strconv.Atoi(v)
+		if err != nil {
+			return Profile{}, errors.Errorf("profile %s: expires must be a number of seconds: %w", name, err)
+		}
+		profile.Credentials.Expires = time.Duration(seconds) * time.Second
+	}
+
+	if err := profile.Validate(); err != nil {
+		return Profile{}, err
+	}
+
+	return profile, nil
+}
@@ -0,0 +1,135 @@
+package cloudstack_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/walteh/cloudstack-mcp/pkg/cloudstack"
+)
+
+func Test_ParseProfiles(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  string
+		want    *cloudstack.Profiles
+		wantErr string
+	}{
+		{
+			name: "cloudmonkey config",
+			config: `
+[core]
+asyncblock = true
+output = json
+profile = prod
+
+; the lab cloud
+[lab]
+url = http://localhost:8080/client/api
+username = admin
+password = password
+domain = /
+apikey =
+secretkey =
+timeout = 1800
+
+[prod]
+url = https://cloud.example.com/client/api
+apikey = key
+secretkey = secret
+signatureversion = 3
+expires = 600
+`,
+			want: &cloudstack.Profiles{
+				Default: "prod",
+				Profiles: []cloudstack.Profile{
+					{Name: "lab", APIURL: "http://localhost:8080/client/api", Credentials: cloudstack.Credentials{Mode: cloudstack.AuthModePassword, Username: "admin", Password: "password", Domain: "/"}},
+					{Name: "prod", APIURL: "https://cloud.example.com/client/api", Credentials: cloudstack.Credentials{Mode: cloudstack.AuthModeAPIKey, APIKey: "key", SecretKey: "secret", SignatureVersion: 3, Expires: 10 * time.Minute}},
+				},
+			},
+		},
+		{
+			name: "cmk config with top level core settings",
+			config: `prompt = 🐵
+asyncblock = true
+timeout = 1800
+output = json
+verifycert = true
+profile = localcloud
+autocompletion = true
+
+[localcloud]
+url = http://localhost:8080/client/api
+username = admin
+password = password
+domain = /
+apikey =
+secretkey =
+
+[prod]
+url = https://cloud.example.com/client/api
+apikey = key
+secretkey = secret
+`,
+			want: &cloudstack.Profiles{
+				Default: "localcloud",
+				Profiles: []cloudstack.Profile{
+					{Name: "localcloud", APIURL: "http://localhost:8080/client/api", Credentials: cloudstack.Credentials{Mode: cloudstack.AuthModePassword, Username: "admin", Password: "password", Domain: "/"}},
+					{Name: "prod", APIURL: "https://cloud.example.com/client/api", Credentials: cloudstack.Credentials{Mode: cloudstack.AuthModeAPIKey, APIKey: "key", SecretKey: "secret"}},
+				},
+			},
+		},
+		{
+			name:   "first profile is the default without core",
+			config: "[a]\nurl = http://a/client/api\napikey = k\nsecretkey = s\n\n[b]\nurl = http://b/client/api\napikey = k\nsecretkey = s\n",
+			want: &cloudstack.Profiles{
+				Default: "a",
+				Profiles: []cloudstack.Profile{
+					{Name: "a", APIURL: "http://a/client/api", Credentials: cloudstack.Credentials{Mode: cloudstack.AuthModeAPIKey, APIKey: "k", SecretKey: "s"}},
+					{Name: "b", APIURL: "http://b/client/api", Credentials: cloudstack.Credentials{Mode: cloudstack.AuthModeAPIKey, APIKey: "k", SecretKey: "s"}},
+				},
+			},
+		},
+		{
+			name:    "missing url",
+			config:  "[a]\nusername = admin\npassword = password\n",
+			wantErr: "profile a: url is required",
+		},
+		{
+			name:    "missing credentials",
+			config:  "[a]\nurl = http://a/client/api\nusername = admin\n",
+			wantErr: "profile a: username and password are required",
+		},
+		{
+			name:    "unknown default",
+			config:  "[core]\nprofile = b\n\n[a]\nurl = http://a/client/api\napikey = k\nsecretkey = s\n",
+			wantErr: "default profile b is not defined",
+		},
+		{
+			name:    "no profiles",
+			config:  "[core]\noutput = json\n",
+			wantErr: "no profiles defined",
+		},
+		{
+			name:    "line without a value",
+			config:  "[a]\nurl\n",
+			wantErr: "line 2: expected key = value",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := cloudstack.ParseProfiles(strings.NewReader(tt.config))
+			if tt.wantErr != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tt.wantErr)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
@@ -14,13 +14,24 @@ import (
 
 	"github.com/apache/cloudstack-go/v2/cloudstack"
 	"github.com/rs/zerolog"
+	"github.com/walteh/cloudstack-mcp/pkg/redact"
 	errors "gitlab.com/tozd/go/errors"
 	"moul.io/http2curl"
 )
 
-func DoTypedCloudStackRequest[T any](ctx context.Context, apiURL string, toolName string, username, password string, params map[string]string) (*T, error) {
+// ResponseError is returned when CloudStack answers a request with a non-200 status code
+type ResponseError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *ResponseError) Error() string {
+	return fmt.Sprintf("HTTP request failed: %d: %s", e.StatusCode, e.Body)
+}
 
-	raw, err := DoRawCloudStackRequest(ctx, apiURL, toolName, username, password, params)
+func DoTypedCloudStackRequest[T any](ctx context.Context, apiURL string, toolName string, creds Credentials, params map[string]string) (*T, error) {
+
+	raw, err := DoRawCloudStackRequest(ctx, apiURL, toolName, creds, params)
 	if err != nil {
 		return nil, errors.Errorf("error calling %s: %w", toolName, err)
 	}
@@ -29,45 +40,33 @@ func DoTypedCloudStackRequest[T any](ctx context.Context, apiURL string, toolNam
 
 }
 
-func DoRawCloudStackRequest(ctx context.Context, apiURL string, toolName string, username, password string, params map[string]string) (json.RawMessage, error) {
-	// Convert the params map to url.Values which is what the CloudStack client expects
-
-	jar, err := cookiejar.New(nil)
-	if err != nil {
-		return nil, errors.Errorf("failed to create cookie jar: %w", err)
+// DoRawCloudStackRequest calls the given command, authenticating according to
+// creds.Mode. Credentials set on ctx with WithCredentials take precedence.
+func DoRawCloudStackRequest(ctx context.Context, apiURL string, toolName string, creds Credentials, params map[string]string) (json.RawMessage, error) {
+	if c, ok := CredentialsFromContext(ctx); ok {
+		creds = c
 	}
 
-	httpClient := &http.Client{
-		Timeout: time.Second * 10,
-		Jar:     jar,
+	if creds.Mode == AuthModeAPIKey {
+		return doSignedCloudStackRequest(ctx, apiURL, toolName, creds, params)
 	}
 
-	// this creates a JSESSIONID cookie that needs to be used for all authenticated requests
-	lres, err := makeTypedCloudStackRequest[cloudstack.LoginResponse](ctx, httpClient, apiURL, url.Values{"command": {"login"}, "username": {username}, "password": {password}})
+	sess, err := DefaultSessionManager.DomainSession(apiURL, creds.Domain, creds.Username, creds.Password)
 	if err != nil {
-		return nil, errors.Errorf("logging in: %w", err)
-	}
-
-	values := url.Values{}
-	values.Set("command", toolName)
-	for k, v := range params {
-		values.Set(k, v)
-	}
-
-	values.Set("sessionkey", lres.Sessionkey)
-
-	mres, err := makeRawCloudStackRequest(ctx, httpClient, apiURL, values)
-	if err != nil {
-		return nil, errors.Errorf("error calling %s: %w", toolName, err)
+		return nil, errors.Errorf("getting session: %w", err)
 	}
 
-	return mres, nil
+	return sess.Do(ctx, toolName, params)
 }
 
-func GetAPICredentials(ctx context.Context, apiURL, username, password string) (string, string, error) {
+// GetAPICredentials logs in as username and returns the API key pair of the
+// user. Keys the user already has are returned as they are, since registering
+// keys replaces them for every other client of the user; new keys are only
+// registered when the user has none, or when rotate is set.
+func GetAPICredentials(ctx context.Context, apiURL, username, password string, rotate bool) (string, string, error) {
 	logger := zerolog.Ctx(ctx)
 
-	logger.Info().Msgf("Logging in with username: %s, password: %s", username, password)
+	logger.Info().Str("username", username).Msg("Logging in to get API keys")
 
 	jar, err := cookiejar.New(nil)
 	if err != nil {
@@ -85,13 +84,59 @@ func GetAPICredentials(ctx context.Context, apiURL, username, password string) (
 		return "", "", errors.Errorf("logging in: %w", err)
 	}
 
-	cres, err := makeTypedCloudStackRequest[cloudstack.RegisterUserKeysResponse](ctx, httpClient, apiURL, url.Values{"command": {"registerUserKeys"}, "id": {lres.Userid}, "sessionkey": {lres.Sessionkey}})
+	if !rotate {
+		raw, err := makeRawCloudStackRequest(ctx, httpClient, apiURL, url.Values{"command": {"getUserKeys"}, "id": {lres.Userid}, "sessionkey": {lres.Sessionkey}})
+		if err != nil {
+			return "", "", errors.Errorf("getting user keys: %w", err)
+		}
+
+		apiKey, secretKey, err := userKeys(raw)
+		if err != nil {
+			return "", "", errors.Errorf("getting user keys: %w", err)
+		}
+		if apiKey != "" && secretKey != "" {
+			logger.Info().Msg("Using the existing API keys of the user")
+			return apiKey, secretKey, nil
+		}
+	}
+
+	logger.Info().Bool("rotate", rotate).Msg("Registering new API keys for the user")
+
+	raw, err := makeRawCloudStackRequest(ctx, httpClient, apiURL, url.Values{"command": {"registerUserKeys"}, "id": {lres.Userid}, "sessionkey": {lres.Sessionkey}})
 	if err != nil {
 		return "", "", errors.Errorf("registering user keys: %w", err)
 	}
 
-	// Return existing API keys
-	return cres.Apikey, cres.Secretkey, nil
+	apiKey, secretKey, err := userKeys(raw)
+	if err != nil {
+		return "", "", errors.Errorf("registering user keys: %w", err)
+	}
+	if apiKey == "" || secretKey == "" {
+		return "", "", errors.New("registering user keys: no keys in the response")
+	}
+
+	return apiKey, secretKey, nil
+}
+
+// userKeys returns the keys of a getUserKeys or registerUserKeys response,
+// empty when the user has none
+func userKeys(raw json.RawMessage) (string, string, error) {
+	inner, err := UnwrapResponse(raw)
+	if err != nil {
+		return "", "", err
+	}
+
+	var resp struct {
+		UserKeys struct {
+			APIKey    string `json:"apikey"`
+			SecretKey string `json:"secretkey"`
+		} `json:"userkeys"`
+	}
+	if err := json.Unmarshal(inner, &resp); err != nil {
+		return "", "", errors.Errorf("unmarshalling user keys: %w", err)
+	}
+
+	return resp.UserKeys.APIKey, resp.UserKeys.SecretKey, nil
 }
 
 func extractTypeFromResponse[T any](ctx context.Context, raw json.RawMessage) (*T, error) {
@@ -147,9 +192,11 @@ func makeRawCloudStackRequest(ctx context.Context, client *http.Client, apiURL s
 
 	logger := zerolog.Ctx(ctx)
 
-	adminLoginURL := fmt.Sprintf("%s?response=json&%s", apiURL, params.Encode())
+	params.Set("response", "json")
+
+	requestURL := fmt.Sprintf("%s?%s", apiURL, params.Encode())
 
-	req, err := http.NewRequestWithContext(ctx, "POST", adminLoginURL, nil)
+	req, err := http.NewRequestWithContext(ctx, "POST", requestURL, nil)
 	if err != nil {
 		return nil, errors.Errorf("failed to create request: %w", err)
 	}
@@ -165,12 +212,15 @@ func makeRawCloudStackRequest(ctx context.Context, client *http.Client, apiURL s
 
 	// get the name of the type with a lower case full name no package
 
-	logger.Info().Msgf("curl: %s", curl.String())
-	logger.Info().Msgf("Making request to: %s", adminLoginURL)
+	// the query carries the signature, session key or password of the call
+	logger.Info().Msgf("curl: %s", redact.String(curl.String()))
+	logger.Info().Msgf("Making request to: %s", redact.String(requestURL))
 
 	// before cookies
-	for _, cookie := range client.Jar.Cookies(req.URL) {
-		logger.Info().Msgf("BEFORE Cookie: %s", cookie.String())
+	if client.Jar != nil {
+		for _, cookie := range client.Jar.Cookies(req.URL) {
+			logger.Info().Msgf("BEFORE Cookie: %s", redact.String(cookie.String()))
+		}
 	}
 
 	resp, err := client.Do(req)
@@ -182,7 +232,7 @@ func makeRawCloudStackRequest(ctx context.Context, client *http.Client, apiURL s
 	cookies := resp.Cookies()
 
 	for _, cookie := range cookies {
-		logger.Info().Msgf("AFTER Cookie: %s", cookie.String())
+		logger.Info().Msgf("AFTER Cookie: %s", redact.String(cookie.String()))
 	}
 
 	body, err := io.ReadAll(resp.Body)
@@ -191,11 +241,14 @@ func makeRawCloudStackRequest(ctx context.Context, client *http.Client, apiURL s
 	}
 
 	if resp.StatusCode != 200 {
-		return nil, errors.Errorf("HTTP request failed: %d: %s", resp.StatusCode, string(body))
+		if apiErr := parseAPIError(resp.StatusCode, body); apiErr != nil {
+			return nil, errors.WithStack(apiErr)
+		}
+		return nil, errors.WithStack(&ResponseError{StatusCode: resp.StatusCode, Body: string(body)})
 	}
 
 	logger.Info().Msgf("Response code: %d", resp.StatusCode)
-	logger.Trace().Msgf("Response body: %s", string(body))
+	logger.Trace().Msgf("Response body: %s", redact.String(string(body)))
 
 	return json.RawMessage(body), nil
 
@@ -0,0 +1,287 @@
+package cloudstack
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	csgo "github.com/apache/cloudstack-go/v2/cloudstack"
+	errors "gitlab.com/tozd/go/errors"
+)
+
+// DefaultResolveTTL is how long resolved names are cached when NewResolver is given no TTL
+const DefaultResolveTTL = 5 * time.Minute
+
+// maxResolveCandidates caps the similar names reported when nothing matches
+const maxResolveCandidates = 10
+
+var uuidPattern = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
+// listDefaults are the extra parameters some list APIs require to list anything
+var listDefaults = map[string]map[string]string{
+	"listTemplates": {"templatefilter": "all"},
+	"listIsos":      {"isofilter": "all"},
+}
+
+// IsUUID reports whether s has the form of a CloudStack uuid
+func IsUUID(s string) bool {
+	return uuidPattern.MatchString(s)
+}
+
+// HasParam reports whether api declares the parameter name
+func HasParam(api *csgo.Api, name string) bool {
+	for _, param := range api.Params {
+		if param.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// ListQuery returns params with what list needs to find any resource the
+// caller can see: the filters some list APIs require, and listall where it is
+// supported. Parameters already set in params are kept.
+func ListQuery(list *csgo.Api, params map[string]string) map[string]string {
+	query := map[string]string{}
+	for k, v := range listDefaults[list.Name] {
+		query[k] = v
+	}
+	if HasParam(list, "listall") {
+		query["listall"] = "true"
+	}
+	for k, v := range params {
+		query[k] = v
+	}
+	return query
+}
+
+// FindListApi returns the list API for a resource noun, trying the plural
+// forms CloudStack uses, e.g. listZones for zone and listVirtualMachines for
+// virtualmachine
+func FindListApi(apis map[string]*csgo.Api, noun string) *csgo.Api {
+	noun = strings.ToLower(noun)
+
+	candidates := []string{"list" + noun + "s", "list" + noun + "es", "list" + noun}
+	if strings.HasSuffix(noun, "y") {
+		candidates = append(candidates, "list"+strings.TrimSuffix(noun, "y")+"ies")
+	}
+
+	for _, candidate := range candidates {
+		for name, api := range apis {
+			if strings.EqualFold(name, candidate) {
+				return api
+			}
+		}
+	}
+
+	return nil
+}
+
+// NamedResource is a resource a name may refer to
+type NamedResource struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	Zone string `json:"zonename,omitempty"`
+}
+
+// ResolveError is returned when a name does not refer to exactly one resource.
+// Candidates are the resources that matched when the name is ambiguous, and
+// the resources with similar names when it matched none.
+type ResolveError struct {
+	Param      string          `json:"param"`
+	Name       string          `json:"name"`
+	ListApi    string          `json:"listapi"`
+	Ambiguous  bool            `json:"ambiguous"`
+	Candidates []NamedResource `json:"candidates"`
+}
+
+func (e *ResolveError) Error() string {
+	if e.Ambiguous {
+		ids := []string{}
+		for _, c := range e.Candidates {
+			ids = append(ids, c.ID)
+		}
+		return fmt.Sprintf("%s: %q matches %d resources of %s, use one of the ids %s", e.Param, e.Name, len(e.Candidates), e.ListApi, strings.Join(ids, ", "))
+	}
+	if len(e.Candidates) > 0 {
+		names := []string{}
+		for _, c := range e.Candidates {
+			names = append(names, c.Name)
+		}
+		return fmt.Sprintf("%s: nothing named %q found with %s, did you mean %s?", e.Param, e.Name, e.ListApi, strings.Join(names, ", "))
+	}
+	return fmt.Sprintf("%s: nothing named %q found with %s", e.Param, e.Name, e.ListApi)
+}
+
+// Resolver turns resource names into the ids CloudStack expects, looking
+// them up with the resource's list API and caching the result
+type Resolver struct {
+	do  RequestFunc
+	ttl time.Duration
+
+	mu    sync.Mutex
+	cache map[string]resolved
+}
+
+type resolved struct {
+	id      string
+	expires time.Time
+}
+
+// NewResolver returns a resolver that calls CloudStack through do
+func NewResolver(do RequestFunc, ttl time.Duration) *Resolver {
+	if ttl <= 0 {
+		ttl = DefaultResolveTTL
+	}
+	return &Resolver{
+		do:    do,
+		ttl:   ttl,
+		cache: map[string]resolved{},
+	}
+}
+
+// Resolve returns the id of the resource of list named name. A value that is
+// already a uuid is returned as is. scope narrows the lookup, e.g. to a zone,
+// for list APIs that take those parameters.
+func (r *Resolver) Resolve(ctx context.Context, list *csgo.Api, param, name string, scope map[string]string) (string, error) {
+	if IsUUID(name) {
+		return name, nil
+	}
+
+	params := map[string]string{}
+	for k, v := range scope {
+		if HasParam(list, k) {
+			params[k] = v
+		}
+	}
+
+	key := cacheKey(list.Name, params, name)
+
+	r.mu.Lock()
+	entry, ok := r.cache[key]
+	r.mu.Unlock()
+	if ok && time.Now().Before(entry.expires) {
+		return entry.id, nil
+	}
+
+	// list APIs without a name filter are searched by keyword, and filtered here
+	switch {
+	case HasParam(list, "name"):
+		params["name"] = name
+	case HasParam(list, "keyword"):
+		params["keyword"] = name
+	}
+
+	raw, err := r.do(ctx, list.Name, ListQuery(list, params))
+	if err != nil {
+		return "", errors.Errorf("resolving %s %q with %s: %w", param, name, list.Name, err)
+	}
+
+	items, err := namedResources(raw)
+	if err != nil {
+		return "", errors.Errorf("resolving %s %q with %s: %w", param, name, list.Name, err)
+	}
+
+	// CloudStack matches some names by prefix or substring, so only exact
+	// matches count, preferring those with the same case
+	matches := []NamedResource{}
+	for _, item := range items {
+		if item.Name == name {
+			matches = append(matches, item)
+		}
+	}
+	if len(matches) == 0 {
+		for _, item := range items {
+			if strings.EqualFold(item.Name, name) {
+				matches = append(matches, item)
+			}
+		}
+	}
+
+	switch len(matches) {
+	case 1:
+	case 0:
+		return "", errors.WithStack(&ResolveError{Param: param, Name: name, ListApi: list.Name, Candidates: similarResources(items, name)})
+	default:
+		return "", errors.WithStack(&ResolveError{Param: param, Name: name, ListApi: list.Name, Ambiguous: true, Candidates: matches})
+	}
+
+	r.mu.Lock()
+	r.cache[key] = resolved{id: matches[0].ID, expires: time.Now().Add(r.ttl)}
+	r.mu.Unlock()
+
+	return matches[0].ID, nil
+}
+
+// similarResources returns the items whose name contains name or is contained
+// in it, ignoring case and separators. Unfiltered list APIs return every
+// resource, which would bury the likely ones.
+func similarResources(items []NamedResource, name string) []NamedResource {
+	normalize := func(s string) string {
+		return strings.NewReplacer("_", "", "-", "", " ", "").Replace(strings.ToLower(s))
+	}
+
+	want := normalize(name)
+	similar := []NamedResource{}
+	for _, item := range items {
+		got := normalize(item.Name)
+		if got == "" || want == "" {
+			continue
+		}
+		if strings.Contains(got, want) || strings.Contains(want, got) {
+			similar = append(similar, item)
+		}
+		if len(similar) == maxResolveCandidates {
+			break
+		}
+	}
+
+	return similar
+}
+
+func cacheKey(command string, params map[string]string, name string) string {
+	keys := make([]string, 0, len(params))
+	for k := range params {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var sb strings.Builder
+	sb.WriteString(command)
+	for _, k := range keys {
+		fmt.Fprintf(&sb, "\x00%s=%s", k, params[k])
+	}
+	sb.WriteString("\x00" + name)
+	return sb.String()
+}
+
+// namedResources returns the items of a list response
+func namedResources(raw json.RawMessage) ([]NamedResource, error) {
+	body, err := UnwrapResponse(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(body, &fields); err != nil {
+		return nil, errors.Errorf("unmarshalling list response: %w", err)
+	}
+
+	items := []NamedResource{}
+	for key, value := range fields {
+		if key == "count" {
+			continue
+		}
+		var list []NamedResource
+		if err := json.Unmarshal(value, &list); err == nil {
+			items = append(items, list...)
+		}
+	}
+
+	return items, nil
+}
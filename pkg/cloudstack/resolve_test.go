@@ -0,0 +1,196 @@
+package cloudstack_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	csgo "github.com/apache/cloudstack-go/v2/cloudstack"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/walteh/cloudstack-mcp/pkg/cloudstack"
+	errors "gitlab.com/tozd/go/errors"
+)
+
+const (
+	zone1     = "11111111-1111-4111-8111-111111111111"
+	zone2     = "22222222-2222-4222-8222-222222222222"
+	template1 = "33333333-3333-4333-8333-333333333333"
+	template2 = "44444444-4444-4444-8444-444444444444"
+)
+
+// listResponses returns a RequestFunc answering list calls with the items in
+// bodies, a JSON array per command, and recording the params of every call
+func listResponses(bodies map[string]string) (cloudstack.RequestFunc, *[]map[string]string) {
+	calls := []map[string]string{}
+
+	do := func(ctx context.Context, command string, params map[string]string) (json.RawMessage, error) {
+		calls = append(calls, params)
+
+		body, ok := bodies[command]
+		if !ok {
+			return nil, errors.Errorf("unexpected call %s %v", command, params)
+		}
+		return json.RawMessage(fmt.Sprintf(`{"%sresponse":{"count":1,"item":%s}}`, command, body)), nil
+	}
+
+	return do, &calls
+}
+
+func Test_Resolver_Resolve(t *testing.T) {
+	listZones := &csgo.Api{Name: "listZones", Params: []csgo.ApiParams{{Name: "id"}, {Name: "name"}}}
+	listTemplates := &csgo.Api{Name: "listTemplates", Params: []csgo.ApiParams{{Name: "templatefilter"}, {Name: "name"}, {Name: "zoneid"}, {Name: "listall"}}}
+	listOsTypes := &csgo.Api{Name: "listOsTypes", Params: []csgo.ApiParams{{Name: "keyword"}}}
+
+	bodies := map[string]string{
+		"listZones":     fmt.Sprintf(`[{"id":%q,"name":"us-east-1"},{"id":%q,"name":"US-East-1b"}]`, zone1, zone2),
+		"listTemplates": fmt.Sprintf(`[{"id":%q,"name":"ubuntu-22","zonename":"us-east-1"},{"id":%q,"name":"ubuntu-22","zonename":"us-east-1b"},{"id":"x","name":"centos-9"}]`, template1, template2),
+		"listOsTypes":   `[{"id":"os1","name":"Ubuntu 22.04 (64-bit)"},{"id":"os2","name":"Ubuntu 22.04 (32-bit)"},{"id":"os3","name":"Windows 11"}]`,
+	}
+
+	tests := []struct {
+		name       string
+		list       *csgo.Api
+		value      string
+		scope      map[string]string
+		want       string
+		wantQuery  map[string]string
+		wantErr    *cloudstack.ResolveError
+		wantNoCall bool
+	}{
+		{
+			name:      "exact name",
+			list:      listZones,
+			value:     "us-east-1",
+			want:      zone1,
+			wantQuery: map[string]string{"name": "us-east-1"},
+		},
+		{
+			name:  "case insensitive name",
+			list:  listZones,
+			value: "us-east-1B",
+			want:  zone2,
+		},
+		{
+			name:       "uuid is kept",
+			list:       listZones,
+			value:      zone2,
+			want:       zone2,
+			wantNoCall: true,
+		},
+		{
+			name:      "list defaults and scope",
+			list:      listTemplates,
+			value:     "centos-9",
+			scope:     map[string]string{"zoneid": zone1, "account": "admin"},
+			want:      "x",
+			wantQuery: map[string]string{"name": "centos-9", "templatefilter": "all", "listall": "true", "zoneid": zone1},
+		},
+		{
+			name:  "ambiguous",
+			list:  listTemplates,
+			value: "ubuntu-22",
+			wantErr: &cloudstack.ResolveError{Param: "templateid", Name: "ubuntu-22", ListApi: "listTemplates", Ambiguous: true, Candidates: []cloudstack.NamedResource{
+				{ID: template1, Name: "ubuntu-22", Zone: "us-east-1"},
+				{ID: template2, Name: "ubuntu-22", Zone: "us-east-1b"},
+			}},
+		},
+		{
+			name:      "keyword search",
+			list:      listOsTypes,
+			value:     "Windows 11",
+			want:      "os3",
+			wantQuery: map[string]string{"keyword": "Windows 11"},
+		},
+		{
+			name:  "similar names only",
+			list:  listOsTypes,
+			value: "ubuntu 22.04",
+			wantErr: &cloudstack.ResolveError{Param: "ostypeid", Name: "ubuntu 22.04", ListApi: "listOsTypes", Candidates: []cloudstack.NamedResource{
+				{ID: "os1", Name: "Ubuntu 22.04 (64-bit)"},
+				{ID: "os2", Name: "Ubuntu 22.04 (32-bit)"},
+			}},
+		},
+		{
+			name:    "nothing similar",
+			list:    listOsTypes,
+			value:   "debian",
+			wantErr: &cloudstack.ResolveError{Param: "ostypeid", Name: "debian", ListApi: "listOsTypes", Candidates: []cloudstack.NamedResource{}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			do, calls := listResponses(bodies)
+			r := cloudstack.NewResolver(do, 0)
+
+			param := map[string]string{"listZones": "zoneid", "listTemplates": "templateid", "listOsTypes": "ostypeid"}[tt.list.Name]
+			got, err := r.Resolve(t.Context(), tt.list, param, tt.value, tt.scope)
+
+			if tt.wantErr != nil {
+				var rerr *cloudstack.ResolveError
+				require.True(t, errors.As(err, &rerr), "got %v", err)
+				assert.Equal(t, tt.wantErr, rerr)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+
+			if tt.wantNoCall {
+				assert.Empty(t, *calls)
+			}
+			if tt.wantQuery != nil {
+				require.Len(t, *calls, 1)
+				assert.Equal(t, tt.wantQuery, (*calls)[0])
+			}
+		})
+	}
+}
+
+func Test_Resolver_Cache(t *testing.T) {
+	listZones := &csgo.Api{Name: "listZones", Params: []csgo.ApiParams{{Name: "name"}}}
+
+	do, calls := listResponses(map[string]string{"listZones": fmt.Sprintf(`[{"id":%q,"name":"us-east-1"}]`, zone1)})
+	r := cloudstack.NewResolver(do, 0)
+
+	for range 3 {
+		id, err := r.Resolve(t.Context(), listZones, "zoneid", "us-east-1", nil)
+		require.NoError(t, err)
+		assert.Equal(t, zone1, id)
+	}
+
+	assert.Len(t, *calls, 1)
+}
+
+func Test_FindListApi(t *testing.T) {
+	apis := map[string]*csgo.Api{}
+	for _, name := range []string{"listZones", "listVirtualMachines", "listAddresses", "listPolicies", "listOsTypes"} {
+		apis[name] = &csgo.Api{Name: name}
+	}
+
+	tests := []struct {
+		noun string
+		want string
+	}{
+		{noun: "zone", want: "listZones"},
+		{noun: "virtualmachine", want: "listVirtualMachines"},
+		{noun: "address", want: "listAddresses"},
+		{noun: "policy", want: "listPolicies"},
+		{noun: "ostype", want: "listOsTypes"},
+		{noun: "host", want: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.noun, func(t *testing.T) {
+			got := cloudstack.FindListApi(apis, tt.noun)
+			if tt.want == "" {
+				assert.Nil(t, got)
+				return
+			}
+			require.NotNil(t, got)
+			assert.Equal(t, tt.want, got.Name)
+		})
+	}
+}
@@ -0,0 +1,116 @@
+package cloudstack
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+
+	errors "gitlab.com/tozd/go/errors"
+)
+
+// ReadSecretFile reads a secret kept in a file of its own, like a docker or
+// kubernetes secret, without the trailing newline editors add
+func ReadSecretFile(path string) (string, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return "", errors.Errorf("reading secret file: %w", err)
+	}
+
+	secret := strings.TrimRight(string(b), "\r\n")
+	if secret == "" {
+		return "", errors.Errorf("secret file %s is empty", path)
+	}
+	return secret, nil
+}
+
+// LoadEnvFile reads a file of KEY=VALUE lines, as used by docker --env-file
+// and dotenv. Blank lines, # comments and a leading export are skipped, and
+// values may be quoted.
+func LoadEnvFile(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, errors.Errorf("opening env file: %w", err)
+	}
+	defer f.Close()
+
+	env, err := ParseEnvFile(f)
+	if err != nil {
+		return nil, errors.Errorf("reading env file %s: %w", path, err)
+	}
+	return env, nil
+}
+
+// ParseEnvFile parses the KEY=VALUE lines read by LoadEnvFile
+func ParseEnvFile(r io.Reader) (map[string]string, error) {
+	env := map[string]string{}
+
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		text = strings.TrimPrefix(text, "export ")
+
+		key, value, ok := strings.Cut(text, "=")
+		key = strings.TrimSpace(key)
+		if !ok || key == "" {
+			return nil, errors.Errorf("line %d: expected KEY=VALUE", line)
+		}
+
+		value = strings.TrimSpace(value)
+		if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+			if value[0] == '"' {
+				unquoted, err := strconv.Unquote(value)
+				if err != nil {
+					return nil, errors.Errorf("line %d: %w", line, err)
+				}
+				value = unquoted
+			} else {
+				value = value[1 : len(value)-1]
+			}
+		}
+
+		env[key] = value
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, errors.Errorf("reading env file: %w", err)
+	}
+
+	return env, nil
+}
+
+// KeychainSecret looks up the secret stored for account under service in the
+// keychain of the OS: the login keychain on macOS, read with security, and the
+// secret service (GNOME Keyring, KWallet) elsewhere, read with secret-tool
+func KeychainSecret(ctx context.Context, service, account string) (string, error) {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		cmd = exec.CommandContext(ctx, "security", "find-generic-password", "-s", service, "-a", account, "-w")
+	case "linux", "freebsd", "openbsd", "netbsd":
+		cmd = exec.CommandContext(ctx, "secret-tool", "lookup", "service", service, "account", account)
+	default:
+		return "", errors.Errorf("no keychain support on %s", runtime.GOOS)
+	}
+
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	out, err := cmd.Output()
+	if err != nil {
+		return "", errors.Errorf("looking up %s/%s in the keychain: %w: %s", service, account, err, strings.TrimSpace(stderr.String()))
+	}
+
+	secret := strings.TrimRight(string(out), "\r\n")
+	if secret == "" {
+		return "", errors.Errorf("no secret for %s/%s in the keychain", service, account)
+	}
+	return secret, nil
+}
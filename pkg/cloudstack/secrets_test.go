@@ -0,0 +1,52 @@
+package cloudstack_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/walteh/cloudstack-mcp/pkg/cloudstack"
+)
+
+func Test_ParseEnvFile(t *testing.T) {
+	env, err := cloudstack.ParseEnvFile(strings.NewReader(`
+# the lab endpoint
+CLOUDSTACK_API_URL=http://cs.lab:8080/client/api
+export CLOUDSTACK_USERNAME = admin
+CLOUDSTACK_PASSWORD="p@ss \"word\""
+CLOUDSTACK_SECRET_KEY='a#b'
+CLOUDSTACK_API_KEY=
+`))
+	require.NoError(t, err)
+
+	assert.Equal(t, map[string]string{
+		"CLOUDSTACK_API_URL":    "http://cs.lab:8080/client/api",
+		"CLOUDSTACK_USERNAME":   "admin",
+		"CLOUDSTACK_PASSWORD":   `p@ss "word"`,
+		"CLOUDSTACK_SECRET_KEY": "a#b",
+		"CLOUDSTACK_API_KEY":    "",
+	}, env)
+
+	_, err = cloudstack.ParseEnvFile(strings.NewReader("CLOUDSTACK_USERNAME"))
+	assert.ErrorContains(t, err, "line 1: expected KEY=VALUE")
+}
+
+func Test_ReadSecretFile(t *testing.T) {
+	dir := t.TempDir()
+
+	path := filepath.Join(dir, "password")
+	require.NoError(t, os.WriteFile(path, []byte("hunter2\n"), 0o600))
+
+	secret, err := cloudstack.ReadSecretFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, "hunter2", secret)
+
+	empty := filepath.Join(dir, "empty")
+	require.NoError(t, os.WriteFile(empty, []byte("\n"), 0o600))
+
+	_, err = cloudstack.ReadSecretFile(empty)
+	assert.ErrorContains(t, err, "is empty")
+}
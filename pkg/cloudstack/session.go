@@ -0,0 +1,182 @@
+package cloudstack
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/apache/cloudstack-go/v2/cloudstack"
+	"github.com/rs/zerolog"
+	errors "gitlab.com/tozd/go/errors"
+)
+
+// DefaultSessionManager is the session manager used by DoRawCloudStackRequest
+var DefaultSessionManager = NewSessionManager()
+
+// SessionManager caches one authenticated CloudStack session per API URL and user
+type SessionManager struct {
+	mu       sync.Mutex
+	sessions map[sessionID]*Session
+}
+
+type sessionID struct {
+	apiURL   string
+	domain   string
+	username string
+}
+
+// NewSessionManager creates a new SessionManager
+func NewSessionManager() *SessionManager {
+	return &SessionManager{
+		sessions: make(map[sessionID]*Session),
+	}
+}
+
+// Session returns the cached session for the given user of the ROOT domain,
+// creating it if needed. The session logs in lazily on its first request.
+func (m *SessionManager) Session(apiURL, username, password string) (*Session, error) {
+	return m.DomainSession(apiURL, "", username, password)
+}
+
+// DomainSession is Session for a user of the domain with the given path
+func (m *SessionManager) DomainSession(apiURL, domain, username, password string) (*Session, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	id := sessionID{apiURL: apiURL, domain: domain, username: username}
+
+	if sess, ok := m.sessions[id]; ok && sess.password == password {
+		return sess, nil
+	}
+
+	sess, err := newSession(apiURL, domain, username, password)
+	if err != nil {
+		return nil, errors.Errorf("creating session: %w", err)
+	}
+
+	m.sessions[id] = sess
+
+	return sess, nil
+}
+
+// Session is a logged in CloudStack user, holding the JSESSIONID cookie jar
+// and the session key that must accompany every authenticated request
+type Session struct {
+	apiURL     string
+	domain     string
+	username   string
+	password   string
+	httpClient *http.Client
+
+	mu         sync.Mutex
+	sessionKey string
+}
+
+func newSession(apiURL, domain, username, password string) (*Session, error) {
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		return nil, errors.Errorf("failed to create cookie jar: %w", err)
+	}
+
+	return &Session{
+		apiURL:   apiURL,
+		domain:   domain,
+		username: username,
+		password: password,
+		httpClient: &http.Client{
+			Timeout: time.Second * 10,
+			Jar:     jar,
+		},
+	}, nil
+}
+
+// Do calls the given command with the session key, logging in first if needed.
+// If CloudStack reports the session as expired, it logs in again and retries once.
+func (s *Session) Do(ctx context.Context, command string, params map[string]string) (json.RawMessage, error) {
+	logger := zerolog.Ctx(ctx)
+
+	key, err := s.key(ctx, "")
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := s.do(ctx, key, command, params)
+	if err == nil || !isSessionExpired(err) {
+		return res, err
+	}
+
+	logger.Debug().Str("command", command).Msg("CloudStack session expired, logging in again")
+
+	key, err = s.key(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.do(ctx, key, command, params)
+}
+
+func (s *Session) do(ctx context.Context, key string, command string, params map[string]string) (json.RawMessage, error) {
+	values := url.Values{}
+	values.Set("command", command)
+	for k, v := range params {
+		values.Set(k, v)
+	}
+
+	values.Set("sessionkey", key)
+
+	res, err := makeRawCloudStackRequest(ctx, s.httpClient, s.apiURL, values)
+	if err != nil {
+		return nil, errors.Errorf("error calling %s: %w", command, err)
+	}
+
+	return res, nil
+}
+
+// key returns the current session key. If the current key equals stale, the
+// session logs in again; concurrent callers holding the same stale key share
+// a single login round-trip.
+func (s *Session) key(ctx context.Context, stale string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.sessionKey != "" && s.sessionKey != stale {
+		return s.sessionKey, nil
+	}
+
+	login := url.Values{"command": {"login"}, "username": {s.username}, "password": {s.password}}
+	if s.domain != "" {
+		login.Set("domain", s.domain)
+	}
+
+	// this creates a JSESSIONID cookie that needs to be used for all authenticated requests
+	lres, err := makeTypedCloudStackRequest[cloudstack.LoginResponse](ctx, s.httpClient, s.apiURL, login)
+	if err != nil {
+		s.sessionKey = ""
+		return "", errors.Errorf("logging in: %w", err)
+	}
+
+	s.sessionKey = lres.Sessionkey
+
+	return s.sessionKey, nil
+}
+
+// isSessionExpired reports whether CloudStack rejected a request because the
+// session key or JSESSIONID is no longer valid
+func isSessionExpired(err error) bool {
+	statusCode := 0
+
+	var rerr *ResponseError
+	var aerr *APIError
+	switch {
+	case errors.As(err, &aerr):
+		statusCode = aerr.StatusCode
+	case errors.As(err, &rerr):
+		statusCode = rerr.StatusCode
+	}
+
+	return statusCode == http.StatusUnauthorized || statusCode == 432
+}
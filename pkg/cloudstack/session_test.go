@@ -0,0 +1,182 @@
+package cloudstack_test
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/walteh/cloudstack-mcp/pkg/cloudstack"
+)
+
+// sessionServer is a CloudStack stub that hands out a new session key on
+// every login and rejects keys that were expired with expire
+type sessionServer struct {
+	*httptest.Server
+
+	status int
+	logins atomic.Int32
+
+	mu      sync.Mutex
+	expired map[string]bool
+}
+
+func newSessionServer(t *testing.T, status int) *sessionServer {
+	s := &sessionServer{status: status, expired: map[string]bool{}}
+
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+
+		if q.Get("command") == "login" {
+			n := s.logins.Add(1)
+			http.SetCookie(w, &http.Cookie{Name: "JSESSIONID", Value: fmt.Sprintf("js%d", n)})
+			fmt.Fprintf(w, `{"loginresponse":{"sessionkey":"key%d","userid":"u1"}}`, n)
+			return
+		}
+
+		s.mu.Lock()
+		expired := s.expired[q.Get("sessionkey")]
+		s.mu.Unlock()
+
+		if expired {
+			w.WriteHeader(s.status)
+			fmt.Fprintf(w, `{"listzonesresponse":{"errorcode":%d,"errortext":"unable to verify user credentials"}}`, s.status)
+			return
+		}
+
+		fmt.Fprintf(w, `{"listzonesresponse":{"sessionkey":%q}}`, q.Get("sessionkey"))
+	}))
+	t.Cleanup(s.Close)
+
+	return s
+}
+
+func (s *sessionServer) expire(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.expired[key] = true
+}
+
+func Test_Session_ReloginOnExpiry(t *testing.T) {
+	for _, status := range []int{http.StatusUnauthorized, 432} {
+		t.Run(fmt.Sprint(status), func(t *testing.T) {
+			cs := newSessionServer(t, status)
+
+			sess, err := cloudstack.NewSessionManager().Session(cs.URL, "admin", "password")
+			require.NoError(t, err)
+
+			res, err := sess.Do(t.Context(), "listZones", nil)
+			require.NoError(t, err)
+			assert.JSONEq(t, `{"listzonesresponse":{"sessionkey":"key1"}}`, string(res))
+
+			// the next call finds the key expired, logs in again and retries with the new key
+			cs.expire("key1")
+
+			res, err = sess.Do(t.Context(), "listZones", nil)
+			require.NoError(t, err)
+			assert.JSONEq(t, `{"listzonesresponse":{"sessionkey":"key2"}}`, string(res))
+			assert.Equal(t, int32(2), cs.logins.Load())
+		})
+	}
+}
+
+func Test_Session_ReloginFails(t *testing.T) {
+	cs := newSessionServer(t, http.StatusUnauthorized)
+
+	sess, err := cloudstack.NewSessionManager().Session(cs.URL, "admin", "password")
+	require.NoError(t, err)
+
+	_, err = sess.Do(t.Context(), "listZones", nil)
+	require.NoError(t, err)
+
+	// every key is rejected, so the retry fails too and is not repeated
+	cs.expire("key1")
+	cs.expire("key2")
+
+	_, err = sess.Do(t.Context(), "listZones", nil)
+	require.Error(t, err)
+	assert.Equal(t, int32(2), cs.logins.Load())
+}
+
+func Test_Session_ConcurrentCallersShareLogin(t *testing.T) {
+	cs := newSessionServer(t, http.StatusUnauthorized)
+
+	manager := cloudstack.NewSessionManager()
+
+	callConcurrently := func() {
+		var wg sync.WaitGroup
+		for range 20 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+
+				sess, err := manager.Session(cs.URL, "admin", "password")
+				if !assert.NoError(t, err) {
+					return
+				}
+				_, err = sess.Do(t.Context(), "listZones", nil)
+				assert.NoError(t, err)
+			}()
+		}
+		wg.Wait()
+	}
+
+	callConcurrently()
+	assert.Equal(t, int32(1), cs.logins.Load())
+
+	// callers that all hold the expired key share a single new login
+	cs.expire("key1")
+	callConcurrently()
+	assert.Equal(t, int32(2), cs.logins.Load())
+}
+
+func Test_SessionManager_Session(t *testing.T) {
+	manager := cloudstack.NewSessionManager()
+
+	a, err := manager.Session("http://cloudstack/client/api", "admin", "password")
+	require.NoError(t, err)
+
+	b, err := manager.Session("http://cloudstack/client/api", "admin", "password")
+	require.NoError(t, err)
+	assert.Same(t, a, b)
+
+	// a changed password replaces the cached session
+	c, err := manager.Session("http://cloudstack/client/api", "admin", "changed")
+	require.NoError(t, err)
+	assert.NotSame(t, a, c)
+
+	d, err := manager.Session("http://cloudstack/client/api", "user", "password")
+	require.NoError(t, err)
+	assert.NotSame(t, a, d)
+}
+
+func Test_SessionManager_DomainSession(t *testing.T) {
+	var domain atomic.Value
+	cs := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("command") == "login" {
+			domain.Store(r.URL.Query().Get("domain"))
+			fmt.Fprint(w, `{"loginresponse":{"sessionkey":"key1","userid":"u1"}}`)
+			return
+		}
+		fmt.Fprint(w, `{"listzonesresponse":{}}`)
+	}))
+	t.Cleanup(cs.Close)
+
+	manager := cloudstack.NewSessionManager()
+
+	root, err := manager.Session(cs.URL, "admin", "password")
+	require.NoError(t, err)
+
+	// the same username in another domain is another user
+	sess, err := manager.DomainSession(cs.URL, "/customers/acme", "admin", "password")
+	require.NoError(t, err)
+	assert.NotSame(t, root, sess)
+
+	_, err = sess.Do(t.Context(), "listZones", nil)
+	require.NoError(t, err)
+	assert.Equal(t, "/customers/acme", domain.Load())
+}
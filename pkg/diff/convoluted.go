@@ -13,8 +13,6 @@ import (
 	"reflect"
 	"sort"
 	"strings"
-
-	"github.com/walteh/yaml"
 )
 
 // ConvolutedFormatReflectValue formats a reflect.Value into a standardized string
@@ -34,40 +32,24 @@ func ConvolutedFormatReflectValueAsJSON(s reflect.Value) any {
 		panic(err)
 	}
 
-	// Use ordered map to ensure stable field ordering
-	mapd := yaml.NewOrderedMap()
-	if err := json.Unmarshal(buf.Bytes(), mapd); err != nil {
+	// Decode into plain maps, which encoding/json encodes with their keys sorted
+	// at every level, so that field order never causes a difference
+	var decoded any
+	if err := json.Unmarshal(buf.Bytes(), &decoded); err != nil {
 		panic(err)
 	}
 
-	// Sort keys alphabetically
-	ms := mapd.ToMapSlice()
-	keys := extractAndSortKeys(ms)
-	ms.SortKeys(keys...)
-
 	// Re-encode to JSON with consistent ordering
 	buf.Reset()
 	enc2 := json.NewEncoder(buf)
 	enc2.SetIndent("", "\t")
-	if err := enc2.Encode(ms); err != nil {
+	if err := enc2.Encode(decoded); err != nil {
 		panic(err)
 	}
 
 	return buf.String()
 }
 
-// extractAndSortKeys extracts keys from a MapSlice and returns them sorted
-func extractAndSortKeys(ms yaml.MapSlice) []string {
-	keys := []string{}
-	for _, key := range ms {
-		if keyStr, ok := key.Key.(string); ok {
-			keys = append(keys, keyStr)
-		}
-	}
-	sort.Strings(keys)
-	return keys
-}
-
 // ConvolutedFormatReflectType formats a reflect.Type into a standardized string
 // representation.
 // It handles struct types specially, formatting them with consistent field ordering
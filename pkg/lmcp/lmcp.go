@@ -14,6 +14,7 @@ import (
 	"github.com/mark3labs/mcp-go/server"
 	"github.com/rs/zerolog"
 	zlog "github.com/rs/zerolog/log"
+	"github.com/walteh/cloudstack-mcp/pkg/redact"
 	"gitlab.com/tozd/go/errors"
 )
 
@@ -22,6 +23,9 @@ type LMCPOpts struct {
 	HTTPAddr       string
 	DisableLogFile bool
 	LogLevelStr    string
+	// SSEContextFunc adds values from each HTTP request to the context of the
+	// MCP request it carries, e.g. the credentials of the client
+	SSEContextFunc server.SSEContextFunc
 }
 
 type ServerSetupFunc func(ctx context.Context) (*server.MCPServer, error)
@@ -104,7 +108,8 @@ func WrapMCPServerWithLogging(ctx context.Context, opts LMCPOpts) (func(ctx cont
 		logFileCloser = func() error { return nil }
 	}
 
-	multi := zerolog.MultiLevelWriter(writers...)
+	// every event is scrubbed of credentials before it reaches the console or the log file
+	multi := redact.NewWriter(zerolog.MultiLevelWriter(writers...))
 
 	loggerpre := zerolog.New(multi).With().Timestamp().Caller()
 
@@ -141,13 +146,17 @@ func WrapMCPServerWithLogging(ctx context.Context, opts LMCPOpts) (func(ctx cont
 				if err != nil {
 					logger.Error().Err(err).Msg("error reading request body")
 				} else {
-					logger.Info().RawJSON("body", body).Msg("Request body")
+					logger.Info().RawJSON("body", redact.Bytes(body)).Msg("Request body")
 				}
 
 				// reset the body of the request
 				r.Body = io.NopCloser(bytes.NewBuffer(body))
 			}
 
+			if opts.SSEContextFunc != nil {
+				ctx = opts.SSEContextFunc(ctx, r)
+			}
+
 			return ctx
 		}
 
@@ -12,6 +12,7 @@ import (
 	"time"
 
 	"github.com/rs/zerolog"
+	"github.com/walteh/cloudstack-mcp/pkg/redact"
 	"gitlab.com/tozd/go/errors"
 )
 
@@ -74,6 +75,7 @@ func loggerMiddleware(next http.Handler, logger zerolog.Logger) http.Handler {
 		// Log request with body
 		reqEvent := reqLogger.Info().Str("phase", "request")
 		if len(requestBody) > 0 {
+			requestBody = redact.Bytes(requestBody)
 			// Try to pretty-print JSON bodies
 			if strings.Contains(contentType, "application/json") {
 				reqEvent.RawJSON("body", requestBody)
@@ -116,7 +118,7 @@ func loggerMiddleware(next http.Handler, logger zerolog.Logger) http.Handler {
 
 		if len(rw.body) > 0 && shouldLogRespBody {
 			// Limit response body logging
-			bodyToLog := rw.body
+			bodyToLog := redact.Bytes(rw.body)
 			if len(bodyToLog) > maxBodyLogSize {
 				bodyToLog = bodyToLog[:maxBodyLogSize]
 				respEvent.Bool("truncated", true)
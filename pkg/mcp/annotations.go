@@ -0,0 +1,96 @@
+package mcp
+
+import (
+	"strings"
+	"unicode"
+
+	csgo "github.com/apache/cloudstack-go/v2/cloudstack"
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// idempotentVerbs are mutating verbs where repeating a call with the same
+// arguments leaves CloudStack in the same state
+var idempotentVerbs = map[string]bool{
+	"update":  true,
+	"enable":  true,
+	"disable": true,
+	"start":   true,
+	"stop":    true,
+	"set":     true,
+	"lock":    true,
+	"mark":    true,
+	// deleting something twice has no additional effect, unlike other
+	// destructive verbs such as release, revoke or reset
+	"delete":  true,
+	"destroy": true,
+	"expunge": true,
+}
+
+// toolAnnotations derives the MCP tool annotations of a CloudStack API from its
+// verb, its isasync flag and its response type
+func toolAnnotations(api *csgo.Api) mcp.ToolAnnotation {
+	class := classifyApi(api.Name)
+	verb := apiVerb(api.Name)
+
+	readOnly := class == classRead
+	destructive := class == classDestroy
+
+	// an API that only reports success creates nothing new when it is repeated
+	idempotent := readOnly || idempotentVerbs[verb] || (!api.Isasync && respondsWithSuccessOnly(api))
+
+	title := apiTitle(api.Name)
+	if api.Isasync {
+		title += " (async job)"
+	}
+
+	return mcp.ToolAnnotation{
+		Title:           title,
+		ReadOnlyHint:    mcp.ToBoolPtr(readOnly),
+		DestructiveHint: mcp.ToBoolPtr(destructive),
+		IdempotentHint:  mcp.ToBoolPtr(idempotent),
+		// tools only talk to the configured CloudStack, not the open internet
+		OpenWorldHint: mcp.ToBoolPtr(false),
+	}
+}
+
+// respondsWithSuccessOnly reports whether the API response is a plain success
+// flag instead of a resource
+func respondsWithSuccessOnly(api *csgo.Api) bool {
+	if len(api.Response) == 0 {
+		return false
+	}
+
+	for _, field := range api.Response {
+		if field.Name != "success" && field.Name != "displaytext" {
+			return false
+		}
+	}
+
+	return true
+}
+
+// apiTitle splits a camel case API name into words, e.g. "List Virtual Machines"
+func apiTitle(name string) string {
+	var sb strings.Builder
+
+	runes := []rune(name)
+	for i, r := range runes {
+		if i == 0 {
+			sb.WriteRune(unicode.ToUpper(r))
+			continue
+		}
+
+		// start a new word at an upper case letter that follows a lower case
+		// letter, or that ends an acronym ("VMSnapshot" -> "VM Snapshot"), but
+		// keep a plural acronym together ("listAPIs" -> "List APIs")
+		prevLower := unicode.IsLower(runes[i-1])
+		nextLower := i+1 < len(runes) && unicode.IsLower(runes[i+1]) && string(runes[i+1:]) != "s"
+		if unicode.IsUpper(r) && (prevLower || (unicode.IsUpper(runes[i-1]) && nextLower)) {
+			sb.WriteRune(' ')
+		}
+
+		sb.WriteRune(r)
+	}
+
+	return sb.String()
+}
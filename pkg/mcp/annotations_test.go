@@ -0,0 +1,90 @@
+package mcp_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/walteh/cloudstack-mcp/pkg/mcp"
+)
+
+func Test_ToolAnnotations(t *testing.T) {
+	apis := `[
+		{"name":"listZones","params":[]},
+		{"name":"deployVirtualMachine","isasync":true,"params":[]},
+		{"name":"updateZone","params":[]},
+		{"name":"stopVirtualMachine","isasync":true,"params":[]},
+		{"name":"deleteZone","params":[],"response":[{"name":"success"},{"name":"displaytext"}]},
+		{"name":"destroyVirtualMachine","isasync":true,"params":[]},
+		{"name":"expungeVirtualMachine","isasync":true,"params":[]},
+		{"name":"releaseIpAddress","isasync":true,"params":[]},
+		{"name":"revokeSecurityGroupIngress","isasync":true,"params":[]},
+		{"name":"resetPasswordForVirtualMachine","isasync":true,"params":[]},
+		{"name":"assignToLoadBalancerRule","params":[],"response":[{"name":"success"},{"name":"displaytext"}]},
+		{"name":"createVMSnapshot","isasync":true,"params":[]},
+		{"name":"listAPIs","params":[]}
+	]`
+
+	s := newTestServer(t, apis, nil, mcp.ServerOpts{})
+
+	var resp struct {
+		Result struct {
+			Tools []struct {
+				Name        string `json:"name"`
+				Annotations struct {
+					Title           string `json:"title"`
+					ReadOnlyHint    bool   `json:"readOnlyHint"`
+					DestructiveHint bool   `json:"destructiveHint"`
+					IdempotentHint  bool   `json:"idempotentHint"`
+					OpenWorldHint   *bool  `json:"openWorldHint"`
+				} `json:"annotations"`
+			} `json:"tools"`
+		} `json:"result"`
+	}
+	require.NoError(t, json.Unmarshal([]byte(s.request("tools/list", `{}`)), &resp))
+
+	type hints struct {
+		title       string
+		readOnly    bool
+		destructive bool
+		idempotent  bool
+	}
+
+	got := map[string]hints{}
+	for _, tool := range resp.Result.Tools {
+		a := tool.Annotations
+		got[tool.Name] = hints{title: a.Title, readOnly: a.ReadOnlyHint, destructive: a.DestructiveHint, idempotent: a.IdempotentHint}
+
+		require.NotNil(t, a.OpenWorldHint, tool.Name)
+		assert.False(t, *a.OpenWorldHint, tool.Name)
+	}
+
+	tests := []struct {
+		api  string
+		want hints
+	}{
+		{api: "listZones", want: hints{title: "List Zones", readOnly: true, idempotent: true}},
+		{api: "deployVirtualMachine", want: hints{title: "Deploy Virtual Machine (async job)"}},
+		{api: "updateZone", want: hints{title: "Update Zone", idempotent: true}},
+		{api: "stopVirtualMachine", want: hints{title: "Stop Virtual Machine (async job)", idempotent: true}},
+		{api: "deleteZone", want: hints{title: "Delete Zone", destructive: true, idempotent: true}},
+		{api: "destroyVirtualMachine", want: hints{title: "Destroy Virtual Machine (async job)", destructive: true, idempotent: true}},
+		{api: "expungeVirtualMachine", want: hints{title: "Expunge Virtual Machine (async job)", destructive: true, idempotent: true}},
+		// releasing or revoking again fails or hits whatever took the resource's place
+		{api: "releaseIpAddress", want: hints{title: "Release Ip Address (async job)", destructive: true}},
+		{api: "revokeSecurityGroupIngress", want: hints{title: "Revoke Security Group Ingress (async job)", destructive: true}},
+		// every reset generates a new password
+		{api: "resetPasswordForVirtualMachine", want: hints{title: "Reset Password For Virtual Machine (async job)", destructive: true}},
+		{api: "assignToLoadBalancerRule", want: hints{title: "Assign To Load Balancer Rule", idempotent: true}},
+		{api: "createVMSnapshot", want: hints{title: "Create VM Snapshot (async job)"}},
+		{api: "listAPIs", want: hints{title: "List APIs", readOnly: true, idempotent: true}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.api, func(t *testing.T) {
+			require.Contains(t, got, tt.api)
+			assert.Equal(t, tt.want, got[tt.api])
+		})
+	}
+}
@@ -0,0 +1,36 @@
+package mcp
+
+import (
+	"context"
+
+	"github.com/rs/zerolog"
+)
+
+// Tool calls are cancelled by mcp-go itself: a notifications/cancelled message
+// cancels the context of the request it names, which stops the CloudStack
+// calls and job polling made with it.
+
+// jobCancelCommands maps async APIs to the CloudStack API that cancels them.
+// Both are called with the same id parameter. CloudStack has no generic way to
+// cancel an async job, so only these can be cancelled.
+var jobCancelCommands = map[string]string{
+	"prepareHostForMaintenance": "cancelHostMaintenance",
+	"enableStorageMaintenance":  "cancelStorageMaintenance",
+}
+
+// cancelJob asks CloudStack to cancel the operation started by apiName, if it can be cancelled
+func (s *Server) cancelJob(ctx context.Context, apiName string, params map[string]string) bool {
+	logger := zerolog.Ctx(ctx)
+
+	command, ok := jobCancelCommands[apiName]
+	if !ok || s.api(command) == nil || params["id"] == "" {
+		return false
+	}
+
+	if _, err := s.call(ctx, command, map[string]string{"id": params["id"]}); err != nil {
+		logger.Warn().Err(err).Str("command", command).Msg("Failed to cancel CloudStack operation")
+		return false
+	}
+
+	return true
+}
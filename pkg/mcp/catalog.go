@@ -0,0 +1,295 @@
+package mcp
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	_ "embed"
+
+	csgo "github.com/apache/cloudstack-go/v2/cloudstack"
+	"github.com/rs/zerolog"
+	"github.com/walteh/cloudstack-mcp/pkg/cloudstack"
+	errors "gitlab.com/tozd/go/errors"
+)
+
+// catalogFormatVersion is bumped whenever the cache file layout changes, so
+// that stale cache files are ignored instead of misread
+const catalogFormatVersion = 1
+
+// embeddedCatalogVersion is the CloudStack release of the embedded catalog snapshot
+const embeddedCatalogVersion = "4.20"
+
+// embeddedCatalog is the gzipped listApis response of an admin account on
+// embeddedCatalogVersion, used when neither CloudStack nor a cache is reachable
+//
+//go:embed catalog/cloudstack-4.20.json.gz
+var embeddedCatalog []byte
+
+const (
+	catalogRetryInitial = 15 * time.Second
+	catalogRetryMax     = 5 * time.Minute
+)
+
+// apiCatalog is the listApis result of one CloudStack endpoint, as cached on disk
+type apiCatalog struct {
+	FormatVersion     int         `json:"formatversion"`
+	APIURL            string      `json:"apiurl"`
+	CloudStackVersion string      `json:"cloudstackversion"`
+	FetchedAt         time.Time   `json:"fetchedat"`
+	Apis              []*csgo.Api `json:"api"`
+}
+
+// loadCatalog returns the API catalog: from the cache when CloudStack reports a
+// version that was cached before, from listApis otherwise, and from the newest
+// cache or the embedded snapshot when CloudStack cannot be reached. online is
+// false when the catalog came from such an offline fallback.
+func (s *Server) loadCatalog(ctx context.Context) (apis []*csgo.Api, online bool, err error) {
+	logger := zerolog.Ctx(ctx)
+
+	apis, err = s.fetchCatalog(ctx, false)
+	if err == nil {
+		return apis, true, nil
+	}
+
+	logger.Warn().Err(err).Msg("CloudStack is unreachable, starting from an offline API catalog")
+
+	if cached, err := s.newestCachedCatalog(); err == nil {
+		logger.Info().Str("version", cached.CloudStackVersion).Time("fetched_at", cached.FetchedAt).Msg("Using cached API catalog")
+		return cached.Apis, false, nil
+	} else if !errors.Is(err, os.ErrNotExist) {
+		logger.Warn().Err(err).Msg("Failed to read cached API catalog")
+	}
+
+	apis, err = readEmbeddedCatalog()
+	if err != nil {
+		return nil, false, err
+	}
+
+	logger.Info().Str("version", embeddedCatalogVersion).Msg("Using embedded API catalog")
+	return apis, false, nil
+}
+
+// fetchCatalog asks CloudStack for its version and returns the cached catalog of
+// that version, calling listApis and caching the result on a cache miss or when
+// force is set
+func (s *Server) fetchCatalog(ctx context.Context, force bool) ([]*csgo.Api, error) {
+	logger := zerolog.Ctx(ctx)
+
+	version, err := s.cloudStackVersion(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if !force {
+		if cached, err := s.readCachedCatalog(version); err == nil {
+			logger.Info().Str("version", version).Msg("Using cached API catalog for this CloudStack version")
+			return cached.Apis, nil
+		}
+	}
+
+	apis, err := s.listApis(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.writeCachedCatalog(version, apis); err != nil {
+		logger.Warn().Err(err).Msg("Failed to cache API catalog")
+	}
+
+	return apis, nil
+}
+
+func (s *Server) cloudStackVersion(ctx context.Context) (string, error) {
+	// the catalog is shared by every client, so it is always read as the server's user
+	ctx = cloudstack.WithCredentials(ctx, s.creds)
+
+	resp, err := cloudstack.DoTypedCloudStackRequest[csgo.ListCapabilitiesResponse](ctx, s.apiURL, "listCapabilities", s.creds, map[string]string{})
+	if err != nil {
+		return "", errors.Errorf("getting CloudStack version: %w", err)
+	}
+
+	if resp.Capabilities == nil || resp.Capabilities.Cloudstackversion == "" {
+		return "", errors.New("listCapabilities did not report a CloudStack version")
+	}
+
+	return resp.Capabilities.Cloudstackversion, nil
+}
+
+// refreshCatalogUntilOnline retries fetching the live catalog with backoff until
+// CloudStack becomes reachable, then replaces the offline catalog and its tools
+func (s *Server) refreshCatalogUntilOnline(ctx context.Context) {
+	logger := zerolog.Ctx(ctx)
+
+	interval := catalogRetryInitial
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+
+		apis, err := s.fetchCatalog(ctx, false)
+		if err != nil {
+			logger.Debug().Err(err).Dur("retry_in", interval).Msg("CloudStack still unreachable")
+			interval = min(interval*2, catalogRetryMax)
+			continue
+		}
+
+		if _, err := s.applyCatalog(ctx, apis); err != nil {
+			logger.Error().Err(err).Msg("Failed to apply refreshed API catalog")
+			return
+		}
+
+		logger.Info().Int("count", len(apis)).Msg("Refreshed API catalog from CloudStack")
+		return
+	}
+}
+
+// catalogDir is the cache directory of this endpoint and account, since
+// listApis only returns the APIs the account's role allows
+func (s *Server) catalogDir() (string, error) {
+	dir := s.opts.CatalogCacheDir
+	if dir == "" {
+		userCache, err := os.UserCacheDir()
+		if err != nil {
+			return "", errors.Errorf("finding user cache directory: %w", err)
+		}
+		dir = filepath.Join(userCache, "cloudstack-mcp", "catalog")
+	}
+
+	sum := sha256.Sum256([]byte(s.apiURL + "\x00" + credentialsIdentity(s.creds)))
+	return filepath.Join(dir, hex.EncodeToString(sum[:8])), nil
+}
+
+func catalogFileName(version string) string {
+	return strings.NewReplacer("/", "_", "\\", "_").Replace(version) + ".json"
+}
+
+func (s *Server) readCachedCatalog(version string) (*apiCatalog, error) {
+	dir, err := s.catalogDir()
+	if err != nil {
+		return nil, err
+	}
+
+	return readCatalogFile(filepath.Join(dir, catalogFileName(version)))
+}
+
+// newestCachedCatalog returns the most recently fetched cached catalog of any version
+func (s *Server) newestCachedCatalog() (*apiCatalog, error) {
+	dir, err := s.catalogDir()
+	if err != nil {
+		return nil, err
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, errors.Errorf("listing cached catalogs: %w", err)
+	}
+
+	var newest *apiCatalog
+	for _, file := range files {
+		cat, err := readCatalogFile(file)
+		if err != nil {
+			continue
+		}
+		if newest == nil || cat.FetchedAt.After(newest.FetchedAt) {
+			newest = cat
+		}
+	}
+
+	if newest == nil {
+		return nil, errors.WithStack(os.ErrNotExist)
+	}
+
+	return newest, nil
+}
+
+func readCatalogFile(file string) (*apiCatalog, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	var cat apiCatalog
+	if err := json.Unmarshal(data, &cat); err != nil {
+		return nil, errors.Errorf("parsing cached catalog %s: %w", file, err)
+	}
+
+	if cat.FormatVersion != catalogFormatVersion || len(cat.Apis) == 0 {
+		return nil, errors.Errorf("cached catalog %s is stale or empty", file)
+	}
+
+	return &cat, nil
+}
+
+func (s *Server) writeCachedCatalog(version string, apis []*csgo.Api) error {
+	dir, err := s.catalogDir()
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return errors.Errorf("creating catalog cache directory: %w", err)
+	}
+
+	data, err := json.Marshal(apiCatalog{
+		FormatVersion:     catalogFormatVersion,
+		APIURL:            s.apiURL,
+		CloudStackVersion: version,
+		FetchedAt:         time.Now().UTC(),
+		Apis:              apis,
+	})
+	if err != nil {
+		return errors.Errorf("marshalling catalog: %w", err)
+	}
+
+	// write to a temporary file first so a concurrent start never reads half a catalog
+	tmp, err := os.CreateTemp(dir, ".catalog-*")
+	if err != nil {
+		return errors.Errorf("creating catalog cache file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return errors.Errorf("writing catalog cache file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return errors.Errorf("writing catalog cache file: %w", err)
+	}
+
+	if err := os.Rename(tmp.Name(), filepath.Join(dir, catalogFileName(version))); err != nil {
+		return errors.Errorf("replacing catalog cache file: %w", err)
+	}
+
+	return nil
+}
+
+func readEmbeddedCatalog() ([]*csgo.Api, error) {
+	gz, err := gzip.NewReader(bytes.NewReader(embeddedCatalog))
+	if err != nil {
+		return nil, errors.Errorf("opening embedded catalog: %w", err)
+	}
+	defer gz.Close()
+
+	data, err := io.ReadAll(gz)
+	if err != nil {
+		return nil, errors.Errorf("reading embedded catalog: %w", err)
+	}
+
+	var resp csgo.ListApisResponse
+	if err := json.Unmarshal(data, &resp); err != nil {
+		return nil, errors.Errorf("parsing embedded catalog: %w", err)
+	}
+
+	return resp.Apis, nil
+}
@@ -0,0 +1,84 @@
+package mcp_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/walteh/cloudstack-mcp/pkg/mcp"
+)
+
+func Test_NewServer_CatalogCache(t *testing.T) {
+	cs := newCloudStackStub(t, `[{"name":"listZones","description":"Lists zones","params":[{"name":"id","type":"uuid"}]}]`, nil)
+
+	opts := mcp.ServerOpts{CatalogCacheDir: t.TempDir()}
+
+	toolNames := func(s *mcp.Server) []string {
+		tools, err := s.CreateToolForEachApi(t.Context())
+		require.NoError(t, err)
+		names := []string{}
+		for _, tool := range tools {
+			names = append(names, tool.Name)
+		}
+		return names
+	}
+
+	// the first start fetches and caches the catalog, the second finds it by version
+	for range 2 {
+		s, err := mcp.NewServer(t.Context(), cs.URL, testCreds, opts)
+		require.NoError(t, err)
+		defer s.Close()
+		assert.Equal(t, []string{"listZones"}, toolNames(s))
+	}
+	assert.Equal(t, int32(1), cs.listApisCalls.Load())
+
+	// offline, the cached catalog is used
+	cs.Close()
+	s, err := mcp.NewServer(t.Context(), cs.URL, testCreds, opts)
+	require.NoError(t, err)
+	defer s.Close()
+	assert.Equal(t, []string{"listZones"}, toolNames(s))
+
+	// offline without a cache, the embedded snapshot is used
+	opts.CatalogCacheDir = t.TempDir()
+	s, err = mcp.NewServer(t.Context(), cs.URL, testCreds, opts)
+	require.NoError(t, err)
+	defer s.Close()
+	names := toolNames(s)
+	assert.Greater(t, len(names), 500)
+	assert.Contains(t, names, "deployVirtualMachine")
+}
+
+func Test_RefreshCatalog(t *testing.T) {
+	s := newTestServer(t, `[{"name":"listZones","description":"Lists zones","params":[]}]`, nil, mcp.ServerOpts{})
+
+	s.cs.setApis(`[{"name":"listZones","description":"Lists zones","params":[]},{"name":"listKubernetesClusters","description":"Lists Kubernetes clusters","params":[]}]`)
+
+	refreshed := s.callTool("cs_refresh_api_catalog", `{}`)
+	assert.Contains(t, refreshed, `{\"added\":[\"listKubernetesClusters\"],\"removed\":[],\"changed\":[]}`)
+
+	tools := s.request("tools/list", `{}`)
+	assert.Contains(t, tools, `"name":"listKubernetesClusters"`)
+}
+
+func Test_Server_Close(t *testing.T) {
+	// nothing listens on the API URL, so the server keeps retrying listApis in the background
+	s, err := mcp.NewServer(t.Context(), "http://127.0.0.1:1/client/api", testCreds, mcp.ServerOpts{
+		CatalogCacheDir:        t.TempDir(),
+		CatalogRefreshInterval: time.Millisecond,
+	})
+	require.NoError(t, err)
+
+	closed := make(chan struct{})
+	go func() {
+		s.Close()
+		close(closed)
+	}()
+
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Close did not stop the background catalog refreshes")
+	}
+}
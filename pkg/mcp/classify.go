@@ -0,0 +1,68 @@
+package mcp
+
+// apiClass is how much damage calling an API can do
+type apiClass string
+
+const (
+	classRead    apiClass = "read"
+	classMutate  apiClass = "mutate"
+	classDestroy apiClass = "destroy"
+)
+
+// destroyVerbs are the API verbs that delete or irreversibly discard something
+var destroyVerbs = map[string]bool{
+	"delete":       true,
+	"destroy":      true,
+	"remove":       true,
+	"expunge":      true,
+	"purge":        true,
+	"release":      true,
+	"revoke":       true,
+	"disassociate": true,
+	"cleanup":      true,
+}
+
+// classOverrides are APIs whose verb does not tell what they do
+var classOverrides = map[string]apiClass{
+	// reads that do not start with a read verb
+	"login":           classRead,
+	"logout":          classRead,
+	"quotaBalance":    classRead,
+	"quotaStatement":  classRead,
+	"quotaSummary":    classRead,
+	"quotaTariffList": classRead,
+
+	// mutations that throw data away
+	"restoreVirtualMachine":          classDestroy,
+	"revertSnapshot":                 classDestroy,
+	"revertToVMSnapshot":             classDestroy,
+	"resetPasswordForVirtualMachine": classDestroy,
+	"resetSSHKeyForVirtualMachine":   classDestroy,
+	"registerUserKeys":               classDestroy,
+	"disableAccount":                 classDestroy,
+	"lockAccount":                    classDestroy,
+	"lockUser":                       classDestroy,
+	"disableUser":                    classDestroy,
+	"detachVolume":                   classDestroy,
+}
+
+// classifyApi returns the class of the named API, from the override table or its verb
+func classifyApi(name string) apiClass {
+	if class, ok := classOverrides[name]; ok {
+		return class
+	}
+
+	verb := apiVerb(name)
+
+	for _, v := range readVerbs {
+		if verb == v {
+			return classRead
+		}
+	}
+
+	if destroyVerbs[verb] {
+		return classDestroy
+	}
+
+	return classMutate
+}
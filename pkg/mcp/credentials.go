@@ -0,0 +1,148 @@
+package mcp
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+	"github.com/rs/zerolog"
+	"github.com/walteh/cloudstack-mcp/pkg/cloudstack"
+	errors "gitlab.com/tozd/go/errors"
+)
+
+const authenticateToolName = "cs_authenticate"
+
+// SessionCredentialsContextFunc is an SSE context func that puts the API key
+// pair a client sends in the cloudstack.APIKeyHeader and
+// cloudstack.SecretKeyHeader headers on the context of its requests
+func SessionCredentialsContextFunc(ctx context.Context, r *http.Request) context.Context {
+	if creds, ok := cloudstack.CredentialsFromHeader(r.Header); ok {
+		return cloudstack.WithCredentials(ctx, creds)
+	}
+	return ctx
+}
+
+var _ server.SSEContextFunc = SessionCredentialsContextFunc
+
+// sessionCredentials are the credentials sessions authenticated with through cs_authenticate
+type sessionCredentials struct {
+	mu    sync.Mutex
+	creds map[string]cloudstack.Credentials
+}
+
+func newSessionCredentials() *sessionCredentials {
+	return &sessionCredentials{
+		creds: map[string]cloudstack.Credentials{},
+	}
+}
+
+func (c *sessionCredentials) set(session string, creds cloudstack.Credentials) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.creds[session] = creds
+}
+
+func (c *sessionCredentials) get(session string) (cloudstack.Credentials, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	creds, ok := c.creds[session]
+	return creds, ok
+}
+
+func (c *sessionCredentials) remove(session string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.creds, session)
+}
+
+// addSessionCredentialsHooks forgets the credentials of sessions that are gone
+func (s *Server) addSessionCredentialsHooks(hooks *server.Hooks) {
+	hooks.AddOnUnregisterSession(func(ctx context.Context, session server.ClientSession) {
+		s.sessionCreds.remove(session.SessionID())
+	})
+}
+
+// clientCredentials returns the credentials the client behind ctx supplied:
+// those of its request headers, or else those it authenticated its session with
+func (s *Server) clientCredentials(ctx context.Context) (cloudstack.Credentials, bool) {
+	if creds, ok := cloudstack.CredentialsFromContext(ctx); ok {
+		return creds, true
+	}
+	if session := sessionID(ctx); session != "" {
+		return s.sessionCreds.get(session)
+	}
+	return cloudstack.Credentials{}, false
+}
+
+// withClientCredentials returns ctx carrying the client's credentials. Without
+// session credentials the server's own are used and ctx is returned as is.
+func (s *Server) withClientCredentials(ctx context.Context) (context.Context, error) {
+	if !s.opts.SessionCredentials {
+		return ctx, nil
+	}
+
+	creds, ok := s.clientCredentials(ctx)
+	if !ok {
+		return nil, errors.Errorf("this session has no CloudStack credentials: send the %s and %s headers, or call %s first", cloudstack.APIKeyHeader, cloudstack.SecretKeyHeader, authenticateToolName)
+	}
+
+	return cloudstack.WithCredentials(ctx, creds), nil
+}
+
+// credentialsIdentity tells apart the users of an endpoint without revealing their secrets
+func credentialsIdentity(creds cloudstack.Credentials) string {
+	if creds.Mode == cloudstack.AuthModeAPIKey {
+		return creds.APIKey
+	}
+	if creds.Domain != "" {
+		return creds.Domain + "/" + creds.Username
+	}
+	return creds.Username
+}
+
+// registerAuthenticateTool registers the tool a session supplies its own credentials with
+func (s *Server) registerAuthenticateTool() {
+	if !s.opts.SessionCredentials {
+		return
+	}
+
+	tool := mcp.NewTool(authenticateToolName,
+		mcp.WithDescription("Authenticate this session with a CloudStack API key pair. Every later call of the session is made as that user, with its permissions."),
+		mcp.WithString("apikey", mcp.Required(), mcp.Description("the API key of the CloudStack user")),
+		mcp.WithString("secretkey", mcp.Required(), mcp.Description("the secret key of the CloudStack user")),
+		mcp.WithTitleAnnotation("Authenticate Session"),
+		mcp.WithReadOnlyHintAnnotation(false),
+		mcp.WithDestructiveHintAnnotation(false),
+		mcp.WithIdempotentHintAnnotation(true),
+		mcp.WithOpenWorldHintAnnotation(false),
+	)
+
+	s.mcpServer.AddTool(tool, func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		logger := zerolog.Ctx(ctx)
+
+		session := sessionID(ctx)
+		if session == "" {
+			return mcp.NewToolResultError("there is no session to authenticate"), nil
+		}
+
+		creds := cloudstack.Credentials{Mode: cloudstack.AuthModeAPIKey}
+		creds.APIKey, _ = req.GetArguments()["apikey"].(string)
+		creds.SecretKey, _ = req.GetArguments()["secretkey"].(string)
+		if err := creds.Validate(); err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+
+		// check the keys before keeping them, so a typo is reported here and not by the next call
+		if _, err := cloudstack.DoRawCloudStackRequest(cloudstack.WithCredentials(ctx, creds), s.apiURL, "listCapabilities", creds, map[string]string{}); err != nil {
+			return apiErrorResult(s.api("listCapabilities"), "listCapabilities", err)
+		}
+
+		s.sessionCreds.set(session, creds)
+		logger.Info().Str("session", session).Msg("Authenticated session")
+
+		return mcp.NewToolResultText(fmt.Sprintf("authenticated, the calls of this session are now made with API key %s", creds.APIKey)), nil
+	})
+}
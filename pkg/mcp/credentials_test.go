@@ -0,0 +1,115 @@
+package mcp_test
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	mcplib "github.com/mark3labs/mcp-go/mcp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/walteh/cloudstack-mcp/pkg/cloudstack"
+	"github.com/walteh/cloudstack-mcp/pkg/mcp"
+)
+
+func Test_SessionCredentials(t *testing.T) {
+	var mu sync.Mutex
+	apiKeys := []string{}
+	// vmStates is the state of vm1 as seen by each API key
+	vmStates := map[string]string{"alice": "Running", "bob": "Running"}
+
+	apis := `[
+		{"name":"listZones","params":[]},
+		{"name":"listVirtualMachines","params":[{"name":"id","type":"uuid"}]}
+	]`
+
+	s := newTestServer(t, apis, func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		apiKey := r.URL.Query().Get("apiKey")
+		switch r.URL.Query().Get("command") {
+		case "listZones":
+			apiKeys = append(apiKeys, apiKey)
+			fmt.Fprint(w, `{"listzonesresponse":{"count":0}}`)
+		case "listVirtualMachines":
+			fmt.Fprintf(w, `{"listvirtualmachinesresponse":{"count":1,"virtualmachine":[{"id":"vm1","state":%q}]}}`, vmStates[apiKey])
+		}
+	}, mcp.ServerOpts{SessionCredentials: true, ResourcePollInterval: 10 * time.Millisecond})
+
+	lastAPIKey := func() string {
+		mu.Lock()
+		defer mu.Unlock()
+		require.NotEmpty(t, apiKeys)
+		return apiKeys[len(apiKeys)-1]
+	}
+
+	// alice sends her keys in the headers of every request
+	alice := &testSession{id: "alice", notifications: make(chan mcplib.JSONRPCNotification, 10)}
+	s.withSession(alice)
+	s.ctx = cloudstack.WithCredentials(s.ctx, cloudstack.Credentials{Mode: cloudstack.AuthModeAPIKey, APIKey: "alice", SecretKey: "a"})
+
+	// bob authenticates his session through the tool
+	bob := &testSession{id: "bob", notifications: make(chan mcplib.JSONRPCNotification, 10)}
+	b := &testServer{Server: s.Server, t: t, cs: s.cs, ctx: t.Context()}
+	b.withSession(bob)
+
+	t.Run("header credentials", func(t *testing.T) {
+		text, isErr := s.callToolText("listZones", `{}`)
+		require.False(t, isErr, text)
+		assert.Equal(t, "alice", lastAPIKey())
+	})
+
+	t.Run("no credentials", func(t *testing.T) {
+		text, isErr := b.callToolText("listZones", `{}`)
+		assert.True(t, isErr)
+		assert.Contains(t, text, "cs_authenticate")
+	})
+
+	t.Run("authenticate", func(t *testing.T) {
+		text, isErr := b.callToolText("cs_authenticate", `{"apikey":"bob","secretkey":"b"}`)
+		require.False(t, isErr, text)
+
+		text, isErr = b.callToolText("listZones", `{}`)
+		require.False(t, isErr, text)
+		assert.Equal(t, "bob", lastAPIKey())
+
+		// the header credentials of alice still win for her session
+		text, isErr = s.callToolText("listZones", `{}`)
+		require.False(t, isErr, text)
+		assert.Equal(t, "alice", lastAPIKey())
+	})
+
+	t.Run("resources are polled per user", func(t *testing.T) {
+		assert.NotContains(t, s.request("resources/subscribe", `{"uri":"cloudstack://vm/vm1"}`), `"error"`)
+		assert.NotContains(t, b.request("resources/subscribe", `{"uri":"cloudstack://vm/vm1"}`), `"error"`)
+
+		mu.Lock()
+		vmStates["bob"] = "Stopped"
+		mu.Unlock()
+
+		select {
+		case n := <-bob.notifications:
+			assert.Equal(t, "cloudstack://vm/vm1", n.Params.AdditionalFields["uri"])
+		case <-time.After(5 * time.Second):
+			t.Fatal("no resources/updated notification for bob")
+		}
+
+		select {
+		case n := <-alice.notifications:
+			t.Fatalf("alice was notified of a change only bob can see: %v", n)
+		case <-time.After(50 * time.Millisecond):
+		}
+	})
+}
+
+func Test_SessionCredentials_Profiles(t *testing.T) {
+	cs := newCloudStackStub(t, `[]`, nil)
+
+	profiles := &cloudstack.Profiles{Default: "a", Profiles: []cloudstack.Profile{{Name: "a", APIURL: cs.URL, Credentials: testCreds}}}
+
+	_, err := mcp.NewServer(t.Context(), cs.URL, testCreds, mcp.ServerOpts{SessionCredentials: true, Profiles: profiles, CatalogCacheDir: t.TempDir()})
+	assert.ErrorContains(t, err, "cannot be combined with profiles")
+}
@@ -0,0 +1,120 @@
+package mcp
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	csgo "github.com/apache/cloudstack-go/v2/cloudstack"
+	"github.com/mark3labs/mcp-go/mcp"
+	errors "gitlab.com/tozd/go/errors"
+)
+
+// ToolMode selects how CloudStack APIs are exposed as MCP tools
+type ToolMode string
+
+const (
+	// ToolModeFlat registers one tool per CloudStack API
+	ToolModeFlat ToolMode = "flat"
+	// ToolModeGrouped registers only meta-tools to search, describe and call any API
+	ToolModeGrouped ToolMode = "grouped"
+)
+
+// ParseToolMode parses a tool mode name, defaulting to flat when empty
+func ParseToolMode(s string) (ToolMode, error) {
+	switch ToolMode(s) {
+	case "", ToolModeFlat:
+		return ToolModeFlat, nil
+	case ToolModeGrouped:
+		return ToolModeGrouped, nil
+	default:
+		return "", errors.Errorf("unknown tool mode %q (expected %q or %q)", s, ToolModeFlat, ToolModeGrouped)
+	}
+}
+
+const (
+	describeApiToolName = "cs_describe_api"
+	callApiToolName     = "cs_call_api"
+)
+
+// exposedApi returns the named API if the policy allows calling it
+func (s *Server) exposedApi(name string) (*csgo.Api, error) {
+	api := s.api(name)
+	if api == nil || !s.opts.Policy.Allows(api) {
+		return nil, errors.Errorf("unknown API %q, use %s to find the API to call", name, searchApisToolName)
+	}
+	return api, nil
+}
+
+func (s *Server) registerGroupedTools() {
+	describe := mcp.NewTool(describeApiToolName,
+		mcp.WithDescription(fmt.Sprintf("Describe a CloudStack API: its description, behaviour hints and the JSON schema of the arguments to pass to %s.", callApiToolName)),
+		mcp.WithString("name", mcp.Required(), mcp.Description("the API name, e.g. listVirtualMachines")),
+		mcp.WithTitleAnnotation("Describe CloudStack API"),
+		mcp.WithReadOnlyHintAnnotation(true),
+		mcp.WithDestructiveHintAnnotation(false),
+		mcp.WithIdempotentHintAnnotation(true),
+		mcp.WithOpenWorldHintAnnotation(false),
+	)
+
+	s.mcpServer.AddTool(describe, func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		name, _ := req.GetArguments()["name"].(string)
+		api, err := s.exposedApi(name)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+
+		tool, err := s.createTool(ctx, api)
+		if err != nil {
+			return nil, err
+		}
+
+		return jsonResult(map[string]any{
+			"name":        tool.Name,
+			"description": tool.Description,
+			"since":       api.Since,
+			"class":       classifyApi(api.Name),
+			"annotations": tool.Annotations,
+			"inputSchema": tool.RawInputSchema,
+		})
+	})
+
+	call := mcp.NewTool(callApiToolName,
+		mcp.WithDescription(fmt.Sprintf("Call any CloudStack API by name. Use %s first to find the arguments it takes.", describeApiToolName)),
+		mcp.WithString("name", mcp.Required(), mcp.Description("the API name, e.g. listVirtualMachines")),
+		mcp.WithObject("arguments", mcp.Description("the API arguments, as described by "+describeApiToolName)),
+		mcp.WithTitleAnnotation("Call CloudStack API"),
+		mcp.WithReadOnlyHintAnnotation(false),
+		mcp.WithDestructiveHintAnnotation(true),
+		mcp.WithIdempotentHintAnnotation(false),
+		mcp.WithOpenWorldHintAnnotation(false),
+	)
+
+	s.mcpServer.AddTool(call, func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		name, _ := req.GetArguments()["name"].(string)
+		if _, err := s.exposedApi(name); err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+
+		args := map[string]any{}
+		if a, ok := req.GetArguments()["arguments"].(map[string]any); ok {
+			for k, v := range a {
+				args[k] = v
+			}
+		}
+
+		inner := req
+		inner.Params.Name = name
+		inner.Params.Arguments = args
+
+		return s.handleDynamicTool(ctx, inner, name)
+	})
+}
+
+func jsonResult(v any) (*mcp.CallToolResult, error) {
+	marsh, err := json.Marshal(v)
+	if err != nil {
+		return nil, errors.Errorf("marshalling result: %w", err)
+	}
+	return mcp.NewToolResultText(string(marsh)), nil
+}
@@ -0,0 +1,41 @@
+package mcp_test
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/walteh/cloudstack-mcp/pkg/mcp"
+)
+
+func Test_GroupedTools(t *testing.T) {
+	apis := `[
+		{"name":"listZones","description":"Lists zones","params":[{"name":"name","type":"string"}]},
+		{"name":"deleteZone","description":"Deletes a zone.","params":[{"name":"id","type":"uuid","required":true}]}
+	]`
+
+	s := newTestServer(t, apis, func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"listzonesresponse":{"count":1,"zone":[{"id":"z1","name":%q}]}}`, r.URL.Query().Get("name"))
+	}, mcp.ServerOpts{
+		ToolMode: mcp.ToolModeGrouped,
+		Policy:   &mcp.Policy{Exclude: mcp.PolicyRules{Verbs: []string{"delete"}}},
+	})
+
+	tools := s.request("tools/list", `{}`)
+	assert.Contains(t, tools, `"name":"cs_search_apis"`)
+	assert.NotContains(t, tools, `"name":"listZones"`)
+
+	search := s.callTool("cs_search_apis", `{"query":"zones"}`)
+	assert.Contains(t, search, `\"name\":\"listZones\"`)
+	assert.NotContains(t, search, `deleteZone`)
+
+	describe := s.callTool("cs_describe_api", `{"name":"listZones"}`)
+	assert.Contains(t, describe, `\"inputSchema\":{`)
+
+	result := s.callTool("cs_call_api", `{"name":"listZones","arguments":{"name":"zone1"}}`)
+	assert.Contains(t, result, `zone1`)
+
+	denied := s.callTool("cs_call_api", `{"name":"deleteZone","arguments":{"id":"z1"}}`)
+	assert.Contains(t, denied, `"isError":true`)
+}
@@ -0,0 +1,130 @@
+package mcp_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/mark3labs/mcp-go/server"
+	"github.com/stretchr/testify/require"
+	"github.com/walteh/cloudstack-mcp/pkg/cloudstack"
+	"github.com/walteh/cloudstack-mcp/pkg/mcp"
+)
+
+// testCreds are the credentials of every test server; the stubs do not check signatures
+var testCreds = cloudstack.Credentials{Mode: cloudstack.AuthModeAPIKey, APIKey: "key", SecretKey: "secret"}
+
+// cloudStackStub is a CloudStack endpoint that answers listCapabilities and
+// listApis itself and hands every other command to the test's handler
+type cloudStackStub struct {
+	*httptest.Server
+
+	apis          atomic.Value
+	listApisCalls atomic.Int32
+}
+
+// newCloudStackStub starts a stub whose listApis returns apis, the JSON array
+// of the listapisresponse
+func newCloudStackStub(t *testing.T, apis string, handler http.HandlerFunc) *cloudStackStub {
+	cs := &cloudStackStub{}
+	cs.apis.Store(apis)
+
+	cs.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Query().Get("command") {
+		case "listCapabilities":
+			fmt.Fprint(w, `{"listcapabilitiesresponse":{"capability":{"cloudstackversion":"4.19.1.0"}}}`)
+		case "listApis":
+			cs.listApisCalls.Add(1)
+			fmt.Fprintf(w, `{"listapisresponse":{"count":1,"api":%s}}`, cs.apis.Load())
+		default:
+			if handler == nil {
+				http.Error(w, "unknown command", http.StatusBadRequest)
+				return
+			}
+			handler(w, r)
+		}
+	}))
+	t.Cleanup(cs.Close)
+
+	return cs
+}
+
+// setApis changes what listApis returns from now on
+func (cs *cloudStackStub) setApis(apis string) {
+	cs.apis.Store(apis)
+}
+
+// testServer is an MCP server in front of a cloudStackStub
+type testServer struct {
+	*mcp.Server
+
+	t   *testing.T
+	cs  *cloudStackStub
+	ctx context.Context
+	ids atomic.Int64
+}
+
+// newTestServer starts a stub serving apis and handler, and an MCP server
+// using it. opts.CatalogCacheDir defaults to a temporary directory.
+func newTestServer(t *testing.T, apis string, handler http.HandlerFunc, opts mcp.ServerOpts) *testServer {
+	cs := newCloudStackStub(t, apis, handler)
+
+	if opts.CatalogCacheDir == "" {
+		opts.CatalogCacheDir = t.TempDir()
+	}
+
+	s, err := mcp.NewServer(t.Context(), cs.URL, testCreds, opts)
+	require.NoError(t, err)
+	t.Cleanup(s.Close)
+
+	return &testServer{Server: s, t: t, cs: cs, ctx: t.Context()}
+}
+
+// withSession registers session with the server and makes later requests on its behalf
+func (s *testServer) withSession(session server.ClientSession) {
+	require.NoError(s.t, s.Server.Server().RegisterSession(s.ctx, session))
+	s.ctx = s.Server.Server().WithContext(s.ctx, session)
+}
+
+// request sends a JSON-RPC request and returns the marshalled response
+func (s *testServer) request(method string, params string) string {
+	msg := fmt.Sprintf(`{"jsonrpc":"2.0","id":%d,"method":%q,"params":%s}`, s.ids.Add(1), method, params)
+
+	resp := s.Server.Server().HandleMessage(s.ctx, []byte(msg))
+	marsh, err := json.Marshal(resp)
+	require.NoError(s.t, err)
+
+	return string(marsh)
+}
+
+// notify sends a JSON-RPC notification
+func (s *testServer) notify(method string, params string) {
+	msg := fmt.Sprintf(`{"jsonrpc":"2.0","method":%q,"params":%s}`, method, params)
+	s.Server.Server().HandleMessage(s.ctx, []byte(msg))
+}
+
+// callTool calls the named tool with args, a JSON object
+func (s *testServer) callTool(name string, args string) string {
+	return s.request("tools/call", fmt.Sprintf(`{"name":%q,"arguments":%s}`, name, args))
+}
+
+// callToolText calls the named tool and returns the text of its result and
+// whether it is an error
+func (s *testServer) callToolText(name string, args string) (string, bool) {
+	var resp struct {
+		Result struct {
+			Content []struct {
+				Text string `json:"text"`
+			} `json:"content"`
+			IsError bool `json:"isError"`
+		} `json:"result"`
+	}
+	require.NoError(s.t, json.Unmarshal([]byte(s.callTool(name, args)), &resp))
+	require.NotEmpty(s.t, resp.Result.Content)
+
+	return resp.Result.Content[0].Text, resp.Result.IsError
+}
@@ -0,0 +1,157 @@
+package mcp
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/rs/zerolog"
+	"github.com/walteh/cloudstack-mcp/pkg/cloudstack"
+	errors "gitlab.com/tozd/go/errors"
+)
+
+const waitForJobToolName = "cs_wait_for_job"
+
+// registerJobTools registers the tools that operate on async jobs
+func (s *Server) registerJobTools() {
+	tool := mcp.NewTool(waitForJobToolName, append([]mcp.ToolOption{
+		mcp.WithDescription("Wait for a CloudStack async job to finish and return its result"),
+		mcp.WithString("jobid", mcp.Required(), mcp.Description("the id of the async job")),
+		mcp.WithNumber("timeout", mcp.Description("seconds to wait before returning the job as still pending"), mcp.Min(0)),
+		mcp.WithTitleAnnotation("Wait For Async Job"),
+		mcp.WithReadOnlyHintAnnotation(true),
+		mcp.WithDestructiveHintAnnotation(false),
+		mcp.WithIdempotentHintAnnotation(true),
+		mcp.WithOpenWorldHintAnnotation(false),
+	}, s.profileToolOption()...)...)
+
+	s.mcpServer.AddTool(tool, func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		// jobs are only known to the endpoint that started them
+		ctx, err := s.requestProfile(ctx, req)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+
+		jobID, _ := req.GetArguments()["jobid"].(string)
+		if jobID == "" {
+			return mcp.NewToolResultError("jobid is required"), nil
+		}
+
+		timeout := s.opts.JobTimeout
+		if t, ok := req.GetArguments()["timeout"].(float64); ok && t > 0 {
+			timeout = time.Duration(t * float64(time.Second))
+		}
+
+		return s.waitForJob(ctx, "queryAsyncJobResult", jobID, timeout, progressToken(req), shapeOptions{})
+	})
+}
+
+// handleAsyncJob returns the result of an async API call that responded with jobID
+func (s *Server) handleAsyncJob(ctx context.Context, req mcp.CallToolRequest, apiName string, params map[string]string, jobID string, opts callOptions) (*mcp.CallToolResult, error) {
+	logger := zerolog.Ctx(ctx)
+
+	if opts.fireAndForget {
+		return jobPendingResult(&cloudstack.AsyncJob{JobID: jobID})
+	}
+
+	timeout := s.opts.JobTimeout
+	if opts.jobTimeout > 0 {
+		timeout = opts.jobTimeout
+	}
+
+	res, err := s.waitForJob(ctx, apiName, jobID, timeout, progressToken(req), opts.shape)
+	if err == nil && res.IsError && errors.Is(ctx.Err(), context.Canceled) {
+		// the request context is gone, but CloudStack still has to be told to stop
+		cancelled := s.cancelJob(context.WithoutCancel(ctx), apiName, params)
+		logger.Info().Str("jobid", jobID).Bool("cloudstack_cancelled", cancelled).Msg("Stopped waiting for cancelled async job")
+		return mcp.NewToolResultError(fmt.Sprintf("cancelled while waiting for async job %s (cancelled in CloudStack: %t)", jobID, cancelled)), nil
+	}
+
+	return res, err
+}
+
+// waitForJob polls the job until it finishes or the timeout passes, reporting
+// progress to the client when it asked for it. The result of the job is shaped
+// like the response of apiName would be. A failed job, and a failure to query
+// it, are returned as tool errors.
+func (s *Server) waitForJob(ctx context.Context, apiName string, jobID string, timeout time.Duration, token mcp.ProgressToken, shape shapeOptions) (*mcp.CallToolResult, error) {
+	opts := cloudstack.WaitOptions{Timeout: timeout}
+
+	if token != nil {
+		opts.OnPoll = func(job *cloudstack.AsyncJob, elapsed time.Duration) {
+			s.sendJobProgress(ctx, token, job, elapsed, timeout)
+		}
+	}
+
+	job, err := cloudstack.WaitForAsyncJob(ctx, s.call, jobID, opts)
+
+	var ferr *cloudstack.JobFailedError
+	var terr *cloudstack.JobTimeoutError
+
+	switch {
+	case errors.As(err, &ferr):
+		marsh, err := json.Marshal(ferr)
+		if err != nil {
+			return nil, errors.Errorf("error marshalling job failure: %w", err)
+		}
+		return mcp.NewToolResultError(string(marsh)), nil
+	case errors.As(err, &terr):
+		return jobPendingResult(terr.Job)
+	case err != nil:
+		return apiErrorResult(s.api("queryAsyncJobResult"), "queryAsyncJobResult", err)
+	}
+
+	if len(job.JobResult) == 0 {
+		return mcp.NewToolResultText(string(job.JobResult)), nil
+	}
+
+	if shape.maxBytes == 0 {
+		shape.maxBytes = s.opts.MaxResponseBytes
+	}
+
+	return shapeResponse(apiName, job.JobResult, shape)
+}
+
+// sendJobProgress sends a notifications/progress message for a pending job. The
+// elapsed seconds are used as the progress value since CloudStack does not report
+// how far along a job is.
+func (s *Server) sendJobProgress(ctx context.Context, token mcp.ProgressToken, job *cloudstack.AsyncJob, elapsed, timeout time.Duration) {
+	logger := zerolog.Ctx(ctx)
+
+	params := map[string]any{
+		"progressToken": token,
+		"progress":      elapsed.Seconds(),
+		"message":       fmt.Sprintf("job %s: jobstatus=%d jobprocstatus=%d elapsed=%s", job.JobID, job.JobStatus, job.JobProcStatus, elapsed.Round(time.Second)),
+	}
+	if timeout > 0 {
+		params["total"] = timeout.Seconds()
+	}
+
+	if err := s.mcpServer.SendNotificationToClient(ctx, "notifications/progress", params); err != nil {
+		logger.Debug().Err(err).Msg("Failed to send progress notification")
+	}
+}
+
+// progressToken returns the progress token of the request, or nil if the client did not ask for progress
+func progressToken(req mcp.CallToolRequest) mcp.ProgressToken {
+	if req.Params.Meta == nil {
+		return nil
+	}
+	return req.Params.Meta.ProgressToken
+}
+
+func jobPendingResult(job *cloudstack.AsyncJob) (*mcp.CallToolResult, error) {
+	marsh, err := json.Marshal(map[string]any{
+		"jobid":         job.JobID,
+		"jobstatus":     job.JobStatus,
+		"jobprocstatus": job.JobProcStatus,
+		"message":       "the job is still running, call " + waitForJobToolName + " with this jobid to get its result",
+	})
+	if err != nil {
+		return nil, errors.Errorf("error marshalling pending job: %w", err)
+	}
+
+	return mcp.NewToolResultText(string(marsh)), nil
+}
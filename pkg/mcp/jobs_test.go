@@ -0,0 +1,146 @@
+package mcp_test
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	mcplib "github.com/mark3labs/mcp-go/mcp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/walteh/cloudstack-mcp/pkg/mcp"
+)
+
+func Test_WaitForJob_QueryError(t *testing.T) {
+	s := newTestServer(t, `[{"name":"queryAsyncJobResult","params":[{"name":"jobid","type":"uuid","required":true}]}]`, func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "proxy error", http.StatusBadGateway)
+	}, mcp.ServerOpts{})
+
+	// a job that cannot be queried is a tool error the model can report, not a failed request
+	text, isError := s.callToolText("cs_wait_for_job", `{"jobid":"job1"}`)
+	assert.True(t, isError)
+	assert.Contains(t, text, "calling queryAsyncJobResult failed")
+}
+
+func Test_AsyncJob_Progress(t *testing.T) {
+	var polls atomic.Int32
+
+	s := newTestServer(t, `[{"name":"startVirtualMachine","isasync":true,"params":[{"name":"id","type":"uuid","required":true}]}]`, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Query().Get("command") {
+		case "startVirtualMachine":
+			fmt.Fprint(w, `{"startvirtualmachineresponse":{"jobid":"job1"}}`)
+		case "queryAsyncJobResult":
+			if polls.Add(1) == 1 {
+				fmt.Fprint(w, `{"queryasyncjobresultresponse":{"jobid":"job1","jobstatus":0,"jobprocstatus":3}}`)
+				return
+			}
+			fmt.Fprint(w, `{"queryasyncjobresultresponse":{"jobid":"job1","jobstatus":1,"jobresult":{"virtualmachine":{"state":"Running"}}}}`)
+		}
+	}, mcp.ServerOpts{})
+
+	session := &testSession{notifications: make(chan mcplib.JSONRPCNotification, 10)}
+	s.withSession(session)
+
+	resp := s.request("tools/call", `{"name":"startVirtualMachine","arguments":{"id":"a2c3e4f5-0000-4000-8000-000000000001"},"_meta":{"progressToken":"start-1"}}`)
+	assert.Contains(t, resp, `{\"virtualmachine\":{\"state\":\"Running\"}}`)
+
+	select {
+	case n := <-session.notifications:
+		assert.Equal(t, "notifications/progress", n.Method)
+		assert.Equal(t, "start-1", n.Params.AdditionalFields["progressToken"])
+		assert.Contains(t, n.Params.AdditionalFields["message"], "job job1: jobstatus=0 jobprocstatus=3")
+	default:
+		t.Fatal("no progress notification")
+	}
+}
+
+func Test_AsyncJob_Cancelled(t *testing.T) {
+	polled := make(chan struct{}, 1)
+	var cancelled atomic.Value
+
+	apis := `[
+		{"name":"prepareHostForMaintenance","isasync":true,"params":[{"name":"id","type":"uuid","required":true}]},
+		{"name":"cancelHostMaintenance","isasync":true,"params":[{"name":"id","type":"uuid","required":true}]}
+	]`
+
+	s := newTestServer(t, apis, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Query().Get("command") {
+		case "prepareHostForMaintenance":
+			fmt.Fprint(w, `{"preparehostformaintenanceresponse":{"jobid":"job1"}}`)
+		case "queryAsyncJobResult":
+			select {
+			case polled <- struct{}{}:
+			default:
+			}
+			fmt.Fprint(w, `{"queryasyncjobresultresponse":{"jobid":"job1","jobstatus":0}}`)
+		case "cancelHostMaintenance":
+			cancelled.Store(r.URL.Query().Get("id"))
+			fmt.Fprint(w, `{"cancelhostmaintenanceresponse":{"jobid":"job2"}}`)
+		}
+	}, mcp.ServerOpts{})
+
+	session := &testSession{notifications: make(chan mcplib.JSONRPCNotification, 10)}
+	s.withSession(session)
+
+	result := make(chan string, 1)
+	go func() {
+		result <- s.callTool("prepareHostForMaintenance", `{"id":"a2c3e4f5-0000-4000-8000-000000000001"}`)
+	}()
+
+	select {
+	case <-polled:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the job was never polled")
+	}
+
+	// the call is the only request in flight, so it holds the last id
+	s.notify("notifications/cancelled", fmt.Sprintf(`{"requestId":%d}`, s.ids.Load()))
+
+	select {
+	case resp := <-result:
+		assert.Contains(t, resp, `"isError":true`)
+		assert.Contains(t, resp, "cancelled while waiting for async job job1 (cancelled in CloudStack: true)")
+	case <-time.After(5 * time.Second):
+		t.Fatal("the call was not cancelled")
+	}
+
+	require.Equal(t, "a2c3e4f5-0000-4000-8000-000000000001", cancelled.Load())
+}
+
+func Test_AsyncJob_Shaped(t *testing.T) {
+	vm := `{"virtualmachine":{"id":"vm1","name":"web1","state":"Running","userdata":"` + strings.Repeat("x", 500) + `"}}`
+
+	s := newTestServer(t, `[{"name":"deployVirtualMachine","isasync":true,"params":[{"name":"zoneid","type":"uuid","required":true}]}]`, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Query().Get("command") {
+		case "deployVirtualMachine":
+			fmt.Fprint(w, `{"deployvirtualmachineresponse":{"jobid":"job1"}}`)
+		case "queryAsyncJobResult":
+			fmt.Fprintf(w, `{"queryasyncjobresultresponse":{"jobid":"job1","jobstatus":1,"jobresult":%s}}`, vm)
+		}
+	}, mcp.ServerOpts{MaxResponseBytes: 200})
+
+	zone := `"zoneid":"a2c3e4f5-0000-4000-8000-000000000001"`
+
+	t.Run("projected", func(t *testing.T) {
+		text, isError := s.callToolText("deployVirtualMachine", `{`+zone+`,"fields":["id","state"]}`)
+		require.False(t, isError, text)
+		assert.JSONEq(t, `{"virtualmachine":{"id":"vm1","state":"Running"}}`, text)
+	})
+
+	t.Run("truncated to the server default", func(t *testing.T) {
+		text, isError := s.callToolText("deployVirtualMachine", `{`+zone+`}`)
+		require.False(t, isError, text)
+		assert.True(t, strings.HasSuffix(text, "... [truncated]"), text)
+		assert.LessOrEqual(t, len(text), 200+len("\n... [truncated]"))
+	})
+
+	t.Run("options are offered", func(t *testing.T) {
+		resp := s.request("tools/list", `{}`)
+		for _, option := range []string{"fields", "summary", "max_bytes", "include_raw"} {
+			assert.Contains(t, resp, `"`+option+`":{`)
+		}
+	})
+}
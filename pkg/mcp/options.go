@@ -0,0 +1,236 @@
+package mcp
+
+import (
+	"strings"
+	"time"
+
+	csgo "github.com/apache/cloudstack-go/v2/cloudstack"
+	"github.com/invopop/jsonschema"
+	"github.com/walteh/cloudstack-mcp/pkg/cloudstack"
+	errors "gitlab.com/tozd/go/errors"
+)
+
+// Reserved tool arguments that control how a call is made instead of being
+// forwarded to CloudStack. An API parameter with the same name always wins.
+const (
+	argFireAndForget = "fire_and_forget"
+	argJobTimeout    = "job_timeout"
+	argAutoPaginate  = "auto_paginate"
+	argMaxItems      = "max_items"
+	argCursor        = "cursor"
+	argFields        = "fields"
+	argSummary       = "summary"
+	argMaxBytes      = "max_bytes"
+	argIncludeRaw    = "include_raw"
+	argConfirm       = "confirm"
+	argDryRun        = "dry_run"
+	argProfile       = "profile"
+)
+
+// callOptions are the control arguments of a single tool call
+type callOptions struct {
+	// fireAndForget returns the job id of an async API without waiting for it
+	fireAndForget bool
+	// jobTimeout overrides ServerOpts.JobTimeout for this call
+	jobTimeout time.Duration
+	// autoPaginate walks every page of a list API
+	autoPaginate bool
+	// maxItems lowers ServerOpts.MaxListItems for this call
+	maxItems int
+	// cursor continues a truncated auto-paginated listing
+	cursor string
+	// shape controls how the response is rendered
+	shape shapeOptions
+	// confirm runs a previewed mutating call in safe mode
+	confirm bool
+	// dryRun previews a mutating call in safe mode instead of running it
+	dryRun bool
+}
+
+// splitArguments separates the control arguments from the arguments that are
+// forwarded to CloudStack
+func splitArguments(api *csgo.Api, args map[string]any) (map[string]any, callOptions, error) {
+	opts := callOptions{}
+	params := make(map[string]any, len(args))
+
+	for key, value := range args {
+		if api != nil && cloudstack.HasParam(api, key) {
+			params[key] = value
+			continue
+		}
+
+		switch key {
+		case argFireAndForget:
+			b, ok := value.(bool)
+			if !ok {
+				return nil, opts, errors.Errorf("%s must be a boolean", key)
+			}
+			opts.fireAndForget = b
+		case argJobTimeout:
+			f, ok := value.(float64)
+			if !ok || f < 0 {
+				return nil, opts, errors.Errorf("%s must be a positive number of seconds", key)
+			}
+			opts.jobTimeout = time.Duration(f * float64(time.Second))
+		case argAutoPaginate:
+			b, ok := value.(bool)
+			if !ok {
+				return nil, opts, errors.Errorf("%s must be a boolean", key)
+			}
+			opts.autoPaginate = b
+		case argMaxItems:
+			f, ok := value.(float64)
+			if !ok || f < 1 {
+				return nil, opts, errors.Errorf("%s must be a positive number", key)
+			}
+			opts.maxItems = int(f)
+		case argCursor:
+			c, ok := value.(string)
+			if !ok {
+				return nil, opts, errors.Errorf("%s must be a string", key)
+			}
+			opts.cursor = c
+		case argFields:
+			fields, err := stringList(value)
+			if err != nil {
+				return nil, opts, errors.Errorf("%s: %w", key, err)
+			}
+			opts.shape.fields = fields
+		case argSummary:
+			b, ok := value.(bool)
+			if !ok {
+				return nil, opts, errors.Errorf("%s must be a boolean", key)
+			}
+			opts.shape.summary = b
+		case argMaxBytes:
+			f, ok := value.(float64)
+			if !ok || f < 1 {
+				return nil, opts, errors.Errorf("%s must be a positive number", key)
+			}
+			opts.shape.maxBytes = int(f)
+		case argIncludeRaw:
+			b, ok := value.(bool)
+			if !ok {
+				return nil, opts, errors.Errorf("%s must be a boolean", key)
+			}
+			opts.shape.includeRaw = b
+		case argConfirm:
+			b, ok := value.(bool)
+			if !ok {
+				return nil, opts, errors.Errorf("%s must be a boolean", key)
+			}
+			opts.confirm = b
+		case argDryRun:
+			b, ok := value.(bool)
+			if !ok {
+				return nil, opts, errors.Errorf("%s must be a boolean", key)
+			}
+			opts.dryRun = b
+		case argProfile:
+			// already applied to the context by handleDynamicTool
+		default:
+			params[key] = value
+		}
+	}
+
+	return params, opts, nil
+}
+
+// addCallOptionsToSchema adds the control arguments that apply to api to its input schema
+func addCallOptionsToSchema(sch *jsonschema.Schema, api *csgo.Api, serverOpts ServerOpts) {
+	addOption := func(name string, prop *jsonschema.Schema) {
+		if cloudstack.HasParam(api, name) {
+			return
+		}
+		sch.Properties.Set(name, prop)
+	}
+
+	if api.Isasync {
+		addOption(argFireAndForget, &jsonschema.Schema{
+			Type:        "boolean",
+			Description: "Return the async job id immediately instead of waiting for the job to finish; use cs_wait_for_job to collect the result",
+		})
+		addOption(argJobTimeout, &jsonschema.Schema{
+			Type:        "number",
+			Description: "Seconds to wait for the async job before returning its job id",
+			Minimum:     "0",
+		})
+	}
+
+	if isPaginated(api) {
+		addOption(argAutoPaginate, &jsonschema.Schema{
+			Type:        "boolean",
+			Description: "Fetch every page and return the complete, deduplicated result set instead of a single page",
+		})
+		addOption(argMaxItems, &jsonschema.Schema{
+			Type:        "number",
+			Description: "Maximum number of items to return when auto paginating; a cursor is returned if more remain",
+			Minimum:     "1",
+		})
+		addOption(argCursor, &jsonschema.Schema{
+			Type:        "string",
+			Description: "Cursor returned by a previous truncated auto paginated call, to continue where it stopped",
+		})
+	}
+
+	// the result of an async API is shaped too, once its job finished
+	addOption(argFields, &jsonschema.Schema{
+		Type:        "array",
+		Description: "Only return these keys of the response, or of each item for list APIs",
+		Items:       &jsonschema.Schema{Type: "string"},
+	})
+	addOption(argSummary, &jsonschema.Schema{
+		Type:        "boolean",
+		Description: "Return a compact markdown table instead of JSON",
+	})
+	addOption(argMaxBytes, &jsonschema.Schema{
+		Type:        "number",
+		Description: "Truncate the response to roughly this many bytes, dropping list items that do not fit",
+		Minimum:     "1",
+	})
+	addOption(argIncludeRaw, &jsonschema.Schema{
+		Type:        "boolean",
+		Description: "Also attach the full, unmodified CloudStack response as an embedded resource",
+	})
+
+	if prop := profileSchema(serverOpts.Profiles); prop != nil {
+		addOption(argProfile, prop)
+	}
+
+	if serverOpts.SafeMode && classifyApi(api.Name) != classRead {
+		addOption(argDryRun, &jsonschema.Schema{
+			Type:        "boolean",
+			Description: "Safe mode: describe what this call would change without running it. Required before confirm",
+		})
+		addOption(argConfirm, &jsonschema.Schema{
+			Type:        "boolean",
+			Description: "Safe mode: run this call after it was previewed with dry_run and the same arguments",
+		})
+	}
+}
+
+// stringList accepts either an array of strings or a comma separated string
+func stringList(value any) ([]string, error) {
+	switch v := value.(type) {
+	case string:
+		list := []string{}
+		for _, s := range strings.Split(v, ",") {
+			if s = strings.TrimSpace(s); s != "" {
+				list = append(list, s)
+			}
+		}
+		return list, nil
+	case []any:
+		list := make([]string, 0, len(v))
+		for _, item := range v {
+			s, ok := item.(string)
+			if !ok {
+				return nil, errors.New("must be a list of strings")
+			}
+			list = append(list, s)
+		}
+		return list, nil
+	default:
+		return nil, errors.New("must be a list of strings")
+	}
+}
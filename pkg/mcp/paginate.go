@@ -0,0 +1,211 @@
+package mcp
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"strconv"
+	"strings"
+
+	csgo "github.com/apache/cloudstack-go/v2/cloudstack"
+	"github.com/rs/zerolog"
+	"github.com/walteh/cloudstack-mcp/pkg/cloudstack"
+	errors "gitlab.com/tozd/go/errors"
+)
+
+const (
+	// defaultPageSize matches CloudStack's default.page.size setting
+	defaultPageSize = 500
+	// defaultMaxListItems caps auto pagination when ServerOpts.MaxListItems is not set
+	defaultMaxListItems = 2000
+)
+
+// pageCursor is where a truncated auto-paginated listing continues
+type pageCursor struct {
+	Page     int `json:"page"`
+	Offset   int `json:"offset"`
+	PageSize int `json:"pagesize"`
+}
+
+func (c pageCursor) encode() string {
+	marsh, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(marsh)
+}
+
+func decodePageCursor(s string) (pageCursor, error) {
+	var c pageCursor
+
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return c, errors.Errorf("decoding cursor: %w", err)
+	}
+
+	if err := json.Unmarshal(raw, &c); err != nil {
+		return c, errors.Errorf("unmarshalling cursor: %w", err)
+	}
+
+	if c.Page < 1 || c.PageSize < 1 || c.Offset < 0 {
+		return c, errors.New("invalid cursor")
+	}
+
+	return c, nil
+}
+
+// isPaginated reports whether api is a list API that accepts page and pagesize
+func isPaginated(api *csgo.Api) bool {
+	return api != nil && strings.HasPrefix(api.Name, "list") && cloudstack.HasParam(api, "page") && cloudstack.HasParam(api, "pagesize")
+}
+
+// listPage is a single page of a list API response
+type listPage struct {
+	count   int
+	itemKey string
+	items   []json.RawMessage
+}
+
+func parseListPage(raw json.RawMessage) (*listPage, error) {
+	body, err := cloudstack.UnwrapResponse(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(body, &fields); err != nil {
+		return nil, errors.Errorf("unmarshalling list response: %w", err)
+	}
+
+	page := &listPage{}
+
+	for key, value := range fields {
+		if key == "count" {
+			if err := json.Unmarshal(value, &page.count); err != nil {
+				return nil, errors.Errorf("unmarshalling count: %w", err)
+			}
+			continue
+		}
+
+		var items []json.RawMessage
+		if err := json.Unmarshal(value, &items); err == nil {
+			page.itemKey = key
+			page.items = items
+		}
+	}
+
+	return page, nil
+}
+
+// paginate calls a list API page by page until every item has been collected,
+// the item cap is reached or CloudStack runs out of pages. Items are
+// deduplicated by id, since listings can shift while they are being walked.
+// The result keeps CloudStack's response envelope, with a cursor added when
+// the listing was truncated.
+func (s *Server) paginate(ctx context.Context, apiName string, params map[string]string, opts callOptions) (json.RawMessage, error) {
+	logger := zerolog.Ctx(ctx)
+
+	maxItems := s.opts.MaxListItems
+	if maxItems <= 0 {
+		maxItems = defaultMaxListItems
+	}
+	if opts.maxItems > 0 && opts.maxItems < maxItems {
+		maxItems = opts.maxItems
+	}
+
+	cursor := pageCursor{Page: 1, PageSize: defaultPageSize}
+	if ps, err := strconv.Atoi(params["pagesize"]); err == nil && ps > 0 {
+		cursor.PageSize = ps
+	}
+	if opts.cursor != "" {
+		var err error
+		cursor, err = decodePageCursor(opts.cursor)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	seen := map[string]bool{}
+	items := []json.RawMessage{}
+	itemKey := ""
+	total := 0
+	var next *pageCursor
+
+	for {
+		pageParams := make(map[string]string, len(params)+2)
+		for k, v := range params {
+			pageParams[k] = v
+		}
+		pageParams["page"] = strconv.Itoa(cursor.Page)
+		pageParams["pagesize"] = strconv.Itoa(cursor.PageSize)
+
+		raw, err := s.call(ctx, apiName, pageParams)
+		if err != nil {
+			return nil, err
+		}
+
+		page, err := parseListPage(raw)
+		if err != nil {
+			return nil, errors.Errorf("parsing page %d of %s: %w", cursor.Page, apiName, err)
+		}
+
+		total = page.count
+		if page.itemKey != "" {
+			itemKey = page.itemKey
+		}
+
+		logger.Debug().Int("page", cursor.Page).Int("items", len(page.items)).Int("count", page.count).Msg("Fetched list page")
+
+		for i := cursor.Offset; i < len(page.items); i++ {
+			if len(items) >= maxItems {
+				next = &pageCursor{Page: cursor.Page, Offset: i, PageSize: cursor.PageSize}
+				break
+			}
+
+			key := itemIdentity(page.items[i])
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			items = append(items, page.items[i])
+		}
+
+		if next != nil || len(page.items) < cursor.PageSize || cursor.Page*cursor.PageSize >= total {
+			break
+		}
+
+		if len(items) >= maxItems {
+			next = &pageCursor{Page: cursor.Page + 1, PageSize: cursor.PageSize}
+			break
+		}
+
+		cursor = pageCursor{Page: cursor.Page + 1, PageSize: cursor.PageSize}
+	}
+
+	body := map[string]any{
+		"count": total,
+	}
+	if itemKey != "" {
+		body[itemKey] = items
+	}
+	if next != nil {
+		body["cursor"] = next.encode()
+	}
+
+	marsh, err := json.Marshal(map[string]any{
+		strings.ToLower(apiName) + "response": body,
+	})
+	if err != nil {
+		return nil, errors.Errorf("marshalling paginated response: %w", err)
+	}
+
+	return marsh, nil
+}
+
+// itemIdentity returns the id of a listed item, falling back to its full JSON
+func itemIdentity(item json.RawMessage) string {
+	var withID struct {
+		ID string `json:"id"`
+	}
+	if err := json.Unmarshal(item, &withID); err == nil && withID.ID != "" {
+		return withID.ID
+	}
+	return string(item)
+}
@@ -0,0 +1,121 @@
+package mcp_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/walteh/cloudstack-mcp/pkg/mcp"
+)
+
+func Test_Paginate(t *testing.T) {
+	// vm3 shifts from the first page onto the second while the listing is walked
+	vms := []string{"vm1", "vm2", "vm3", "vm3", "vm4", "vm5", "vm6"}
+
+	apis := `[{"name":"listVirtualMachines","params":[{"name":"page","type":"integer"},{"name":"pagesize","type":"integer"}]}]`
+
+	var mu sync.Mutex
+	pages := []string{}
+
+	newServer := func(t *testing.T, opts mcp.ServerOpts) *testServer {
+		return newTestServer(t, apis, func(w http.ResponseWriter, r *http.Request) {
+			q := r.URL.Query()
+			page, _ := strconv.Atoi(q.Get("page"))
+			size, _ := strconv.Atoi(q.Get("pagesize"))
+
+			mu.Lock()
+			pages = append(pages, q.Get("page")+"/"+q.Get("pagesize"))
+			mu.Unlock()
+
+			items := []string{}
+			for i := (page - 1) * size; i < min(page*size, len(vms)); i++ {
+				items = append(items, fmt.Sprintf(`{"id":%q}`, vms[i]))
+			}
+			fmt.Fprintf(w, `{"listvirtualmachinesresponse":{"count":%d,"virtualmachine":[%s]}}`, len(vms), strings.Join(items, ","))
+		}, opts)
+	}
+
+	type listing struct {
+		Count          int    `json:"count"`
+		Cursor         string `json:"cursor"`
+		VirtualMachine []struct {
+			ID string `json:"id"`
+		} `json:"virtualmachine"`
+	}
+
+	list := func(t *testing.T, s *testServer, args string) (listing, []string) {
+		mu.Lock()
+		pages = nil
+		mu.Unlock()
+
+		text, isError := s.callToolText("listVirtualMachines", args)
+		require.False(t, isError, text)
+
+		var l listing
+		require.NoError(t, json.Unmarshal([]byte(text), &l))
+
+		mu.Lock()
+		defer mu.Unlock()
+		return l, pages
+	}
+
+	ids := func(l listing) []string {
+		out := []string{}
+		for _, vm := range l.VirtualMachine {
+			out = append(out, vm.ID)
+		}
+		return out
+	}
+
+	t.Run("every page", func(t *testing.T) {
+		s := newServer(t, mcp.ServerOpts{})
+
+		l, fetched := list(t, s, `{"auto_paginate":true,"pagesize":3}`)
+		assert.Equal(t, []string{"1/3", "2/3", "3/3"}, fetched)
+		assert.Equal(t, []string{"vm1", "vm2", "vm3", "vm4", "vm5", "vm6"}, ids(l))
+		assert.Equal(t, 7, l.Count)
+		assert.Empty(t, l.Cursor)
+	})
+
+	t.Run("max items and cursor", func(t *testing.T) {
+		s := newServer(t, mcp.ServerOpts{})
+
+		l, fetched := list(t, s, `{"auto_paginate":true,"pagesize":3,"max_items":4}`)
+		assert.Equal(t, []string{"1/3", "2/3"}, fetched)
+		assert.Equal(t, []string{"vm1", "vm2", "vm3", "vm4"}, ids(l))
+		require.NotEmpty(t, l.Cursor)
+
+		// the cursor continues in the middle of the second page
+		l, fetched = list(t, s, fmt.Sprintf(`{"cursor":%q,"max_items":4}`, l.Cursor))
+		assert.Equal(t, []string{"2/3", "3/3"}, fetched)
+		assert.Equal(t, []string{"vm5", "vm6"}, ids(l))
+		assert.Empty(t, l.Cursor)
+	})
+
+	t.Run("cap at a page boundary", func(t *testing.T) {
+		s := newServer(t, mcp.ServerOpts{MaxListItems: 3})
+
+		l, fetched := list(t, s, `{"auto_paginate":true,"pagesize":3}`)
+		assert.Equal(t, []string{"1/3"}, fetched)
+		assert.Equal(t, []string{"vm1", "vm2", "vm3"}, ids(l))
+		require.NotEmpty(t, l.Cursor)
+
+		l, fetched = list(t, s, fmt.Sprintf(`{"cursor":%q}`, l.Cursor))
+		assert.Equal(t, []string{"2/3"}, fetched)
+		assert.Equal(t, []string{"vm3", "vm4", "vm5"}, ids(l))
+	})
+
+	t.Run("invalid cursor", func(t *testing.T) {
+		s := newServer(t, mcp.ServerOpts{})
+
+		text, isError := s.callToolText("listVirtualMachines", `{"cursor":"not a cursor"}`)
+		assert.True(t, isError)
+		assert.Contains(t, text, "cursor")
+	})
+}
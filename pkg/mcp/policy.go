@@ -0,0 +1,141 @@
+package mcp
+
+import (
+	"os"
+	"path"
+	"strings"
+	"unicode"
+
+	csgo "github.com/apache/cloudstack-go/v2/cloudstack"
+	errors "gitlab.com/tozd/go/errors"
+	"gopkg.in/yaml.v3"
+)
+
+// Policy decides which CloudStack APIs are registered as tools. An API is
+// allowed when it matches Include (or Include is empty) and does not match Exclude.
+type Policy struct {
+	Include PolicyRules `yaml:"include" json:"include"`
+	Exclude PolicyRules `yaml:"exclude" json:"exclude"`
+}
+
+// PolicyRules match an API when any of the names, verbs or categories match,
+// and, if Async is set, the API's isasync flag equals it
+type PolicyRules struct {
+	// Names are globs matched against the API name, e.g. "list*" or "*VirtualMachine*"
+	Names []string `yaml:"names" json:"names"`
+	// Verbs are matched against the leading verb of the API name, e.g. "list" or "delete"
+	Verbs []string `yaml:"verbs" json:"verbs"`
+	// Categories are globs matched against the API name without its verb, e.g. "VirtualMachine*"
+	Categories []string `yaml:"categories" json:"categories"`
+	// Async matches on the isasync flag of the API
+	Async *bool `yaml:"async" json:"async"`
+}
+
+// readVerbs are the API verbs that never change anything in CloudStack
+var readVerbs = []string{"list", "get", "query", "search", "find"}
+
+// builtinPolicies can be named instead of a policy file
+var builtinPolicies = map[string]func() *Policy{
+	"full": func() *Policy { return &Policy{} },
+	"readonly": func() *Policy {
+		return &Policy{Include: PolicyRules{Verbs: append([]string{}, readVerbs...)}}
+	},
+}
+
+// LoadPolicy reads a YAML or JSON policy file, or returns the builtin policy
+// with that name ("full" or "readonly")
+func LoadPolicy(nameOrPath string) (*Policy, error) {
+	if builtin, ok := builtinPolicies[nameOrPath]; ok {
+		return builtin(), nil
+	}
+
+	data, err := os.ReadFile(nameOrPath)
+	if err != nil {
+		return nil, errors.Errorf("reading policy file: %w", err)
+	}
+
+	var p Policy
+	// JSON is valid YAML, so one decoder handles both
+	if err := yaml.Unmarshal(data, &p); err != nil {
+		return nil, errors.Errorf("parsing policy file %s: %w", nameOrPath, err)
+	}
+
+	if err := p.Validate(); err != nil {
+		return nil, errors.Errorf("validating policy file %s: %w", nameOrPath, err)
+	}
+
+	return &p, nil
+}
+
+// Validate checks that every glob in the policy is well formed
+func (p *Policy) Validate() error {
+	for _, rules := range []PolicyRules{p.Include, p.Exclude} {
+		for _, glob := range append(append([]string{}, rules.Names...), rules.Categories...) {
+			if _, err := path.Match(strings.ToLower(glob), ""); err != nil {
+				return errors.Errorf("invalid glob %q: %w", glob, err)
+			}
+		}
+	}
+	return nil
+}
+
+// Allows reports whether api should be exposed as a tool. A nil policy allows everything.
+func (p *Policy) Allows(api *csgo.Api) bool {
+	if p == nil {
+		return true
+	}
+
+	if !p.Include.empty() && !p.Include.matches(api) {
+		return false
+	}
+
+	return p.Exclude.empty() || !p.Exclude.matches(api)
+}
+
+func (r PolicyRules) empty() bool {
+	return len(r.Names) == 0 && len(r.Verbs) == 0 && len(r.Categories) == 0 && r.Async == nil
+}
+
+func (r PolicyRules) matches(api *csgo.Api) bool {
+	if r.Async != nil && *r.Async != api.Isasync {
+		return false
+	}
+
+	if len(r.Names) == 0 && len(r.Verbs) == 0 && len(r.Categories) == 0 {
+		return true
+	}
+
+	verb := apiVerb(api.Name)
+	for _, v := range r.Verbs {
+		if strings.EqualFold(v, verb) {
+			return true
+		}
+	}
+
+	return globsMatch(r.Names, api.Name) || globsMatch(r.Categories, apiCategory(api.Name))
+}
+
+func globsMatch(globs []string, name string) bool {
+	name = strings.ToLower(name)
+	for _, glob := range globs {
+		if ok, _ := path.Match(strings.ToLower(glob), name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// apiVerb returns the leading lower case verb of an API name, e.g. "list" for listVirtualMachines
+func apiVerb(name string) string {
+	for i, r := range name {
+		if unicode.IsUpper(r) {
+			return name[:i]
+		}
+	}
+	return name
+}
+
+// apiCategory returns the API name without its verb, e.g. "VirtualMachines" for listVirtualMachines
+func apiCategory(name string) string {
+	return strings.TrimPrefix(name, apiVerb(name))
+}
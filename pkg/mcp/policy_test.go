@@ -0,0 +1,94 @@
+package mcp_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	csgo "github.com/apache/cloudstack-go/v2/cloudstack"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/walteh/cloudstack-mcp/pkg/mcp"
+)
+
+func Test_Policy_Allows(t *testing.T) {
+	yes := true
+
+	tests := []struct {
+		name   string
+		policy *mcp.Policy
+		api    *csgo.Api
+		want   bool
+	}{
+		{
+			name:   "nil policy allows everything",
+			policy: nil,
+			api:    &csgo.Api{Name: "deleteDomain"},
+			want:   true,
+		},
+		{
+			name:   "include verb",
+			policy: &mcp.Policy{Include: mcp.PolicyRules{Verbs: []string{"list"}}},
+			api:    &csgo.Api{Name: "listVirtualMachines"},
+			want:   true,
+		},
+		{
+			name:   "include verb rejects other verbs",
+			policy: &mcp.Policy{Include: mcp.PolicyRules{Verbs: []string{"list"}}},
+			api:    &csgo.Api{Name: "destroyVirtualMachine"},
+			want:   false,
+		},
+		{
+			name:   "exclude name glob",
+			policy: &mcp.Policy{Exclude: mcp.PolicyRules{Names: []string{"*SystemVm*"}}},
+			api:    &csgo.Api{Name: "destroySystemVm"},
+			want:   false,
+		},
+		{
+			name:   "exclude async",
+			policy: &mcp.Policy{Exclude: mcp.PolicyRules{Async: &yes}},
+			api:    &csgo.Api{Name: "deployVirtualMachine", Isasync: true},
+			want:   false,
+		},
+		{
+			name:   "include category",
+			policy: &mcp.Policy{Include: mcp.PolicyRules{Categories: []string{"virtualmachine*"}}},
+			api:    &csgo.Api{Name: "startVirtualMachine"},
+			want:   true,
+		},
+		{
+			name: "exclude wins over include",
+			policy: &mcp.Policy{
+				Include: mcp.PolicyRules{Categories: []string{"Domain*"}},
+				Exclude: mcp.PolicyRules{Verbs: []string{"delete"}},
+			},
+			api:  &csgo.Api{Name: "deleteDomain"},
+			want: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, tt.policy.Allows(tt.api))
+		})
+	}
+}
+
+func Test_LoadPolicy(t *testing.T) {
+	readonly, err := mcp.LoadPolicy("readonly")
+	require.NoError(t, err)
+	assert.True(t, readonly.Allows(&csgo.Api{Name: "listZones"}))
+	assert.False(t, readonly.Allows(&csgo.Api{Name: "deleteZone"}))
+
+	file := filepath.Join(t.TempDir(), "policy.yaml")
+	require.NoError(t, os.WriteFile(file, []byte("exclude:\n  verbs: [delete, destroy]\n  names: [\"*Domain\"]\n"), 0o600))
+
+	p, err := mcp.LoadPolicy(file)
+	require.NoError(t, err)
+	assert.True(t, p.Allows(&csgo.Api{Name: "listZones"}))
+	assert.False(t, p.Allows(&csgo.Api{Name: "destroyRouter"}))
+	assert.False(t, p.Allows(&csgo.Api{Name: "updateDomain"}))
+
+	require.NoError(t, os.WriteFile(file, []byte(`{"include": {"names": ["["]}}`), 0o600))
+	_, err = mcp.LoadPolicy(file)
+	require.Error(t, err)
+}
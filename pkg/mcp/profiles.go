@@ -0,0 +1,126 @@
+package mcp
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/invopop/jsonschema"
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/walteh/cloudstack-mcp/pkg/cloudstack"
+	errors "gitlab.com/tozd/go/errors"
+)
+
+const listProfilesToolName = "cs_list_profiles"
+
+// profileKey is the context key of the profile a call is made with
+type profileKey struct{}
+
+// withProfile returns ctx for calls made with the named profile. An empty
+// name keeps the server's own endpoint and credentials.
+func (s *Server) withProfile(ctx context.Context, name string) (context.Context, error) {
+	if name == "" {
+		return ctx, nil
+	}
+
+	if s.opts.Profiles != nil {
+		if profile, ok := s.opts.Profiles.Get(name); ok {
+			return context.WithValue(ctx, profileKey{}, profile), nil
+		}
+	}
+
+	if s.opts.Profiles == nil {
+		return nil, errors.Errorf("unknown profile %q, no profiles are configured", name)
+	}
+	return nil, errors.Errorf("unknown profile %q, use one of %s", name, strings.Join(s.opts.Profiles.Names(), ", "))
+}
+
+// profileName returns the name of the profile calls made with ctx use, or "" for the server's own
+func profileName(ctx context.Context) string {
+	if profile, ok := ctx.Value(profileKey{}).(cloudstack.Profile); ok {
+		return profile.Name
+	}
+	return ""
+}
+
+// endpoint returns the API URL and credentials calls made with ctx use
+func (s *Server) endpoint(ctx context.Context) (string, cloudstack.Credentials) {
+	if profile, ok := ctx.Value(profileKey{}).(cloudstack.Profile); ok {
+		return profile.APIURL, profile.Credentials
+	}
+	return s.apiURL, s.creds
+}
+
+// profileSchema is the schema of the profile argument, nil without profiles
+func profileSchema(profiles *cloudstack.Profiles) *jsonschema.Schema {
+	if profiles == nil {
+		return nil
+	}
+
+	enum := []any{}
+	for _, name := range profiles.Names() {
+		enum = append(enum, name)
+	}
+
+	return &jsonschema.Schema{
+		Type:        "string",
+		Enum:        enum,
+		Description: fmt.Sprintf("Profile of the CloudStack endpoint and account to call, see %s. Defaults to %s", listProfilesToolName, profiles.Default),
+	}
+}
+
+// profileToolOption adds the profile argument to a tool that is not generated from an API
+func (s *Server) profileToolOption() []mcp.ToolOption {
+	sch := profileSchema(s.opts.Profiles)
+	if sch == nil {
+		return nil
+	}
+
+	return []mcp.ToolOption{
+		mcp.WithString(argProfile, mcp.Enum(s.opts.Profiles.Names()...), mcp.Description(sch.Description)),
+	}
+}
+
+// requestProfile returns ctx for the profile named by the profile argument of req
+func (s *Server) requestProfile(ctx context.Context, req mcp.CallToolRequest) (context.Context, error) {
+	name, _ := req.GetArguments()[argProfile].(string)
+	return s.withProfile(ctx, name)
+}
+
+// registerProfileTools registers the tool listing the configured profiles
+func (s *Server) registerProfileTools() {
+	if s.opts.Profiles == nil {
+		return
+	}
+
+	tool := mcp.NewTool(listProfilesToolName,
+		mcp.WithDescription("List the CloudStack profiles, each an endpoint and account, that tools can be called with through their profile argument"),
+		mcp.WithTitleAnnotation("List CloudStack Profiles"),
+		mcp.WithReadOnlyHintAnnotation(true),
+		mcp.WithDestructiveHintAnnotation(false),
+		mcp.WithIdempotentHintAnnotation(true),
+		mcp.WithOpenWorldHintAnnotation(false),
+	)
+
+	s.mcpServer.AddTool(tool, func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		profiles := []map[string]any{}
+		for _, profile := range s.opts.Profiles.Profiles {
+			// only what identifies the account, never its secrets
+			entry := map[string]any{
+				"name":     profile.Name,
+				"url":      profile.APIURL,
+				"authmode": profile.Credentials.Mode,
+				"default":  profile.Name == s.opts.Profiles.Default,
+			}
+			if profile.Credentials.Mode == cloudstack.AuthModePassword {
+				entry["username"] = profile.Credentials.Username
+				if profile.Credentials.Domain != "" {
+					entry["domain"] = profile.Credentials.Domain
+				}
+			}
+			profiles = append(profiles, entry)
+		}
+
+		return jsonResult(map[string]any{"profiles": profiles})
+	})
+}
@@ -0,0 +1,70 @@
+package mcp_test
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/walteh/cloudstack-mcp/pkg/cloudstack"
+	"github.com/walteh/cloudstack-mcp/pkg/mcp"
+)
+
+func Test_Profiles(t *testing.T) {
+	apis := `[{"name":"listZones","params":[{"name":"name","type":"string"}]}]`
+
+	// each endpoint answers with its own zone, so the responses tell which one was called
+	zones := func(zone string) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprintf(w, `{"listzonesresponse":{"count":1,"zone":[{"name":%q,"apikey":%q}]}}`, zone, r.URL.Query().Get("apiKey"))
+		}
+	}
+
+	prod := newCloudStackStub(t, apis, zones("prod-zone"))
+	lab := newCloudStackStub(t, apis, zones("lab-zone"))
+
+	profiles := &cloudstack.Profiles{
+		Default: "prod",
+		Profiles: []cloudstack.Profile{
+			{Name: "prod", APIURL: prod.URL, Credentials: testCreds},
+			{Name: "lab", APIURL: lab.URL, Credentials: cloudstack.Credentials{Mode: cloudstack.AuthModeAPIKey, APIKey: "lab-key", SecretKey: "lab-secret"}},
+		},
+	}
+
+	s := newTestServer(t, apis, zones("own-zone"), mcp.ServerOpts{Profiles: profiles})
+
+	tests := []struct {
+		name      string
+		tool      string
+		args      string
+		wantError bool
+		wants     []string
+	}{
+		{name: "server's own endpoint", tool: "listZones", args: `{}`, wants: []string{`"name":"own-zone"`}},
+		{name: "named profile", tool: "listZones", args: `{"profile":"lab"}`, wants: []string{`"name":"lab-zone"`, `"apikey":"lab-key"`}},
+		{name: "default profile by name", tool: "listZones", args: `{"profile":"prod"}`, wants: []string{`"name":"prod-zone"`}},
+		{name: "unknown profile", tool: "listZones", args: `{"profile":"staging"}`, wantError: true, wants: []string{`unknown profile "staging", use one of prod, lab`}},
+		{name: "wait for job", tool: "cs_wait_for_job", args: `{"jobid":"job1","profile":"staging"}`, wantError: true, wants: []string{`unknown profile "staging"`}},
+		{
+			name:  "list profiles",
+			tool:  "cs_list_profiles",
+			args:  `{}`,
+			wants: []string{`{"authmode":"apikey","default":true,"name":"prod","url":"` + prod.URL + `"}`, `"name":"lab"`},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			text, isError := s.callToolText(tt.tool, tt.args)
+			assert.Equal(t, tt.wantError, isError, text)
+			for _, want := range tt.wants {
+				assert.Contains(t, text, want)
+			}
+			assert.NotContains(t, text, "secret")
+		})
+	}
+
+	// every tool offers the profiles
+	tools := s.request("tools/list", `{}`)
+	assert.Contains(t, tools, `"profile":{"description":"Profile of the CloudStack endpoint and account to call, see cs_list_profiles. Defaults to prod","enum":["prod","lab"],"type":"string"}`)
+}
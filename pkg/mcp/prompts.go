@@ -0,0 +1,211 @@
+package mcp
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/rs/zerolog"
+	errors "gitlab.com/tozd/go/errors"
+)
+
+// maxPromptSnapshotBytes caps the JSON of each listing a prompt includes
+const maxPromptSnapshotBytes = 20_000
+
+// cloudStackPrompt is a prompt for a common CloudStack operation. It includes
+// snapshots of the list APIs relevant to its arguments, followed by the
+// instructions for the task, so every run starts from the same context.
+type cloudStackPrompt struct {
+	name        string
+	description string
+	arguments   []promptArgument
+	// gather resolves the arguments and returns the listings to include
+	gather func(ctx context.Context, s *Server, args map[string]string) ([]promptSnapshot, error)
+	// instructions returns the task for the model, given the resolved arguments
+	instructions func(args map[string]string) string
+}
+
+type promptArgument struct {
+	name        string
+	description string
+	required    bool
+}
+
+// promptSnapshot is a list API call whose result is included in a prompt
+type promptSnapshot struct {
+	title   string
+	command string
+	params  map[string]string
+}
+
+var cloudStackPrompts = []cloudStackPrompt{
+	{
+		name:        "diagnose_vm_start_failure",
+		description: "Diagnose why a virtual machine does not start",
+		arguments: []promptArgument{
+			{name: "vm", description: "name or id of the VM", required: true},
+		},
+		gather: func(ctx context.Context, s *Server, args map[string]string) ([]promptSnapshot, error) {
+			vm, err := s.resolveItem(ctx, "vm", "listVirtualMachines", args["vm"], nil)
+			if err != nil {
+				return nil, err
+			}
+			args["vmid"] = str(vm["id"])
+			args["vm"] = str(vm["name"])
+
+			snapshots := []promptSnapshot{
+				{title: "The VM", command: "listVirtualMachines", params: map[string]string{"id": args["vmid"]}},
+				{title: "Its volumes", command: "listVolumes", params: map[string]string{"virtualmachineid": args["vmid"]}},
+				{title: "Recent events of the VM", command: "listEvents", params: map[string]string{"keyword": args["vm"], "pagesize": "50", "page": "1"}},
+				{title: "Capacity of its zone", command: "listCapacity", params: map[string]string{"zoneid": str(vm["zoneid"])}},
+			}
+			if hostID := str(vm["hostid"]); hostID != "" {
+				snapshots = append(snapshots, promptSnapshot{title: "Its last host", command: "listHosts", params: map[string]string{"id": hostID}})
+			}
+			return snapshots, nil
+		},
+		instructions: func(args map[string]string) string {
+			return fmt.Sprintf(`The virtual machine %s (id %s) does not start. Using the listings above, find out why:
+
+1. Check the VM state and whether it is stuck in Starting, Stopping or Error.
+2. Look for failed events of the VM and quote their error text.
+3. Check that its volumes are Ready and on available storage.
+4. Check that its zone has enough CPU, memory and storage capacity left, and that its last host is Up and enabled.
+
+Report the most likely cause first, with the evidence for it, then the steps to fix it. Do not change anything without asking first.`, args["vm"], args["vmid"])
+		},
+	},
+	{
+		name:        "zone_capacity_report",
+		description: "Report the capacity and utilisation of a zone",
+		arguments: []promptArgument{
+			{name: "zone", description: "name or id of the zone", required: true},
+		},
+		gather: func(ctx context.Context, s *Server, args map[string]string) ([]promptSnapshot, error) {
+			zone, err := s.resolveItem(ctx, "zone", "listZones", args["zone"], nil)
+			if err != nil {
+				return nil, err
+			}
+			args["zoneid"] = str(zone["id"])
+			args["zone"] = str(zone["name"])
+
+			return []promptSnapshot{
+				{title: "Capacity", command: "listCapacity", params: map[string]string{"zoneid": args["zoneid"]}},
+				{title: "Clusters", command: "listClusters", params: map[string]string{"zoneid": args["zoneid"]}},
+				{title: "Hypervisor hosts", command: "listHosts", params: map[string]string{"zoneid": args["zoneid"], "type": "Routing"}},
+				{title: "Primary storage pools", command: "listStoragePools", params: map[string]string{"zoneid": args["zoneid"]}},
+			}, nil
+		},
+		instructions: func(args map[string]string) string {
+			return fmt.Sprintf(`Write a capacity report for the zone %s (id %s) from the listings above:
+
+1. A table of each capacity type with its used and total amount and the percentage used.
+2. The clusters and hosts that are disabled, in maintenance or not Up.
+3. The primary storage pools ordered by how full they are.
+4. Anything above 80%% used, and how much headroom is left for new VMs.
+
+Keep the report short and put the problems first.`, args["zone"], args["zoneid"])
+		},
+	},
+	{
+		name:        "audit_security_groups",
+		description: "Audit security groups for overly open rules",
+		arguments: []promptArgument{
+			{name: "ports", description: "comma separated ports that must not be open to the internet, defaults to 22,3389,3306,5432"},
+		},
+		gather: func(ctx context.Context, s *Server, args map[string]string) ([]promptSnapshot, error) {
+			if args["ports"] == "" {
+				args["ports"] = "22,3389,3306,5432"
+			}
+
+			return []promptSnapshot{
+				{title: "Security groups", command: "listSecurityGroups", params: map[string]string{}},
+				{title: "Virtual machines", command: "listVirtualMachines", params: map[string]string{"details": "min,secgrp"}},
+			}, nil
+		},
+		instructions: func(args map[string]string) string {
+			return fmt.Sprintf(`Audit the security groups above:
+
+1. List every ingress rule open to 0.0.0.0/0 or ::/0, and mark those covering the ports %s.
+2. List rules that open all ports or all protocols.
+3. List security groups no VM uses.
+4. For each finding, name the group, its account and the VMs it applies to.
+
+Order the findings by risk and suggest the narrower rule to replace each one. Do not change anything without asking first.`, args["ports"])
+		},
+	},
+}
+
+// renderSnapshot returns the listing as prompt text, noting when it is unavailable
+// or had to be shortened
+func (s *Server) renderSnapshot(ctx context.Context, snap promptSnapshot) string {
+	logger := zerolog.Ctx(ctx)
+
+	query := url.Values{}
+	for k, v := range snap.params {
+		query.Set(k, v)
+	}
+	header := fmt.Sprintf("## %s (%s %s)\n", snap.title, snap.command, query.Encode())
+
+	items, err := s.listItems(ctx, snap.command, snap.params)
+	if err != nil {
+		logger.Debug().Err(err).Str("command", snap.command).Msg("Failed to gather prompt snapshot")
+		return header + "unavailable: " + err.Error()
+	}
+
+	omitted := 0
+	for {
+		marsh, err := json.Marshal(items)
+		if err != nil {
+			return header + "unavailable: " + err.Error()
+		}
+		if len(marsh) <= maxPromptSnapshotBytes || len(items) <= 1 {
+			text := header + "```json\n" + string(marsh) + "\n```"
+			if omitted > 0 {
+				text += fmt.Sprintf("\n%d more items omitted", omitted)
+			}
+			return text
+		}
+		omitted += len(items) / 2
+		items = items[:len(items)-len(items)/2]
+	}
+}
+
+func (s *Server) registerPrompts() {
+	for _, p := range cloudStackPrompts {
+		opts := []mcp.PromptOption{mcp.WithPromptDescription(p.description)}
+		for _, arg := range p.arguments {
+			argOpts := []mcp.ArgumentOption{mcp.ArgumentDescription(arg.description)}
+			if arg.required {
+				argOpts = append(argOpts, mcp.RequiredArgument())
+			}
+			opts = append(opts, mcp.WithArgument(arg.name, argOpts...))
+		}
+
+		s.mcpServer.AddPrompt(mcp.NewPrompt(p.name, opts...), func(ctx context.Context, req mcp.GetPromptRequest) (*mcp.GetPromptResult, error) {
+			args := map[string]string{}
+			for _, arg := range p.arguments {
+				args[arg.name] = strings.TrimSpace(req.Params.Arguments[arg.name])
+				if arg.required && args[arg.name] == "" {
+					return nil, errors.Errorf("argument %s is required", arg.name)
+				}
+			}
+
+			snapshots, err := p.gather(ctx, s, args)
+			if err != nil {
+				return nil, err
+			}
+
+			messages := []mcp.PromptMessage{}
+			for _, snap := range snapshots {
+				messages = append(messages, mcp.NewPromptMessage(mcp.RoleUser, mcp.NewTextContent(s.renderSnapshot(ctx, snap))))
+			}
+			messages = append(messages, mcp.NewPromptMessage(mcp.RoleUser, mcp.NewTextContent(p.instructions(args))))
+
+			return mcp.NewGetPromptResult(p.description, messages), nil
+		})
+	}
+}
@@ -0,0 +1,47 @@
+package mcp_test
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/walteh/cloudstack-mcp/pkg/mcp"
+)
+
+func Test_Prompts(t *testing.T) {
+	apis := `[
+		{"name":"listZones","params":[]},
+		{"name":"listCapacity","params":[]},
+		{"name":"listHosts","params":[]}
+	]`
+
+	s := newTestServer(t, apis, func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		switch q.Get("command") {
+		case "listZones":
+			fmt.Fprint(w, `{"listzonesresponse":{"count":1,"zone":[{"id":"z1","name":"zone1"}]}}`)
+		case "listCapacity":
+			assert.Equal(t, "z1", q.Get("zoneid"))
+			fmt.Fprint(w, `{"listcapacityresponse":{"count":1,"capacity":[{"type":0,"capacityused":90,"capacitytotal":100}]}}`)
+		case "listHosts":
+			fmt.Fprint(w, `{"listhostsresponse":{"count":1,"host":[{"id":"h1","name":"host1","state":"Up"}]}}`)
+		}
+	}, mcp.ServerOpts{})
+
+	prompts := s.request("prompts/list", `{}`)
+	for _, name := range []string{"diagnose_vm_start_failure", "zone_capacity_report", "audit_security_groups", "find_cloudstack_api"} {
+		assert.Contains(t, prompts, fmt.Sprintf(`"name":%q`, name))
+	}
+
+	report := s.request("prompts/get", `{"name":"zone_capacity_report","arguments":{"zone":"zone1"}}`)
+	assert.Contains(t, report, `## Capacity (listCapacity zoneid=z1)`)
+	assert.Contains(t, report, `\"capacityused\":90`)
+	assert.Contains(t, report, `\"name\":\"host1\"`)
+	// listClusters is not in the catalog, so its snapshot is skipped with the reason
+	assert.Contains(t, report, `## Clusters (listClusters zoneid=z1)\nunavailable: unknown API`)
+	assert.Contains(t, report, `capacity report for the zone zone1 (id z1)`)
+
+	missing := s.request("prompts/get", `{"name":"zone_capacity_report","arguments":{"zone":"nowhere"}}`)
+	assert.Contains(t, missing, `zone: nothing named \"nowhere\" found with listZones`)
+}
@@ -0,0 +1,104 @@
+package mcp
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"sort"
+	"time"
+
+	csgo "github.com/apache/cloudstack-go/v2/cloudstack"
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/rs/zerolog"
+	errors "gitlab.com/tozd/go/errors"
+)
+
+const refreshCatalogToolName = "cs_refresh_api_catalog"
+
+// catalogDiff lists the APIs a catalog refresh added, removed or changed
+type catalogDiff struct {
+	Added   []string `json:"added"`
+	Removed []string `json:"removed"`
+	Changed []string `json:"changed"`
+}
+
+func diffCatalogs(old, updated map[string]*csgo.Api) catalogDiff {
+	diff := catalogDiff{Added: []string{}, Removed: []string{}, Changed: []string{}}
+
+	for name, api := range updated {
+		prev, ok := old[name]
+		switch {
+		case !ok:
+			diff.Added = append(diff.Added, name)
+		case !reflect.DeepEqual(prev, api):
+			diff.Changed = append(diff.Changed, name)
+		}
+	}
+
+	for name := range old {
+		if _, ok := updated[name]; !ok {
+			diff.Removed = append(diff.Removed, name)
+		}
+	}
+
+	sort.Strings(diff.Added)
+	sort.Strings(diff.Removed)
+	sort.Strings(diff.Changed)
+
+	return diff
+}
+
+// refreshCatalog fetches the catalog from CloudStack, bypassing the cache, and
+// updates the registered tools to match it
+func (s *Server) refreshCatalog(ctx context.Context) (catalogDiff, error) {
+	apis, err := s.fetchCatalog(ctx, true)
+	if err != nil {
+		return catalogDiff{}, errors.Errorf("fetching API catalog: %w", err)
+	}
+
+	return s.applyCatalog(ctx, apis)
+}
+
+func (s *Server) refreshCatalogPeriodically(ctx context.Context, interval time.Duration) {
+	logger := zerolog.Ctx(ctx)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if _, err := s.refreshCatalog(ctx); err != nil {
+			logger.Warn().Err(err).Msg("Periodic API catalog refresh failed")
+		}
+	}
+}
+
+func (s *Server) registerRefreshTool() {
+	tool := mcp.NewTool(refreshCatalogToolName,
+		mcp.WithDescription("Fetch the CloudStack API catalog again and update the available tools, e.g. after enabling a plugin or upgrading CloudStack. Returns the APIs that were added, removed or changed."),
+		mcp.WithTitleAnnotation("Refresh API Catalog"),
+		mcp.WithReadOnlyHintAnnotation(false),
+		mcp.WithDestructiveHintAnnotation(false),
+		mcp.WithIdempotentHintAnnotation(true),
+		mcp.WithOpenWorldHintAnnotation(false),
+	)
+
+	s.mcpServer.AddTool(tool, func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		diff, err := s.refreshCatalog(ctx)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+
+		marsh, err := json.Marshal(diff)
+		if err != nil {
+			return nil, errors.Errorf("marshalling catalog diff: %w", err)
+		}
+
+		return mcp.NewToolResultText(string(marsh)), nil
+	})
+}
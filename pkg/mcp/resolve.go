@@ -0,0 +1,167 @@
+package mcp
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"strings"
+
+	csgo "github.com/apache/cloudstack-go/v2/cloudstack"
+	"github.com/invopop/jsonschema"
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/rs/zerolog"
+	"github.com/walteh/cloudstack-mcp/pkg/cloudstack"
+	errors "gitlab.com/tozd/go/errors"
+)
+
+// resolveScopes are the parameters that narrow the lookup of the other names
+// of a call, e.g. a template is looked up in the zone given by zoneid. They are
+// resolved first so the others can be scoped by their ids.
+var resolveScopes = []string{"zoneid", "domainid", "projectid"}
+
+// resolveNames returns args with the names given for id parameters replaced by
+// the ids they refer to. A name that does not refer to exactly one resource
+// is returned as a tool error listing the candidates.
+func (s *Server) resolveNames(ctx context.Context, api *csgo.Api, args map[string]any) (map[string]any, *mcp.CallToolResult, error) {
+	logger := zerolog.Ctx(ctx)
+
+	resolved := make(map[string]any, len(args))
+	for k, v := range args {
+		resolved[k] = v
+	}
+
+	resolver, err := s.resolver(ctx)
+	if err != nil {
+		return nil, mcp.NewToolResultError(err.Error()), nil
+	}
+
+	for _, param := range resolveOrder(api) {
+		list := s.listApiForParam(api.Name, param)
+		if list == nil {
+			continue
+		}
+
+		scope := map[string]string{}
+		for _, k := range resolveScopes {
+			if v, ok := resolved[k].(string); ok && k != param && cloudstack.IsUUID(v) {
+				scope[k] = v
+			}
+		}
+
+		resolve := func(name string) (string, *mcp.CallToolResult, error) {
+			id, err := resolver.Resolve(ctx, list, param, name, scope)
+			if err != nil {
+				var rerr *cloudstack.ResolveError
+				if errors.As(err, &rerr) {
+					res, err := resolveErrorResult(api.Name, rerr)
+					return "", res, err
+				}
+				res, err := apiErrorResult(list, list.Name, err)
+				return "", res, err
+			}
+			logger.Debug().Str("param", param).Str("name", name).Str("id", id).Msg("Resolved name")
+			return id, nil, nil
+		}
+
+		switch v := resolved[param].(type) {
+		case string:
+			if v == "" || cloudstack.IsUUID(v) {
+				continue
+			}
+			id, res, err := resolve(v)
+			if res != nil || err != nil {
+				return nil, res, err
+			}
+			resolved[param] = id
+		case []any:
+			ids := make([]any, len(v))
+			for i, item := range v {
+				name, ok := item.(string)
+				if !ok || name == "" || cloudstack.IsUUID(name) {
+					ids[i] = item
+					continue
+				}
+				id, res, err := resolve(name)
+				if res != nil || err != nil {
+					return nil, res, err
+				}
+				ids[i] = id
+			}
+			resolved[param] = ids
+		}
+	}
+
+	return resolved, nil, nil
+}
+
+// resolveOrder returns the uuid and uuid list parameters of api, scopes first
+func resolveOrder(api *csgo.Api) []string {
+	params := []string{}
+	for _, param := range api.Params {
+		if isIdParam(param) {
+			params = append(params, param.Name)
+		}
+	}
+
+	rank := func(name string) int {
+		for i, scope := range resolveScopes {
+			if name == scope {
+				return i
+			}
+		}
+		return len(resolveScopes)
+	}
+	sort.SliceStable(params, func(i, j int) bool {
+		if rank(params[i]) != rank(params[j]) {
+			return rank(params[i]) < rank(params[j])
+		}
+		return params[i] < params[j]
+	})
+
+	return params
+}
+
+// isIdParam reports whether param takes the id of a resource, or a list of them
+func isIdParam(param csgo.ApiParams) bool {
+	switch param.Type {
+	case "uuid":
+		return strings.HasSuffix(param.Name, "id")
+	case "list", "array":
+		return strings.HasSuffix(param.Name, "ids")
+	}
+	return false
+}
+
+// resolvableSchema drops the uuid format of the id parameters whose names
+// resolveNames resolves, so clients do not reject a name before it is sent
+func (s *Server) resolvableSchema(sch *jsonschema.Schema, api *csgo.Api) {
+	for _, param := range resolveOrder(api) {
+		prop, ok := sch.Properties.Get(param)
+		if !ok || s.listApiForParam(api.Name, param) == nil {
+			continue
+		}
+		if prop.Items != nil {
+			prop.Items.Format = ""
+		} else {
+			prop.Format = ""
+		}
+	}
+}
+
+// resolveErrorResult returns a name that could not be resolved as a tool error
+func resolveErrorResult(apiName string, rerr *cloudstack.ResolveError) (*mcp.CallToolResult, error) {
+	marsh, err := json.Marshal(map[string]any{
+		"api":        apiName,
+		"error":      rerr.Error(),
+		"param":      rerr.Param,
+		"name":       rerr.Name,
+		"listapi":    rerr.ListApi,
+		"ambiguous":  rerr.Ambiguous,
+		"candidates": rerr.Candidates,
+	})
+	if err != nil {
+		return nil, errors.Errorf("marshalling resolve error: %w", err)
+	}
+
+	return mcp.NewToolResultError(string(marsh)), nil
+}
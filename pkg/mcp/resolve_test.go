@@ -0,0 +1,157 @@
+package mcp_test
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/walteh/cloudstack-mcp/pkg/mcp"
+)
+
+func Test_ResolveNames(t *testing.T) {
+	const (
+		zone1     = "11111111-1111-4111-8111-111111111111"
+		zone2     = "22222222-2222-4222-8222-222222222222"
+		template1 = "33333333-3333-4333-8333-333333333333"
+		template2 = "44444444-4444-4444-8444-444444444444"
+	)
+
+	apis := `[
+		{"name":"deployVirtualMachine","params":[{"name":"zoneid","type":"uuid","required":true},{"name":"templateid","type":"uuid","required":true},{"name":"name","type":"string"}]},
+		{"name":"deleteTemplate","params":[{"name":"id","type":"uuid","required":true}]},
+		{"name":"listZones","params":[{"name":"id","type":"uuid"},{"name":"name","type":"string"}]},
+		{"name":"listTemplates","params":[{"name":"templatefilter","type":"string","required":true},{"name":"name","type":"string"},{"name":"zoneid","type":"uuid"}]}
+	]`
+
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		switch q.Get("command") {
+		case "listZones":
+			fmt.Fprintf(w, `{"listzonesresponse":{"count":3,"zone":[{"id":%q,"name":"us-east-1"},{"id":%q,"name":"us-west-1"},{"id":"55555555-5555-4555-8555-555555555555","name":"eu-central-1"}]}}`, zone1, zone2)
+		case "listTemplates":
+			// the same template name exists in both zones
+			templates := map[string]string{zone1: template1, zone2: template2}
+			items := []string{}
+			for zone, id := range templates {
+				if q.Get("zoneid") == "" || q.Get("zoneid") == zone {
+					items = append(items, fmt.Sprintf(`{"id":%q,"name":"ubuntu-22"}`, id))
+				}
+			}
+			fmt.Fprintf(w, `{"listtemplatesresponse":{"count":%d,"template":[%s]}}`, len(items), strings.Join(items, ","))
+		case "deployVirtualMachine":
+			fmt.Fprintf(w, `{"deployvirtualmachineresponse":{"zoneid":%q,"templateid":%q}}`, q.Get("zoneid"), q.Get("templateid"))
+		}
+	}
+
+	tests := []struct {
+		name         string
+		tool         string
+		resolveNames bool
+		args         string
+		wantError    bool
+		wants        []string
+	}{
+		{
+			name:         "names scoped by zone",
+			resolveNames: true,
+			args:         `{"zoneid":"us-west-1","templateid":"ubuntu-22"}`,
+			wants:        []string{zone2, template2},
+		},
+		{
+			name:         "ids are kept",
+			resolveNames: true,
+			args:         fmt.Sprintf(`{"zoneid":%q,"templateid":%q}`, zone1, template1),
+			wants:        []string{zone1, template1},
+		},
+		{
+			name:         "ambiguous without a zone",
+			tool:         "deleteTemplate",
+			resolveNames: true,
+			args:         `{"id":"ubuntu-22"}`,
+			wantError:    true,
+			wants:        []string{`"param":"id"`, `"ambiguous":true`, template1, template2},
+		},
+		{
+			name:         "unknown name",
+			resolveNames: true,
+			args:         `{"zoneid":"ap-south-1","templateid":"ubuntu-22"}`,
+			wantError:    true,
+			wants:        []string{`"param":"zoneid"`, `"candidates":[]`},
+		},
+		{
+			name:         "similar names",
+			resolveNames: true,
+			args:         `{"zoneid":"us","templateid":"ubuntu-22"}`,
+			wantError:    true,
+			wants:        []string{`"candidates":[{"id":"` + zone1 + `","name":"us-east-1"},{"id":"` + zone2 + `","name":"us-west-1"}]`, `did you mean us-east-1, us-west-1?`},
+		},
+		{
+			name:      "names are not resolved by default",
+			args:      `{"zoneid":"us-west-1","templateid":"ubuntu-22"}`,
+			wantError: true,
+			wants:     []string{`is not a uuid`},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t, apis, handler, mcp.ServerOpts{ResolveNames: tt.resolveNames})
+
+			tool := tt.tool
+			if tool == "" {
+				tool = "deployVirtualMachine"
+			}
+
+			text, isError := s.callToolText(tool, tt.args)
+			assert.Equal(t, tt.wantError, isError, text)
+			for _, want := range tt.wants {
+				assert.Contains(t, text, want)
+			}
+		})
+	}
+}
+
+func Test_ResolveNames_IdLists(t *testing.T) {
+	const (
+		network1 = "66666666-6666-4666-8666-666666666666"
+		network2 = "77777777-7777-4777-8777-777777777777"
+		zone1    = "11111111-1111-4111-8111-111111111111"
+	)
+
+	apis := `[
+		{"name":"deployVirtualMachine","params":[{"name":"zoneid","type":"uuid"},{"name":"networkids","type":"list"},{"name":"hostid","type":"uuid"}]},
+		{"name":"listZones","params":[{"name":"name","type":"string"}]},
+		{"name":"listNetworks","params":[{"name":"keyword","type":"string"}]}
+	]`
+
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		switch q.Get("command") {
+		case "listZones":
+			fmt.Fprintf(w, `{"listzonesresponse":{"count":1,"zone":[{"id":%q,"name":"us-east-1"}]}}`, zone1)
+		case "listNetworks":
+			fmt.Fprintf(w, `{"listnetworksresponse":{"count":2,"network":[{"id":%q,"name":"frontend"},{"id":%q,"name":"backend"}]}}`, network1, network2)
+		case "deployVirtualMachine":
+			fmt.Fprintf(w, `{"deployvirtualmachineresponse":{"networkids":%q}}`, q.Get("networkids"))
+		}
+	}
+
+	t.Run("schema", func(t *testing.T) {
+		plain := newTestServer(t, apis, handler, mcp.ServerOpts{})
+		assert.Equal(t, 3, strings.Count(plain.request("tools/list", `{}`), `"format":"uuid"`))
+
+		// hostid has no list API to resolve it with, so it still takes an id only
+		resolving := newTestServer(t, apis, handler, mcp.ServerOpts{ResolveNames: true})
+		assert.Equal(t, 1, strings.Count(resolving.request("tools/list", `{}`), `"format":"uuid"`))
+	})
+
+	t.Run("names in a list", func(t *testing.T) {
+		s := newTestServer(t, apis, handler, mcp.ServerOpts{ResolveNames: true})
+
+		text, isError := s.callToolText("deployVirtualMachine", fmt.Sprintf(`{"zoneid":"us-east-1","networkids":["backend",%q]}`, network1))
+		assert.False(t, isError, text)
+		assert.Contains(t, text, network2+","+network1)
+	})
+}
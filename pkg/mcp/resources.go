@@ -0,0 +1,348 @@
+package mcp
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+	"github.com/rs/zerolog"
+	"github.com/walteh/cloudstack-mcp/pkg/cloudstack"
+	errors "gitlab.com/tozd/go/errors"
+)
+
+const (
+	resourceScheme   = "cloudstack"
+	resourceMIMEType = "application/json"
+
+	// defaultResourcePollInterval is used when ServerOpts.ResourcePollInterval is not set
+	defaultResourcePollInterval = time.Minute
+)
+
+// inventoryCollection is a kind of CloudStack resource exposed as MCP resources:
+// cloudstack://<name> lists them and cloudstack://<item>/{id} reads one
+type inventoryCollection struct {
+	name        string
+	item        string
+	command     string
+	description string
+	// params are always passed to the list API
+	params map[string]string
+	// filterParam is the list API parameter set by the filter query, if any
+	filterParam   string
+	defaultFilter string
+}
+
+var inventoryCollections = []inventoryCollection{
+	{name: "zones", item: "zone", command: "listZones", description: "Zones"},
+	{
+		name: "vms", item: "vm", command: "listVirtualMachines", description: "Virtual machines",
+		// everything but the usage stats, which change on every read
+		params: map[string]string{"listall": "true", "details": "group,nics,secgrp,tmpl,servoff,diskoff,iso,volume,affgrp"},
+	},
+	{name: "networks", item: "network", command: "listNetworks", description: "Guest networks", params: map[string]string{"listall": "true"}},
+	{name: "volumes", item: "volume", command: "listVolumes", description: "Volumes", params: map[string]string{"listall": "true"}},
+	{name: "hosts", item: "host", command: "listHosts", description: "Hosts"},
+	{name: "serviceofferings", item: "serviceoffering", command: "listServiceOfferings", description: "Compute service offerings"},
+	{
+		name: "templates", item: "template", command: "listTemplates", description: "Templates",
+		filterParam: "templatefilter", defaultFilter: "featured",
+	},
+}
+
+// inventoryRead is a parsed inventory resource URI
+type inventoryRead struct {
+	collection *inventoryCollection
+	params     map[string]string
+	// id is set when a single item is read
+	id string
+}
+
+func parseInventoryURI(uri string) (*inventoryRead, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return nil, errors.Errorf("parsing resource URI: %w", err)
+	}
+	if u.Scheme != resourceScheme {
+		return nil, errors.Errorf("unknown resource URI %q", uri)
+	}
+
+	read := &inventoryRead{}
+	for i := range inventoryCollections {
+		c := &inventoryCollections[i]
+		switch {
+		case u.Host == c.name && u.Path == "":
+			read.collection = c
+		case u.Host == c.item && strings.Count(u.Path, "/") == 1 && len(u.Path) > 1:
+			read.collection = c
+			read.id = strings.TrimPrefix(u.Path, "/")
+		}
+	}
+	if read.collection == nil {
+		return nil, errors.Errorf("unknown resource URI %q", uri)
+	}
+
+	read.params = map[string]string{}
+	for k, v := range read.collection.params {
+		read.params[k] = v
+	}
+	if read.collection.filterParam != "" {
+		read.params[read.collection.filterParam] = read.collection.defaultFilter
+	}
+
+	for key, values := range u.Query() {
+		if key != "filter" || read.collection.filterParam == "" {
+			return nil, errors.Errorf("unknown query parameter %q in resource URI %q", key, uri)
+		}
+		read.params[read.collection.filterParam] = values[0]
+	}
+
+	if read.id != "" {
+		read.params["id"] = read.id
+	}
+
+	return read, nil
+}
+
+// readInventory returns the JSON contents of an inventory resource: the whole
+// listing for a collection, or the single item for an id
+func (s *Server) readInventory(ctx context.Context, uri string) (json.RawMessage, error) {
+	read, err := parseInventoryURI(uri)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := s.exposedApi(read.collection.command); err != nil {
+		return nil, errors.Errorf("resource %q is not available: %w", uri, err)
+	}
+
+	if read.id == "" {
+		raw, err := s.paginate(ctx, read.collection.command, read.params, callOptions{})
+		if err != nil {
+			return nil, errors.Errorf("listing %s: %w", read.collection.name, err)
+		}
+		return cloudstack.UnwrapResponse(raw)
+	}
+
+	raw, err := s.call(ctx, read.collection.command, read.params)
+	if err != nil {
+		return nil, errors.Errorf("reading %s %s: %w", read.collection.item, read.id, err)
+	}
+
+	page, err := parseListPage(raw)
+	if err != nil {
+		return nil, errors.Errorf("parsing %s response: %w", read.collection.command, err)
+	}
+	if len(page.items) == 0 {
+		return nil, errors.Errorf("%s %s not found", read.collection.item, read.id)
+	}
+
+	return page.items[0], nil
+}
+
+func (s *Server) handleReadResource(ctx context.Context, req mcp.ReadResourceRequest) ([]mcp.ResourceContents, error) {
+	contents, err := s.readInventory(ctx, req.Params.URI)
+	if err != nil {
+		return nil, err
+	}
+
+	return []mcp.ResourceContents{
+		mcp.TextResourceContents{URI: req.Params.URI, MIMEType: resourceMIMEType, Text: string(contents)},
+	}, nil
+}
+
+// registerResources registers a resource listing each inventory collection
+// and a template reading its items by id
+func (s *Server) registerResources() {
+	for _, c := range inventoryCollections {
+		s.mcpServer.AddResource(mcp.NewResource(resourceScheme+"://"+c.name, c.name,
+			mcp.WithResourceDescription(fmt.Sprintf("%s in CloudStack, as returned by %s", c.description, c.command)),
+			mcp.WithMIMEType(resourceMIMEType),
+		), s.handleReadResource)
+
+		s.mcpServer.AddResourceTemplate(mcp.NewResourceTemplate(resourceScheme+"://"+c.item+"/{id}", c.item,
+			mcp.WithTemplateDescription(fmt.Sprintf("A single %s by id, as returned by %s", c.item, c.command)),
+			mcp.WithTemplateMIMEType(resourceMIMEType),
+		), s.handleReadResource)
+
+		if c.filterParam != "" {
+			s.mcpServer.AddResourceTemplate(mcp.NewResourceTemplate(resourceScheme+"://"+c.name+"{?filter}", c.name+" by filter",
+				mcp.WithTemplateDescription(fmt.Sprintf("%s in CloudStack with %s set to filter (default %s)", c.description, c.filterParam, c.defaultFilter)),
+				mcp.WithTemplateMIMEType(resourceMIMEType),
+			), s.handleReadResource)
+		}
+	}
+}
+
+// resourceSubscriptions tracks which sessions subscribed to which resources,
+// and the last seen contents of each subscribed resource
+type resourceSubscriptions struct {
+	mu       sync.Mutex
+	sessions map[string]map[string]bool
+	// creds are the session credentials subscribed resources are read with
+	creds  map[string]cloudstack.Credentials
+	hashes map[resourceReader][sha256.Size]byte
+}
+
+// resourceReader is a subscribed resource as read by one user, so sessions
+// with their own credentials are only told about changes they can see
+type resourceReader struct {
+	uri string
+	// creds are the session credentials the resource is read with, zero for the server's own
+	creds cloudstack.Credentials
+}
+
+func newResourceSubscriptions() *resourceSubscriptions {
+	return &resourceSubscriptions{
+		sessions: map[string]map[string]bool{},
+		creds:    map[string]cloudstack.Credentials{},
+		hashes:   map[resourceReader][sha256.Size]byte{},
+	}
+}
+
+func (r *resourceSubscriptions) subscribe(session, uri string, creds cloudstack.Credentials) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.sessions[session] == nil {
+		r.sessions[session] = map[string]bool{}
+	}
+	r.sessions[session][uri] = true
+	r.creds[session] = creds
+}
+
+func (r *resourceSubscriptions) unsubscribe(session, uri string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.sessions[session], uri)
+	if len(r.sessions[session]) == 0 {
+		delete(r.sessions, session)
+		delete(r.creds, session)
+	}
+}
+
+func (r *resourceSubscriptions) removeSession(session string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.sessions, session)
+	delete(r.creds, session)
+}
+
+// subscribers returns the sessions subscribed to each resource, forgetting the
+// contents of resources nobody is subscribed to anymore
+func (r *resourceSubscriptions) subscribers() map[resourceReader][]string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	subs := map[resourceReader][]string{}
+	for session, uris := range r.sessions {
+		for uri := range uris {
+			reader := resourceReader{uri: uri, creds: r.creds[session]}
+			subs[reader] = append(subs[reader], session)
+		}
+	}
+
+	for reader := range r.hashes {
+		if _, ok := subs[reader]; !ok {
+			delete(r.hashes, reader)
+		}
+	}
+
+	return subs
+}
+
+// update records the contents of a resource, reporting whether they changed
+// since they were last seen
+func (r *resourceSubscriptions) update(reader resourceReader, contents []byte) bool {
+	hash := sha256.Sum256(contents)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	prev, seen := r.hashes[reader]
+	r.hashes[reader] = hash
+	return seen && prev != hash
+}
+
+// addSubscriptionHooks tracks resources/subscribe and resources/unsubscribe
+func (s *Server) addSubscriptionHooks(hooks *server.Hooks) {
+	hooks.AddAfterSubscribe(func(ctx context.Context, id any, message *mcp.SubscribeRequest, result *mcp.EmptyResult) {
+		reader := resourceReader{uri: message.Params.URI}
+		if s.opts.SessionCredentials {
+			// kept for the poller, which has no request carrying them
+			reader.creds, _ = s.clientCredentials(ctx)
+		}
+		s.subscriptions.subscribe(sessionID(ctx), reader.uri, reader.creds)
+
+		s.startPolling.Do(func() {
+			interval := s.opts.ResourcePollInterval
+			if interval <= 0 {
+				interval = defaultResourcePollInterval
+			}
+			s.goBackground(func(ctx context.Context) {
+				s.pollSubscribedResources(ctx, interval)
+			})
+		})
+
+		// read the resource right away, so the first poll can already tell a change
+		if contents, err := s.readInventory(ctx, reader.uri); err == nil {
+			s.subscriptions.update(reader, contents)
+		}
+	})
+	hooks.AddAfterUnsubscribe(func(ctx context.Context, id any, message *mcp.UnsubscribeRequest, result *mcp.EmptyResult) {
+		s.subscriptions.unsubscribe(sessionID(ctx), message.Params.URI)
+	})
+	hooks.AddOnUnregisterSession(func(ctx context.Context, session server.ClientSession) {
+		s.subscriptions.removeSession(session.SessionID())
+	})
+}
+
+// pollSubscribedResources reads every subscribed resource each interval and
+// sends notifications/resources/updated to its subscribers when it changed
+func (s *Server) pollSubscribedResources(ctx context.Context, interval time.Duration) {
+	logger := zerolog.Ctx(ctx)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		for reader, sessions := range s.subscriptions.subscribers() {
+			uri := reader.uri
+
+			readCtx := ctx
+			if reader.creds != (cloudstack.Credentials{}) {
+				readCtx = cloudstack.WithCredentials(ctx, reader.creds)
+			}
+
+			contents, err := s.readInventory(readCtx, uri)
+			if err != nil {
+				logger.Debug().Err(err).Str("uri", uri).Msg("Failed to poll subscribed resource")
+				continue
+			}
+
+			if !s.subscriptions.update(reader, contents) {
+				continue
+			}
+
+			logger.Debug().Str("uri", uri).Int("subscribers", len(sessions)).Msg("Subscribed resource changed")
+			for _, session := range sessions {
+				if err := s.mcpServer.SendNotificationToSpecificClient(session, mcp.MethodNotificationResourceUpdated, map[string]any{"uri": uri}); err != nil {
+					logger.Debug().Err(err).Str("session", session).Msg("Failed to send resource update")
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package mcp_test
+
+import (
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	mcplib "github.com/mark3labs/mcp-go/mcp"
+	"github.com/stretchr/testify/assert"
+	"github.com/walteh/cloudstack-mcp/pkg/mcp"
+)
+
+type testSession struct {
+	notifications chan mcplib.JSONRPCNotification
+	// id defaults to test
+	id string
+}
+
+func (s *testSession) Initialize()       {}
+func (s *testSession) Initialized() bool { return true }
+func (s *testSession) SessionID() string {
+	if s.id == "" {
+		return "test"
+	}
+	return s.id
+}
+func (s *testSession) NotificationChannel() chan<- mcplib.JSONRPCNotification {
+	return s.notifications
+}
+
+func Test_Resources(t *testing.T) {
+	var vmState atomic.Value
+	vmState.Store("Running")
+
+	apis := `[
+		{"name":"listVirtualMachines","description":"Lists VMs","params":[{"name":"id","type":"uuid"},{"name":"page","type":"integer"},{"name":"pagesize","type":"integer"}]},
+		{"name":"listTemplates","description":"Lists templates","params":[{"name":"templatefilter","type":"string","required":true}]}
+	]`
+
+	s := newTestServer(t, apis, func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		switch q.Get("command") {
+		case "listVirtualMachines":
+			fmt.Fprintf(w, `{"listvirtualmachinesresponse":{"count":1,"virtualmachine":[{"id":"vm1","state":%q}]}}`, vmState.Load())
+		case "listTemplates":
+			fmt.Fprintf(w, `{"listtemplatesresponse":{"count":1,"template":[{"id":"t1","filter":%q}]}}`, q.Get("templatefilter"))
+		}
+	}, mcp.ServerOpts{ResourcePollInterval: 10 * time.Millisecond})
+
+	session := &testSession{notifications: make(chan mcplib.JSONRPCNotification, 10)}
+	s.withSession(session)
+
+	tests := []struct {
+		uri  string
+		want string
+	}{
+		{uri: "cloudstack://vms", want: `\"virtualmachine\":[{\"id\":\"vm1\"`},
+		{uri: "cloudstack://vm/vm1", want: `"text":"{\"id\":\"vm1\",\"state\":\"Running\"}"`},
+		{uri: "cloudstack://templates", want: `\"filter\":\"featured\"`},
+		{uri: "cloudstack://templates?filter=self", want: `\"filter\":\"self\"`},
+		{uri: "cloudstack://zones", want: `"error"`},
+		{uri: "cloudstack://vms?filter=self", want: `"error"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.uri, func(t *testing.T) {
+			assert.Contains(t, s.request("resources/read", fmt.Sprintf(`{"uri":%q}`, tt.uri)), tt.want)
+		})
+	}
+
+	assert.NotContains(t, s.request("resources/subscribe", `{"uri":"cloudstack://vm/vm1"}`), `"error"`)
+
+	vmState.Store("Stopped")
+
+	select {
+	case n := <-session.notifications:
+		assert.Equal(t, "notifications/resources/updated", n.Method)
+		assert.Equal(t, "cloudstack://vm/vm1", n.Params.AdditionalFields["uri"])
+	case <-time.After(5 * time.Second):
+		t.Fatal("no resources/updated notification")
+	}
+}
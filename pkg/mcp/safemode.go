@@ -0,0 +1,209 @@
+package mcp
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	csgo "github.com/apache/cloudstack-go/v2/cloudstack"
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/rs/zerolog"
+	"github.com/walteh/cloudstack-mcp/pkg/cloudstack"
+	errors "gitlab.com/tozd/go/errors"
+)
+
+// defaultPreviewTTL is how long a dry-run preview can be confirmed for when
+// ServerOpts.PreviewTTL is not set
+const defaultPreviewTTL = 10 * time.Minute
+
+// previews remembers the dry runs of mutating calls so that safe mode only
+// runs a call that was previewed with exactly the same arguments
+type previews struct {
+	ttl time.Duration
+
+	mu      sync.Mutex
+	entries map[string]time.Time
+}
+
+func newPreviews(ttl time.Duration) *previews {
+	if ttl <= 0 {
+		ttl = defaultPreviewTTL
+	}
+
+	return &previews{
+		ttl:     ttl,
+		entries: map[string]time.Time{},
+	}
+}
+
+func previewKey(ctx context.Context, apiName string, params map[string]string) string {
+	// json.Marshal sorts map keys, so equal arguments give equal keys
+	marsh, _ := json.Marshal(params)
+	return sessionID(ctx) + "\x00" + profileName(ctx) + "\x00" + apiName + "\x00" + string(marsh)
+}
+
+func (p *previews) record(key string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := time.Now()
+	for k, expires := range p.entries {
+		if now.After(expires) {
+			delete(p.entries, k)
+		}
+	}
+
+	p.entries[key] = now.Add(p.ttl)
+}
+
+// consume reports whether key was previewed and has not expired, forgetting it
+func (p *previews) consume(key string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	expires, ok := p.entries[key]
+	delete(p.entries, key)
+
+	return ok && time.Now().Before(expires)
+}
+
+// checkSafeMode enforces safe mode for a call. It returns a non-nil result when
+// the call must not go ahead: either the dry-run preview or a rejection.
+func (s *Server) checkSafeMode(ctx context.Context, apiName string, params map[string]string, opts callOptions) (*mcp.CallToolResult, error) {
+	class := classifyApi(apiName)
+
+	if !s.opts.SafeMode || class == classRead {
+		return nil, nil
+	}
+
+	key := previewKey(ctx, apiName, params)
+
+	if opts.dryRun {
+		preview, err := s.preview(ctx, apiName, class, params)
+		if err != nil {
+			return nil, err
+		}
+		s.previews.record(key)
+		return preview, nil
+	}
+
+	if !opts.confirm {
+		return mcp.NewToolResultError(fmt.Sprintf("safe mode: %s is a %s call. Call it with %s: true first to preview its effect, then again with the same arguments and %s: true", apiName, class, argDryRun, argConfirm)), nil
+	}
+
+	if !s.previews.consume(key) {
+		return mcp.NewToolResultError(fmt.Sprintf("safe mode: no preview found for this %s call. Call it with %s: true and the same arguments before confirming", apiName, argDryRun)), nil
+	}
+
+	return nil, nil
+}
+
+// previewTarget is a resource a mutating call refers to
+type previewTarget struct {
+	Param    string         `json:"param"`
+	ID       string         `json:"id"`
+	ListApi  string         `json:"listapi,omitempty"`
+	Resource map[string]any `json:"resource,omitempty"`
+	Error    string         `json:"error,omitempty"`
+}
+
+// preview describes what a call would do, resolving every id parameter, and
+// each id of a list parameter like virtualmachineids, to the resource it
+// refers to through the matching list API
+func (s *Server) preview(ctx context.Context, apiName string, class apiClass, params map[string]string) (*mcp.CallToolResult, error) {
+	targets := []previewTarget{}
+
+	for _, name := range sortedKeys(params) {
+		if params[name] == "" {
+			continue
+		}
+
+		switch {
+		case strings.HasSuffix(name, "ids"):
+			list := s.listApiForParam(apiName, strings.TrimSuffix(name, "s"))
+			for _, id := range strings.Split(params[name], ",") {
+				if id = strings.TrimSpace(id); id != "" {
+					targets = append(targets, s.describeTarget(ctx, list, name, id))
+				}
+			}
+		case strings.HasSuffix(name, "id"):
+			targets = append(targets, s.describeTarget(ctx, s.listApiForParam(apiName, name), name, params[name]))
+		}
+	}
+
+	marsh, err := json.Marshal(map[string]any{
+		"api":        apiName,
+		"class":      class,
+		"parameters": params,
+		"targets":    targets,
+		"message":    fmt.Sprintf("dry run only, nothing was changed. To run this call, repeat it with the same arguments and %s: true within %s", argConfirm, s.previews.ttl),
+	})
+	if err != nil {
+		return nil, errors.Errorf("marshalling preview: %w", err)
+	}
+
+	return mcp.NewToolResultText(string(marsh)), nil
+}
+
+// describeTarget looks up the resource id of param with list, if there is one
+func (s *Server) describeTarget(ctx context.Context, list *csgo.Api, param, id string) previewTarget {
+	logger := zerolog.Ctx(ctx)
+
+	target := previewTarget{Param: param, ID: id}
+
+	if list == nil {
+		return target
+	}
+	target.ListApi = list.Name
+
+	raw, err := s.call(ctx, list.Name, cloudstack.ListQuery(list, map[string]string{"id": id}))
+	if err != nil {
+		logger.Debug().Err(err).Str("api", list.Name).Msg("Failed to resolve preview target")
+		target.Error = err.Error()
+		return target
+	}
+
+	page, err := parseListPage(raw)
+	if err != nil || len(page.items) == 0 {
+		target.Error = "not found"
+		return target
+	}
+
+	var item map[string]any
+	if err := json.Unmarshal(page.items[0], &item); err != nil {
+		target.Error = err.Error()
+		return target
+	}
+
+	target.Resource = map[string]any{}
+	for _, k := range summaryColumns {
+		if v, ok := item[k]; ok {
+			target.Resource[k] = v
+		}
+	}
+
+	return target
+}
+
+// listApiForParam finds the list API for an id parameter: listZones for zoneid,
+// and for a bare id the list API of the called API's noun, e.g. listVirtualMachines
+// for destroyVirtualMachine
+func (s *Server) listApiForParam(apiName, param string) *csgo.Api {
+	noun := strings.TrimSuffix(strings.TrimSuffix(param, "ids"), "id")
+	if noun == "" {
+		noun = apiCategory(apiName)
+	}
+
+	return s.findListApi(noun)
+}
+
+// findListApi returns the list API for a resource noun, trying the plural forms CloudStack uses
+func (s *Server) findListApi(noun string) *csgo.Api {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return cloudstack.FindListApi(s.apis, noun)
+}
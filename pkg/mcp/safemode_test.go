@@ -0,0 +1,111 @@
+package mcp_test
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/walteh/cloudstack-mcp/pkg/mcp"
+)
+
+func Test_SafeMode(t *testing.T) {
+	apis := `[
+		{"name":"listVirtualMachines","params":[{"name":"id","type":"uuid"},{"name":"listall","type":"boolean"}]},
+		{"name":"removeFromLoadBalancerRule","isasync":false,"params":[{"name":"id","type":"uuid","required":true},{"name":"virtualmachineids","type":"list"}]}
+	]`
+
+	var mu sync.Mutex
+	removed := []string{}
+
+	newServer := func(t *testing.T, ttl time.Duration) *testServer {
+		return newTestServer(t, apis, func(w http.ResponseWriter, r *http.Request) {
+			q := r.URL.Query()
+			switch q.Get("command") {
+			case "listVirtualMachines":
+				fmt.Fprintf(w, `{"listvirtualmachinesresponse":{"count":1,"virtualmachine":[{"id":%q,"name":"name-%s","state":"Running","nic":[]}]}}`, q.Get("id"), q.Get("id"))
+			case "removeFromLoadBalancerRule":
+				mu.Lock()
+				removed = append(removed, q.Get("virtualmachineids"))
+				mu.Unlock()
+				fmt.Fprint(w, `{"removefromloadbalancerruleresponse":{"success":true}}`)
+			}
+		}, mcp.ServerOpts{SafeMode: true, PreviewTTL: ttl})
+	}
+
+	removals := func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		out := removed
+		removed = []string{}
+		return out
+	}
+
+	const (
+		lb  = `"id":"3d5f7bd6-4a1e-4c4f-9a2e-1b8e2c3d4f5a"`
+		vm1 = "11111111-1111-4111-8111-111111111111"
+		vm2 = "22222222-2222-4222-8222-222222222222"
+	)
+
+	t.Run("preview and confirm", func(t *testing.T) {
+		s := newServer(t, 0)
+
+		text, isError := s.callToolText("removeFromLoadBalancerRule", `{`+lb+`,"virtualmachineids":["`+vm1+`","`+vm2+`"]}`)
+		assert.True(t, isError)
+		assert.Contains(t, text, "dry_run: true first")
+
+		text, isError = s.callToolText("removeFromLoadBalancerRule", `{`+lb+`,"virtualmachineids":["`+vm1+`","`+vm2+`"],"dry_run":true}`)
+		assert.False(t, isError, text)
+		assert.Contains(t, text, `"class":"destroy"`)
+		assert.Contains(t, text, `{"param":"virtualmachineids","id":"`+vm1+`","listapi":"listVirtualMachines","resource":{"id":"`+vm1+`","name":"name-`+vm1+`","state":"Running"}}`)
+		assert.Contains(t, text, `{"param":"virtualmachineids","id":"`+vm2+`","listapi":"listVirtualMachines","resource":{"id":"`+vm2+`","name":"name-`+vm2+`","state":"Running"}}`)
+		assert.Contains(t, text, "within 10m0s")
+		assert.Empty(t, removals(), "dry run reached CloudStack")
+
+		text, isError = s.callToolText("removeFromLoadBalancerRule", `{`+lb+`,"virtualmachineids":["`+vm1+`","`+vm2+`"],"confirm":true}`)
+		assert.False(t, isError, text)
+		assert.Equal(t, []string{vm1 + "," + vm2}, removals())
+
+		// a preview confirms a single call
+		text, isError = s.callToolText("removeFromLoadBalancerRule", `{`+lb+`,"virtualmachineids":["`+vm1+`","`+vm2+`"],"confirm":true}`)
+		assert.True(t, isError)
+		assert.Contains(t, text, "no preview found")
+		assert.Empty(t, removals())
+	})
+
+	t.Run("different arguments", func(t *testing.T) {
+		s := newServer(t, 0)
+
+		_, isError := s.callToolText("removeFromLoadBalancerRule", `{`+lb+`,"virtualmachineids":["`+vm1+`"],"dry_run":true}`)
+		assert.False(t, isError)
+
+		text, isError := s.callToolText("removeFromLoadBalancerRule", `{`+lb+`,"virtualmachineids":["`+vm1+`","`+vm2+`"],"confirm":true}`)
+		assert.True(t, isError)
+		assert.Contains(t, text, "no preview found")
+		assert.Empty(t, removals())
+	})
+
+	t.Run("expired preview", func(t *testing.T) {
+		s := newServer(t, 10*time.Millisecond)
+
+		_, isError := s.callToolText("removeFromLoadBalancerRule", `{`+lb+`,"virtualmachineids":["`+vm1+`"],"dry_run":true}`)
+		assert.False(t, isError)
+
+		time.Sleep(50 * time.Millisecond)
+
+		text, isError := s.callToolText("removeFromLoadBalancerRule", `{`+lb+`,"virtualmachineids":["`+vm1+`"],"confirm":true}`)
+		assert.True(t, isError)
+		assert.Contains(t, text, "no preview found")
+		assert.Empty(t, removals())
+	})
+
+	t.Run("reads run directly", func(t *testing.T) {
+		s := newServer(t, 0)
+
+		text, isError := s.callToolText("listVirtualMachines", `{"id":"8c1e2f3a-5b6c-4d7e-8f9a-0b1c2d3e4f5a"}`)
+		assert.False(t, isError, text)
+		assert.Contains(t, text, "name-8c1e2f3a")
+	})
+}
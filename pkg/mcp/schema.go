@@ -0,0 +1,151 @@
+package mcp
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	csgo "github.com/apache/cloudstack-go/v2/cloudstack"
+	"github.com/invopop/jsonschema"
+	errors "gitlab.com/tozd/go/errors"
+)
+
+const (
+	// extraSince is the schema keyword holding the CloudStack version an API or parameter appeared in
+	extraSince = "x-cloudstack-since"
+	// extraRelated is the schema keyword holding the APIs related to a parameter
+	extraRelated = "x-cloudstack-related"
+	// extraValues is the schema keyword holding the values a parameter description
+	// lists. They are only a hint: descriptions are often incomplete or worded
+	// differently from what CloudStack accepts, so they are not an enum.
+	extraValues = "x-cloudstack-values"
+)
+
+// knownEnums are the only values some parameters accept; they become the enum
+// of the parameter's schema
+var knownEnums = map[string][]string{
+	"templatefilter": {"featured", "self", "selfexecutable", "sharedexecutable", "executable", "community", "all"},
+	"isofilter":      {"featured", "self", "selfexecutable", "sharedexecutable", "executable", "community", "all"},
+}
+
+var (
+	// enumPattern finds value lists in parameter descriptions, e.g.
+	// "Possible values are Running, Stopped" or "valid options are: tcp, udp"
+	enumPattern = regexp.MustCompile(`(?i)(?:possible|valid|supported|allowed) (?:values|options)(?: are| is)?:?\s*([^.;()]+)`)
+	enumValue   = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_-]*$`)
+	enumSplit   = regexp.MustCompile(`\s*(?:,|\bor\b|\band\b)\s*`)
+)
+
+// paramSchema converts one listApis parameter into its JSON schema
+func paramSchema(param csgo.ApiParams) (*jsonschema.Schema, error) {
+	prop := &jsonschema.Schema{
+		Description: param.Description,
+	}
+
+	switch param.Type {
+	case "string":
+		prop.Type = "string"
+		prop.Enum = paramEnum(param)
+	case "integer", "long", "short":
+		prop.Type = "integer"
+	case "number", "float", "double":
+		prop.Type = "number"
+	case "boolean":
+		prop.Type = "boolean"
+	case "uuid":
+		prop.Type = "string"
+		prop.Format = "uuid"
+	case "date":
+		// CloudStack accepts both yyyy-MM-dd and yyyy-MM-dd HH:mm:ss for date parameters
+		prop.Type = "string"
+		prop.Pattern = `^\d{4}-\d{2}-\d{2}( \d{2}:\d{2}:\d{2})?$`
+	case "datetime":
+		prop.Type = "string"
+		prop.Format = "date-time"
+	case "list", "array":
+		// lists are sent comma separated, and the ids of lists are uuids
+		item := &jsonschema.Schema{Type: "string"}
+		if strings.HasSuffix(param.Name, "ids") {
+			item.Format = "uuid"
+		}
+		prop.Type = "array"
+		prop.Items = item
+	case "map", "object":
+		prop.Type = "array"
+		prop.Items = &jsonschema.Schema{
+			Type:                 "object",
+			AdditionalProperties: &jsonschema.Schema{Type: "string"},
+		}
+		encoding := fmt.Sprintf("A list of objects, each sent as %[1]s[i].<key>=<value>, e.g. [{\"key\": \"a\", \"value\": \"b\"}] is sent as %[1]s[0].key=a&%[1]s[0].value=b", param.Name)
+		if param.Description != "" {
+			encoding = strings.TrimSuffix(param.Description, ".") + ". " + encoding
+		}
+		prop.Description = encoding
+	default:
+		return nil, errors.Errorf("unknown type: %s", param.Type)
+	}
+
+	if param.Length > 0 && prop.Type == "string" {
+		maxLength := uint64(param.Length)
+		prop.MaxLength = &maxLength
+	}
+
+	extras := map[string]any{}
+	if param.Since != "" {
+		extras[extraSince] = param.Since
+	}
+	if param.Related != "" {
+		extras[extraRelated] = strings.Split(param.Related, ",")
+	}
+	if param.Type == "string" && prop.Enum == nil {
+		if values := describedValues(param.Description); values != nil {
+			extras[extraValues] = values
+		}
+	}
+	if len(extras) > 0 {
+		prop.Extras = extras
+	}
+
+	return prop, nil
+}
+
+// paramEnum returns the known values a string parameter is limited to
+func paramEnum(param csgo.ApiParams) []any {
+	values, ok := knownEnums[param.Name]
+	if !ok {
+		return nil
+	}
+
+	enum := make([]any, len(values))
+	for i, v := range values {
+		enum[i] = v
+	}
+	return enum
+}
+
+// describedValues returns the values a parameter description lists. It
+// returns nil unless every value found is a plain identifier, so that loosely
+// worded prose is not mistaken for a list.
+func describedValues(description string) []string {
+	match := enumPattern.FindStringSubmatch(description)
+	if match == nil {
+		return nil
+	}
+
+	values := []string{}
+	for _, v := range enumSplit.Split(strings.TrimSpace(match[1]), -1) {
+		v = strings.Trim(v, `"'`)
+		if v == "" {
+			continue
+		}
+		if !enumValue.MatchString(v) {
+			return nil
+		}
+		values = append(values, v)
+	}
+
+	if len(values) < 2 {
+		return nil
+	}
+	return values
+}
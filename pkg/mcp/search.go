@@ -0,0 +1,296 @@
+package mcp
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"unicode"
+
+	csgo "github.com/apache/cloudstack-go/v2/cloudstack"
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+const (
+	searchApisToolName   = "cs_search_apis"
+	findApiPromptName    = "find_cloudstack_api"
+	defaultSearchResults = 10
+
+	// BM25 parameters, at their usual values
+	bm25K1 = 1.2
+	bm25B  = 0.75
+)
+
+// searchFields are the parts of an API that are indexed, and how much a match
+// in each counts. A match in the name outranks one among the parameters.
+var searchFields = []struct {
+	weight float64
+	text   func(api *csgo.Api) []string
+}{
+	{weight: 3, text: func(api *csgo.Api) []string { return []string{api.Name} }},
+	{weight: 1, text: func(api *csgo.Api) []string { return []string{api.Description} }},
+	{weight: 0.3, text: func(api *csgo.Api) []string {
+		names := []string{}
+		for _, param := range api.Params {
+			names = append(names, param.Name)
+		}
+		return names
+	}},
+	{weight: 0.5, text: func(api *csgo.Api) []string { return strings.Split(api.Related, ",") }},
+}
+
+// searchStopwords are too common in API descriptions to tell APIs apart
+var searchStopwords = map[string]bool{
+	"a": true, "an": true, "and": true, "are": true, "by": true, "for": true, "from": true,
+	"in": true, "is": true, "it": true, "of": true, "on": true, "or": true, "the": true,
+	"to": true, "with": true, "which": true, "how": true, "do": true, "i": true, "me": true,
+	"my": true, "all": true, "api": true,
+}
+
+// searchSynonyms expands query terms that CloudStack spells differently
+var searchSynonyms = map[string][]string{
+	"vm":       {"virtual", "machine"},
+	"instance": {"virtual", "machine"},
+	"server":   {"virtual", "machine"},
+	"disk":     {"volume"},
+	"image":    {"template"},
+}
+
+// searchIndex is a BM25F index over the API catalog. Each API is one document
+// whose fields are its name, description, parameter names and related APIs.
+type searchIndex struct {
+	docs       []searchDoc
+	docFreq    map[string]int
+	avgLengths []float64
+}
+
+type searchDoc struct {
+	api     *csgo.Api
+	terms   []map[string]int
+	lengths []int
+}
+
+// apiMatch is a search result of cs_search_apis
+type apiMatch struct {
+	Name           string   `json:"name"`
+	Description    string   `json:"description"`
+	Async          bool     `json:"isasync,omitempty"`
+	Class          apiClass `json:"class"`
+	RequiredParams []string `json:"requiredparams"`
+	Score          float64  `json:"score"`
+}
+
+func newSearchIndex(apis map[string]*csgo.Api) *searchIndex {
+	idx := &searchIndex{docFreq: map[string]int{}, avgLengths: make([]float64, len(searchFields))}
+
+	for _, name := range sortedKeys(apis) {
+		api := apis[name]
+
+		doc := searchDoc{api: api}
+		seen := map[string]bool{}
+		for f, field := range searchFields {
+			terms := map[string]int{}
+			length := 0
+			for _, text := range field.text(api) {
+				for _, token := range searchTokens(text) {
+					terms[token]++
+					length++
+					if !seen[token] {
+						seen[token] = true
+						idx.docFreq[token]++
+					}
+				}
+			}
+			doc.terms = append(doc.terms, terms)
+			doc.lengths = append(doc.lengths, length)
+			idx.avgLengths[f] += float64(length)
+		}
+
+		idx.docs = append(idx.docs, doc)
+	}
+
+	for f := range idx.avgLengths {
+		if len(idx.docs) > 0 {
+			idx.avgLengths[f] /= float64(len(idx.docs))
+		}
+	}
+
+	return idx
+}
+
+// search returns up to limit APIs matching query, best first, skipping those
+// allow rejects
+func (idx *searchIndex) search(query string, limit int, allow func(*csgo.Api) bool) []apiMatch {
+	terms := []string{}
+	for _, term := range searchTokens(query) {
+		terms = append(terms, term)
+		terms = append(terms, searchSynonyms[term]...)
+	}
+	n := float64(len(idx.docs))
+
+	type scored struct {
+		doc   *searchDoc
+		score float64
+	}
+
+	matches := []scored{}
+	for i := range idx.docs {
+		doc := &idx.docs[i]
+		if !allow(doc.api) {
+			continue
+		}
+
+		score := 0.0
+		for _, term := range terms {
+			// BM25F: length normalise the term frequency of each field, weight and sum them
+			tf := 0.0
+			for f, field := range searchFields {
+				if doc.terms[f][term] == 0 || idx.avgLengths[f] == 0 {
+					continue
+				}
+				norm := 1 - bm25B + bm25B*float64(doc.lengths[f])/idx.avgLengths[f]
+				tf += field.weight * float64(doc.terms[f][term]) / norm
+			}
+			if tf == 0 {
+				continue
+			}
+			df := float64(idx.docFreq[term])
+			idf := math.Log(1 + (n-df+0.5)/(df+0.5))
+			score += idf * tf / (bm25K1 + tf)
+		}
+
+		if score > 0 {
+			matches = append(matches, scored{doc: doc, score: score})
+		}
+	}
+
+	// documents are in name order, so a stable sort breaks ties by name
+	sort.SliceStable(matches, func(i, j int) bool { return matches[i].score > matches[j].score })
+
+	results := []apiMatch{}
+	for _, m := range matches {
+		if len(results) == limit {
+			break
+		}
+
+		required := []string{}
+		for _, param := range m.doc.api.Params {
+			if param.Required {
+				required = append(required, param.Name)
+			}
+		}
+
+		results = append(results, apiMatch{
+			Name:           m.doc.api.Name,
+			Description:    m.doc.api.Description,
+			Async:          m.doc.api.Isasync,
+			Class:          classifyApi(m.doc.api.Name),
+			RequiredParams: required,
+			Score:          math.Round(m.score*100) / 100,
+		})
+	}
+
+	return results
+}
+
+// searchTokens splits text into lower case search terms, breaking camel case
+// names into words ("listVirtualMachines" -> list, virtual, machine) and
+// reducing plurals so that "hosts" finds "host"
+func searchTokens(text string) []string {
+	var words []string
+	for _, word := range strings.FieldsFunc(text, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}) {
+		words = append(words, strings.Fields(apiTitle(word))...)
+	}
+
+	tokens := []string{}
+	for _, word := range words {
+		word = strings.ToLower(word)
+		if searchStopwords[word] {
+			continue
+		}
+		tokens = append(tokens, stemToken(word))
+	}
+	return tokens
+}
+
+func stemToken(word string) string {
+	switch {
+	case len(word) > 4 && strings.HasSuffix(word, "ies"):
+		return strings.TrimSuffix(word, "ies") + "y"
+	case len(word) > 3 && strings.HasSuffix(word, "s") && !strings.HasSuffix(word, "ss"):
+		return strings.TrimSuffix(word, "s")
+	default:
+		return word
+	}
+}
+
+// searchApis runs query against the catalog, returning only APIs the policy allows
+func (s *Server) searchApis(query string, limit int) []apiMatch {
+	s.mu.RLock()
+	idx := s.searchIndex
+	s.mu.RUnlock()
+
+	if idx == nil {
+		return []apiMatch{}
+	}
+
+	return idx.search(query, limit, s.opts.Policy.Allows)
+}
+
+func (s *Server) registerSearchTools() {
+	search := mcp.NewTool(searchApisToolName,
+		mcp.WithDescription("Search the CloudStack APIs by what you want to do, e.g. \"list virtual machines\" or \"snapshot a volume\". Returns the best matching APIs with their required parameters."),
+		mcp.WithString("query", mcp.Required(), mcp.Description("what you want to do, or keywords from API names, descriptions and parameters")),
+		mcp.WithNumber("limit", mcp.Description("maximum number of APIs to return"), mcp.Min(1)),
+		mcp.WithTitleAnnotation("Search CloudStack APIs"),
+		mcp.WithReadOnlyHintAnnotation(true),
+		mcp.WithDestructiveHintAnnotation(false),
+		mcp.WithIdempotentHintAnnotation(true),
+		mcp.WithOpenWorldHintAnnotation(false),
+	)
+
+	s.mcpServer.AddTool(search, func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		query, _ := req.GetArguments()["query"].(string)
+		limit := defaultSearchResults
+		if l, ok := req.GetArguments()["limit"].(float64); ok && l >= 1 {
+			limit = int(l)
+		}
+
+		return jsonResult(s.searchApis(query, limit))
+	})
+
+	prompt := mcp.NewPrompt(findApiPromptName,
+		mcp.WithPromptDescription("Find the CloudStack API for a task"),
+		mcp.WithArgument("task", mcp.RequiredArgument(), mcp.ArgumentDescription("what you want to do in CloudStack")),
+	)
+
+	s.mcpServer.AddPrompt(prompt, func(ctx context.Context, req mcp.GetPromptRequest) (*mcp.GetPromptResult, error) {
+		task := req.Params.Arguments["task"]
+
+		var sb strings.Builder
+		fmt.Fprintf(&sb, "These CloudStack APIs best match the task %q, best first:\n\n", task)
+		matches := s.searchApis(task, defaultSearchResults)
+		for _, m := range matches {
+			fmt.Fprintf(&sb, "- %s (%s", m.Name, m.Class)
+			if m.Async {
+				sb.WriteString(", async")
+			}
+			sb.WriteString(")")
+			if len(m.RequiredParams) > 0 {
+				fmt.Fprintf(&sb, ", requires %s", strings.Join(m.RequiredParams, ", "))
+			}
+			fmt.Fprintf(&sb, ": %s\n", m.Description)
+		}
+		if len(matches) == 0 {
+			sb.WriteString("No API matched. Try other words, or search with " + searchApisToolName + ".\n")
+		}
+		sb.WriteString("\nPick the API that fits the task and call it with the required parameters.")
+
+		return mcp.NewGetPromptResult("CloudStack APIs for: "+task, []mcp.PromptMessage{
+			mcp.NewPromptMessage(mcp.RoleUser, mcp.NewTextContent(sb.String())),
+		}), nil
+	})
+}
@@ -0,0 +1,74 @@
+package mcp_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/walteh/cloudstack-mcp/pkg/cloudstack"
+	"github.com/walteh/cloudstack-mcp/pkg/mcp"
+)
+
+func Test_SearchApis(t *testing.T) {
+	// nothing listens on the API URL, so the embedded catalog is searched
+	s, err := mcp.NewServer(t.Context(), "http://127.0.0.1:1/client/api", cloudstack.Credentials{Mode: cloudstack.AuthModeAPIKey, APIKey: "key", SecretKey: "secret"}, mcp.ServerOpts{
+		CatalogCacheDir: t.TempDir(),
+		Policy:          &mcp.Policy{Exclude: mcp.PolicyRules{Names: []string{"rebootRouter"}}},
+	})
+	require.NoError(t, err)
+	defer s.Close()
+
+	search := func(query string) []string {
+		args, err := json.Marshal(map[string]any{"query": query, "limit": 3})
+		require.NoError(t, err)
+
+		resp, err := json.Marshal(s.Server().HandleMessage(t.Context(), []byte(`{"jsonrpc":"2.0","id":1,"method":"tools/call","params":{"name":"cs_search_apis","arguments":`+string(args)+`}}`)))
+		require.NoError(t, err)
+
+		var rpc struct {
+			Result struct {
+				Content []struct {
+					Text string `json:"text"`
+				} `json:"content"`
+			} `json:"result"`
+		}
+		require.NoError(t, json.Unmarshal(resp, &rpc))
+		require.NotEmpty(t, rpc.Result.Content)
+
+		var matches []struct {
+			Name           string   `json:"name"`
+			RequiredParams []string `json:"requiredparams"`
+		}
+		require.NoError(t, json.Unmarshal([]byte(rpc.Result.Content[0].Text), &matches))
+
+		names := []string{}
+		for _, m := range matches {
+			names = append(names, m.Name)
+		}
+		return names
+	}
+
+	tests := []struct {
+		query string
+		first string
+	}{
+		{query: "list virtual machines", first: "listVirtualMachines"},
+		{query: "deploy a vm", first: "deployVirtualMachine"},
+		{query: "hypervisors", first: "listHypervisors"},
+		{query: "create firewall rule", first: "createFirewallRule"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			got := search(tt.query)
+			require.NotEmpty(t, got)
+			assert.Equal(t, tt.first, got[0])
+		})
+	}
+
+	assert.NotContains(t, search("reboot router"), "rebootRouter")
+
+	resp, err := json.Marshal(s.Server().HandleMessage(t.Context(), []byte(`{"jsonrpc":"2.0","id":2,"method":"prompts/get","params":{"name":"find_cloudstack_api","arguments":{"task":"deploy a vm"}}}`)))
+	require.NoError(t, err)
+	assert.Contains(t, string(resp), "deployVirtualMachine (mutate, async), requires zoneid, templateid, serviceofferingid")
+}
@@ -5,7 +5,10 @@ import (
 	"encoding/json"
 	"fmt"
 	"strings"
+	"sync"
+	"time"
 
+	csgo "github.com/apache/cloudstack-go/v2/cloudstack"
 	"github.com/mark3labs/mcp-go/mcp"
 	"github.com/mark3labs/mcp-go/server"
 	"github.com/rs/zerolog"
@@ -13,37 +16,151 @@ import (
 	errors "gitlab.com/tozd/go/errors"
 )
 
+// ServerOpts configures the behaviour of the MCP server
+type ServerOpts struct {
+	// JobTimeout is how long async API calls are polled before the job id is returned instead
+	JobTimeout time.Duration
+	// MaxListItems caps how many items an auto-paginated list call returns
+	MaxListItems int
+	// MaxResponseBytes truncates tool results that do not set max_bytes themselves
+	MaxResponseBytes int
+	// Policy limits which APIs are registered as tools; nil registers every API
+	Policy *Policy
+	// SafeMode rejects mutating and destructive calls unless they were previewed with
+	// dry_run and then confirmed
+	SafeMode bool
+	// PreviewTTL is how long a safe mode preview can be confirmed for; zero uses
+	// ten minutes
+	PreviewTTL time.Duration
+	// CatalogCacheDir is where listApis results are cached per endpoint and CloudStack
+	// version; empty uses the user cache directory
+	CatalogCacheDir string
+	// CatalogRefreshInterval is how often the API catalog is fetched again to pick up
+	// enabled plugins or upgrades; zero only refreshes on demand
+	CatalogRefreshInterval time.Duration
+	// ToolMode registers one tool per API (flat, the default) or only the
+	// cs_search_apis, cs_describe_api and cs_call_api meta-tools (grouped)
+	ToolMode ToolMode
+	// ResourcePollInterval is how often subscribed resources are read again to
+	// notify subscribers of changes; zero uses one minute
+	ResourcePollInterval time.Duration
+	// ResolveNames lets id parameters like zoneid or networkids be given as
+	// resource names, which are looked up with the matching list API
+	ResolveNames bool
+	// Profiles are the CloudStack endpoints and accounts a call can be made
+	// with through its profile argument, instead of the server's own. Tools and
+	// resources still come from the server's own endpoint.
+	Profiles *cloudstack.Profiles
+	// SessionCredentials makes every client call CloudStack with its own API key
+	// pair, sent in headers (see SessionCredentialsContextFunc) or through the
+	// cs_authenticate tool. The server's own credentials then only read the API
+	// catalog. It cannot be combined with Profiles.
+	SessionCredentials bool
+}
+
 // Server represents an MCP server for CloudStack
 type Server struct {
-	username  string
-	password  string
+	creds     cloudstack.Credentials
 	apiURL    string
+	opts      ServerOpts
 	mcpServer *server.MCPServer
+	previews  *previews
+	// sessionCreds are the credentials sessions authenticated with, see ServerOpts.SessionCredentials
+	sessionCreds *sessionCredentials
+
+	// resolvers cache resolved names per endpoint and user, see resolver
+	resolversMu sync.Mutex
+	resolvers   map[string]*cloudstack.Resolver
+
+	subscriptions *resourceSubscriptions
+	// startPolling starts the subscribed resource poller on the first subscription
+	startPolling sync.Once
+
+	// ctx is cancelled by Close to stop the background catalog refreshes and
+	// resource polling, which wg waits for
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	// refreshMu serialises catalog refreshes
+	refreshMu sync.Mutex
+
+	mu          sync.RWMutex
+	apis        map[string]*csgo.Api
+	searchIndex *searchIndex
 }
 
 // NewServer creates a new MCP server
-func NewServer(ctx context.Context, username, password, apiURL string) (*Server, error) {
+func NewServer(ctx context.Context, apiURL string, creds cloudstack.Credentials, opts ServerOpts) (*Server, error) {
 	logger := zerolog.Ctx(ctx)
-	logger.Info().Msg("Creating CloudStack MCP server")
+	logger.Info().Str("auth_mode", string(creds.Mode)).Msg("Creating CloudStack MCP server")
+
+	if err := creds.Validate(); err != nil {
+		return nil, errors.Errorf("validating credentials: %w", err)
+	}
+
+	if opts.SessionCredentials && opts.Profiles != nil {
+		return nil, errors.New("session credentials cannot be combined with profiles")
+	}
 
 	s := &Server{
-		username: username,
-		password: password,
+		creds:    creds,
 		apiURL:   apiURL,
-		mcpServer: server.NewMCPServer(
-			"CloudStackMCP",
-			"1.0.0",
-			server.WithToolCapabilities(false),
-			server.WithResourceCapabilities(false, false),
-			server.WithInstructions("CloudStack MCP server provides tools to interact with CloudStack"),
-		),
+		opts:     opts,
+		apis:     map[string]*csgo.Api{},
+		previews: newPreviews(opts.PreviewTTL),
+
+		sessionCreds:  newSessionCredentials(),
+		resolvers:     map[string]*cloudstack.Resolver{},
+		subscriptions: newResourceSubscriptions(),
+	}
+
+	// the server outlives NewServer's caller, so only logging is kept from ctx
+	s.ctx, s.cancel = context.WithCancel(zerolog.Ctx(ctx).WithContext(context.Background()))
+
+	instructions := "CloudStack MCP server provides tools to interact with CloudStack"
+	if opts.ResolveNames {
+		instructions += ". Id parameters like zoneid or templateid also accept the name of the resource"
 	}
 
+	hooks := &server.Hooks{}
+	s.addSubscriptionHooks(hooks)
+	s.addSessionCredentialsHooks(hooks)
+
+	s.mcpServer = server.NewMCPServer(
+		"CloudStackMCP",
+		"1.0.0",
+		server.WithToolCapabilities(true),
+		server.WithResourceCapabilities(true, false),
+		server.WithPromptCapabilities(false),
+		server.WithInstructions(instructions),
+		server.WithHooks(hooks),
+	)
+
 	// Register the dynamic tools based on CloudStack API
 	if err := s.registerDynamicTools(ctx); err != nil {
+		s.Close()
 		return nil, errors.Errorf("registering dynamic tools: %w", err)
 	}
 
+	s.registerSearchTools()
+	if opts.ToolMode == ToolModeGrouped {
+		s.registerGroupedTools()
+	}
+	s.registerJobTools()
+	s.registerProfileTools()
+	s.registerAuthenticateTool()
+	s.registerRefreshTool()
+	s.registerWorkflowTools(ctx)
+	s.registerResources()
+	s.registerPrompts()
+
+	if opts.CatalogRefreshInterval > 0 {
+		s.goBackground(func(ctx context.Context) {
+			s.refreshCatalogPeriodically(ctx, opts.CatalogRefreshInterval)
+		})
+	}
+
 	// Register default tools as fallback
 	// s.registerDefaultTools(ctx)
 
@@ -56,23 +173,157 @@ func (s *Server) registerDynamicTools(ctx context.Context) error {
 	logger := zerolog.Ctx(ctx)
 	logger.Info().Msg("Registering dynamic tools based on CloudStack API")
 
-	tools, err := s.CreateToolForEachApi(ctx)
+	apis, online, err := s.loadCatalog(ctx)
 	if err != nil {
-		return errors.Errorf("creating tools for APIs: %w", err)
+		return errors.Errorf("loading API catalog: %w", err)
 	}
 
-	logger.Info().Int("count", len(tools)).Msg("Registering CloudStack API tools")
+	if _, err := s.applyCatalog(ctx, apis); err != nil {
+		return err
+	}
 
-	for _, tool := range tools {
-		s.mcpServer.AddTool(*tool, func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
-			return s.handleDynamicTool(ctx, req, tool.Name)
-		})
-		logger.Debug().Str("tool", tool.Name).Msg("Registered dynamic tool")
+	if !online {
+		s.goBackground(s.refreshCatalogUntilOnline)
 	}
 
 	return nil
 }
 
+// goBackground runs f on the server's context until Close
+func (s *Server) goBackground(f func(ctx context.Context)) {
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		f(s.ctx)
+	}()
+}
+
+// Close stops the background work of the server and waits for it to finish
+func (s *Server) Close() {
+	s.cancel()
+	s.wg.Wait()
+}
+
+// applyCatalog makes apis the server's catalog, registering the tools of APIs
+// that are new or changed and removing the tools of APIs that are gone
+func (s *Server) applyCatalog(ctx context.Context, apis []*csgo.Api) (catalogDiff, error) {
+	logger := zerolog.Ctx(ctx)
+
+	s.refreshMu.Lock()
+	defer s.refreshMu.Unlock()
+
+	catalog := make(map[string]*csgo.Api, len(apis))
+	for _, api := range apis {
+		// every API is kept in the catalog, since safe mode and job cancellation
+		// may need APIs the policy does not expose as tools
+		catalog[api.Name] = api
+	}
+
+	s.mu.RLock()
+	previous := s.apis
+	diff := diffCatalogs(previous, catalog)
+	s.mu.RUnlock()
+
+	if s.opts.ToolMode == ToolModeGrouped {
+		// the meta-tools read the catalog on every call, so there are no tools to update
+		s.setCatalog(catalog)
+		return diff, nil
+	}
+
+	// the schemas of the tools look up list APIs in the catalog, so it is
+	// applied first and put back if a tool cannot be built
+	s.setCatalog(catalog)
+
+	tools := []server.ServerTool{}
+	for _, name := range append(append([]string{}, diff.Added...), diff.Changed...) {
+		api := catalog[name]
+
+		if !s.opts.Policy.Allows(api) {
+			logger.Debug().Str("api", api.Name).Msg("Skipping API excluded by policy")
+			continue
+		}
+
+		tool, err := s.createTool(ctx, api)
+		if err != nil {
+			s.setCatalog(previous)
+			return catalogDiff{}, errors.Errorf("creating tool for %s: %w", api.Name, err)
+		}
+
+		tools = append(tools, server.ServerTool{
+			Tool: *tool,
+			Handler: func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+				return s.handleDynamicTool(ctx, req, tool.Name)
+			},
+		})
+	}
+
+	logger.Info().Int("count", len(apis)).Int("added", len(diff.Added)).Int("removed", len(diff.Removed)).Int("changed", len(diff.Changed)).Msg("Applied CloudStack API catalog")
+
+	// both calls notify clients with notifications/tools/list_changed
+	if len(diff.Removed) > 0 {
+		s.mcpServer.DeleteTools(diff.Removed...)
+	}
+	if len(tools) > 0 {
+		s.mcpServer.AddTools(tools...)
+	}
+
+	return diff, nil
+}
+
+func (s *Server) setCatalog(catalog map[string]*csgo.Api) {
+	idx := newSearchIndex(catalog)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.apis = catalog
+	s.searchIndex = idx
+}
+
+// api returns the listApis metadata of the named API, or nil if it is unknown
+func (s *Server) api(name string) *csgo.Api {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.apis[name]
+}
+
+// call makes a single CloudStack API call as the server's user, as the
+// profile ctx was given by withProfile, or with session credentials as the
+// client's own user
+func (s *Server) call(ctx context.Context, command string, params map[string]string) (json.RawMessage, error) {
+	ctx, err := s.withClientCredentials(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	apiURL, creds := s.endpoint(ctx)
+	return cloudstack.DoRawCloudStackRequest(ctx, apiURL, command, creds, params)
+}
+
+// resolver returns the name resolver of the endpoint and user calls made with
+// ctx use, so names one user may see are never resolved for another
+func (s *Server) resolver(ctx context.Context) (*cloudstack.Resolver, error) {
+	ctx, err := s.withClientCredentials(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	apiURL, creds := s.endpoint(ctx)
+	if c, ok := cloudstack.CredentialsFromContext(ctx); ok {
+		creds = c
+	}
+	key := apiURL + "\x00" + credentialsIdentity(creds)
+
+	s.resolversMu.Lock()
+	defer s.resolversMu.Unlock()
+
+	r, ok := s.resolvers[key]
+	if !ok {
+		r = cloudstack.NewResolver(s.call, 0)
+		s.resolvers[key] = r
+	}
+	return r, nil
+}
+
 // handleDynamicTool is a generic handler for dynamically created tools
 func (s *Server) handleDynamicTool(ctx context.Context, req mcp.CallToolRequest, toolID string) (*mcp.CallToolResult, error) {
 	logger := zerolog.Ctx(ctx).With().Str("tool", toolID).Logger()
@@ -88,64 +339,146 @@ func (s *Server) handleDynamicTool(ctx context.Context, req mcp.CallToolRequest,
 
 	logger.Debug().Str("apiName", apiName).Msg("Executing CloudStack API")
 
-	// Convert mcp.Params to a map of strings for the CloudStack API
-	params := make(map[string]string)
-	for key, value := range req.Params.Arguments {
-		if value == nil {
-			continue
+	api := s.api(apiName)
+
+	if api == nil || !cloudstack.HasParam(api, argProfile) {
+		profileCtx, err := s.requestProfile(ctx, req)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
 		}
+		ctx = profileCtx
+	}
 
-		// Convert value to string based on type
-		switch v := value.(type) {
-		case string:
-			params[key] = v
-		case float64:
-			params[key] = fmt.Sprintf("%v", v)
-		case bool:
-			params[key] = fmt.Sprintf("%t", v)
-		case []interface{}:
-			// Convert array to comma-separated string
-
-			var strValues []string
-			for _, item := range v {
-				strValues = append(strValues, fmt.Sprintf("%v", item))
-			}
-			params[key] = strings.Join(strValues, ",")
-		case map[string]interface{}:
-			// Marshal map to JSON string
-			jsonBytes, err := json.Marshal(v)
-			if err != nil {
-				logger.Warn().Err(err).Str("param", key).Msg("Failed to marshal object parameter")
-				continue
-			}
-			params[key] = string(jsonBytes)
-		default:
-			// Default conversion for other types
-			params[key] = fmt.Sprintf("%v", v)
+	arguments := req.GetArguments()
+
+	if api != nil && s.opts.ResolveNames {
+		resolved, res, err := s.resolveNames(ctx, api, arguments)
+		if res != nil || err != nil {
+			return res, err
+		}
+		arguments = resolved
+	}
+
+	if api != nil {
+		sch, err := s.inputSchema(ctx, api)
+		if err != nil {
+			return nil, err
 		}
+		if issues := validateArguments(sch, arguments); len(issues) > 0 {
+			logger.Debug().Interface("issues", issues).Msg("Rejecting invalid arguments")
+			return validationErrorResult(apiName, issues)
+		}
+	}
+
+	args, opts, err := splitArguments(api, arguments)
+	if err != nil {
+		return mcp.NewToolResultError(err.Error()), nil
+	}
+
+	params, err := cloudstack.EncodeParams(api, args)
+	if err != nil {
+		return mcp.NewToolResultError(err.Error()), nil
+	}
+
+	if res, err := s.checkSafeMode(ctx, apiName, params, opts); res != nil || err != nil {
+		return res, err
 	}
 
 	// Execute the API call
 	logger.Debug().Interface("params", params).Msg("Calling CloudStack API")
 
 	// Call the dynamic API
-	result, err := cloudstack.DoRawCloudStackRequest(ctx, s.apiURL, apiName, s.username, s.password, params)
+	var result json.RawMessage
+	if isPaginated(api) && (opts.autoPaginate || opts.cursor != "") {
+		result, err = s.paginate(ctx, apiName, params, opts)
+	} else {
+		result, err = s.call(ctx, apiName, params)
+	}
 	if err != nil {
 		logger.Error().Err(err).Msg("CloudStack API call failed")
-		return nil, errors.Errorf("error executing CloudStack API: %w", err)
+		return apiErrorResult(api, apiName, err)
+	}
+
+	if api != nil && api.Isasync {
+		if jobID, ok := cloudstack.JobIDFromResponse(result); ok {
+			logger.Debug().Str("jobid", jobID).Msg("CloudStack API started async job")
+			return s.handleAsyncJob(ctx, req, apiName, params, jobID, opts)
+		}
 	}
 
 	logger.Debug().Msg("Dynamic tool executed successfully")
 
-	marsh, err := json.Marshal(result)
+	if opts.shape.maxBytes == 0 {
+		opts.shape.maxBytes = s.opts.MaxResponseBytes
+	}
+
+	return shapeResponse(apiName, result, opts.shape)
+}
+
+// apiErrorResult returns a failed call as a tool error the model can act on.
+// Errors reported by CloudStack keep their codes and get a hint on how to
+// correct the call.
+func apiErrorResult(api *csgo.Api, apiName string, err error) (*mcp.CallToolResult, error) {
+	var aerr *cloudstack.APIError
+	if !errors.As(err, &aerr) {
+		return mcp.NewToolResultError(fmt.Sprintf("calling %s failed: %s", apiName, err)), nil
+	}
+
+	res := map[string]any{
+		"api":       apiName,
+		"errorcode": aerr.ErrorCode,
+		"errortext": aerr.ErrorText,
+		"kind":      aerr.Kind(),
+	}
+	if aerr.CSErrorCode != 0 {
+		res["cserrorcode"] = aerr.CSErrorCode
+	}
+	if aerr.Param != "" {
+		res["param"] = aerr.Param
+	}
+	if hint := aerr.Hint(); hint != "" {
+		res["hint"] = hint
+	}
+
+	if aerr.Kind() == cloudstack.ErrorKindMissingParameter && api != nil {
+		required := []string{}
+		for _, param := range api.Params {
+			if param.Required {
+				required = append(required, param.Name)
+			}
+		}
+		res["requiredparams"] = required
+	}
+
+	// values listed in a parameter description are not enforced before the
+	// call, so they are offered once CloudStack rejects the parameter
+	if aerr.Param != "" && api != nil {
+		for _, param := range api.Params {
+			if param.Name == aerr.Param && param.Type == "string" {
+				if values := describedValues(param.Description); values != nil {
+					res["documentedvalues"] = values
+				}
+			}
+		}
+	}
+
+	marsh, err := json.Marshal(res)
 	if err != nil {
-		return nil, errors.Errorf("error marshalling result: %w", err)
+		return nil, errors.Errorf("marshalling API error: %w", err)
 	}
 
-	return mcp.NewToolResultText(string(marsh)), nil
+	return mcp.NewToolResultError(string(marsh)), nil
 }
 
 // Start starts the MCP server
 func (s *Server) Server() *server.MCPServer {
 	return s.mcpServer
 }
+
+// sessionID returns the id of the client session making the request, or "" outside of one
+func sessionID(ctx context.Context) string {
+	if session := server.ClientSessionFromContext(ctx); session != nil {
+		return session.SessionID()
+	}
+	return ""
+}
@@ -0,0 +1,301 @@
+package mcp
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/walteh/cloudstack-mcp/pkg/cloudstack"
+	errors "gitlab.com/tozd/go/errors"
+)
+
+// summaryColumns are preferred, in order, as table columns when no fields are requested
+var summaryColumns = []string{"id", "name", "displayname", "state", "status", "zonename", "account", "created"}
+
+const maxSummaryColumns = 6
+
+// shapeOptions controls how a CloudStack response is turned into a tool result
+type shapeOptions struct {
+	// fields keeps only these keys of the response or of each listed item
+	fields []string
+	// summary renders a markdown table instead of JSON
+	summary bool
+	// maxBytes truncates the text content to roughly this size
+	maxBytes int
+	// includeRaw attaches the untouched response as an embedded resource
+	includeRaw bool
+}
+
+// shapedBody is an unwrapped CloudStack response. List responses have their
+// items split out so they can be projected and truncated on their own.
+type shapedBody struct {
+	fields  map[string]any
+	itemKey string
+	items   []map[string]any
+}
+
+// shapeResponse unwraps the response envelope of apiName, applies the
+// projection and size limit and renders it as JSON or as a markdown summary
+func shapeResponse(apiName string, raw json.RawMessage, opts shapeOptions) (*mcp.CallToolResult, error) {
+	body, err := parseShapedBody(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(opts.fields) > 0 {
+		body.project(opts.fields)
+	}
+
+	text, err := body.render(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	if opts.maxBytes > 0 && len(text) > opts.maxBytes {
+		text, err = body.truncate(opts)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	result := mcp.NewToolResultText(text)
+
+	if opts.includeRaw {
+		result.Content = append(result.Content, mcp.NewEmbeddedResource(mcp.TextResourceContents{
+			URI:      "cloudstack://response/" + apiName,
+			MIMEType: "application/json",
+			Text:     string(raw),
+		}))
+	}
+
+	return result, nil
+}
+
+func parseShapedBody(raw json.RawMessage) (*shapedBody, error) {
+	unwrapped, err := cloudstack.UnwrapResponse(raw)
+	if err != nil {
+		unwrapped = raw
+	}
+
+	body := &shapedBody{}
+	if err := json.Unmarshal(unwrapped, &body.fields); err != nil {
+		return nil, errors.Errorf("unmarshalling response: %w", err)
+	}
+
+	// a response has at most one listing in practice, but keys are walked in
+	// order so that the pick does not depend on map iteration
+	for _, key := range sortedKeys(body.fields) {
+		list, ok := body.fields[key].([]any)
+		if !ok {
+			continue
+		}
+
+		items := make([]map[string]any, 0, len(list))
+		for _, item := range list {
+			obj, ok := item.(map[string]any)
+			if !ok {
+				items = nil
+				break
+			}
+			items = append(items, obj)
+		}
+
+		if items != nil {
+			body.itemKey = key
+			body.items = items
+			delete(body.fields, key)
+			break
+		}
+	}
+
+	return body, nil
+}
+
+// project keeps only the given keys, on each item for list responses and on
+// the response itself otherwise. The count and cursor of a listing are kept,
+// and a response holding a single object, like {"capability":{...}}, is
+// projected inside that object.
+func (b *shapedBody) project(fields []string) {
+	keep := func(obj map[string]any) map[string]any {
+		out := make(map[string]any, len(fields))
+		for _, f := range fields {
+			if v, ok := obj[f]; ok {
+				out[f] = v
+			}
+		}
+		return out
+	}
+
+	if b.itemKey != "" {
+		for i, item := range b.items {
+			b.items[i] = keep(item)
+		}
+		return
+	}
+
+	if len(b.fields) == 1 {
+		for key, value := range b.fields {
+			if obj, ok := value.(map[string]any); ok {
+				b.fields[key] = keep(obj)
+				return
+			}
+		}
+	}
+
+	b.fields = keep(b.fields)
+}
+
+func (b *shapedBody) object() map[string]any {
+	out := make(map[string]any, len(b.fields)+1)
+	for k, v := range b.fields {
+		out[k] = v
+	}
+	if b.itemKey != "" {
+		out[b.itemKey] = b.items
+	}
+	return out
+}
+
+func (b *shapedBody) render(opts shapeOptions) (string, error) {
+	if opts.summary {
+		return b.markdown(opts.fields), nil
+	}
+
+	marsh, err := json.Marshal(b.object())
+	if err != nil {
+		return "", errors.Errorf("marshalling response: %w", err)
+	}
+
+	return string(marsh), nil
+}
+
+// truncate drops trailing items of a list response until it fits in maxBytes.
+// Other responses are cut at maxBytes.
+func (b *shapedBody) truncate(opts shapeOptions) (string, error) {
+	if b.itemKey == "" {
+		text, err := b.render(opts)
+		if err != nil {
+			return "", err
+		}
+		return strings.ToValidUTF8(text[:opts.maxBytes], "") + "\n... [truncated]", nil
+	}
+
+	all := b.items
+	defer func() { b.items = all }()
+
+	// binary search for the most items that still fit
+	lo, hi := 0, len(all)
+	for lo < hi {
+		mid := (lo + hi + 1) / 2
+		b.items = all[:mid]
+		text, err := b.render(opts)
+		if err != nil {
+			return "", err
+		}
+		if len(text) <= opts.maxBytes {
+			lo = mid
+		} else {
+			hi = mid - 1
+		}
+	}
+
+	b.items = all[:lo]
+	b.fields["truncated"] = fmt.Sprintf("showing %d of %d items, request fewer fields or raise max_bytes to see more", lo, len(all))
+	defer delete(b.fields, "truncated")
+
+	return b.render(opts)
+}
+
+// markdown renders the response as a markdown table
+func (b *shapedBody) markdown(fields []string) string {
+	var sb strings.Builder
+
+	if b.itemKey == "" {
+		sb.WriteString("| key | value |\n| --- | --- |\n")
+		for _, k := range sortedKeys(b.fields) {
+			fmt.Fprintf(&sb, "| %s | %s |\n", k, markdownCell(b.fields[k]))
+		}
+		return sb.String()
+	}
+
+	columns := fields
+	if len(columns) == 0 {
+		columns = defaultSummaryColumns(b.items)
+	}
+
+	fmt.Fprintf(&sb, "%s: %d", b.itemKey, len(b.items))
+	for _, k := range sortedKeys(b.fields) {
+		fmt.Fprintf(&sb, ", %s: %s", k, markdownCell(b.fields[k]))
+	}
+	sb.WriteString("\n\n")
+
+	sb.WriteString("| " + strings.Join(columns, " | ") + " |\n")
+	sb.WriteString("|" + strings.Repeat(" --- |", len(columns)) + "\n")
+
+	for _, item := range b.items {
+		cells := make([]string, len(columns))
+		for i, c := range columns {
+			cells[i] = markdownCell(item[c])
+		}
+		sb.WriteString("| " + strings.Join(cells, " | ") + " |\n")
+	}
+
+	return sb.String()
+}
+
+// defaultSummaryColumns picks the preferred summary columns present in the
+// items, topped up with other scalar keys
+func defaultSummaryColumns(items []map[string]any) []string {
+	present := map[string]bool{}
+	for _, item := range items {
+		for k, v := range item {
+			switch v.(type) {
+			case map[string]any, []any:
+			default:
+				present[k] = true
+			}
+		}
+	}
+
+	columns := []string{}
+	for _, c := range summaryColumns {
+		if present[c] && len(columns) < maxSummaryColumns {
+			columns = append(columns, c)
+			delete(present, c)
+		}
+	}
+
+	for _, k := range sortedKeys(present) {
+		if len(columns) >= maxSummaryColumns {
+			break
+		}
+		columns = append(columns, k)
+	}
+
+	return columns
+}
+
+func markdownCell(v any) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return strings.ReplaceAll(v, "|", "\\|")
+	case map[string]any, []any:
+		marsh, _ := json.Marshal(v)
+		return strings.ReplaceAll(string(marsh), "|", "\\|")
+	default:
+		return fmt.Sprintf("%v", v)
+	}
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
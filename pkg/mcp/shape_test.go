@@ -0,0 +1,111 @@
+package mcp_test
+
+import (
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/walteh/cloudstack-mcp/pkg/mcp"
+)
+
+func Test_ShapeResponse(t *testing.T) {
+	var body atomic.Value
+
+	s := newTestServer(t, `[{"name":"listVirtualMachines","params":[]}]`, func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"listvirtualmachinesresponse":%s}`, body.Load())
+	}, mcp.ServerOpts{})
+
+	const vms = `{"count":2,"virtualmachine":[
+		{"id":"vm1","name":"web","state":"Running","nic":[{"id":"n1"}]},
+		{"id":"vm2","name":"db","state":"Stopped","nic":[{"id":"n2"}]}
+	]}`
+
+	tests := []struct {
+		name     string
+		body     string
+		args     string
+		wantJSON string
+		wantText string
+	}{
+		{
+			name:     "unwrap",
+			body:     vms,
+			args:     `{}`,
+			wantJSON: vms,
+		},
+		{
+			name:     "project list items",
+			body:     vms,
+			args:     `{"fields":["id","state"]}`,
+			wantJSON: `{"count":2,"virtualmachine":[{"id":"vm1","state":"Running"},{"id":"vm2","state":"Stopped"}]}`,
+		},
+		{
+			name:     "project single object",
+			body:     `{"capability":{"cloudstackversion":"4.19.1.0","apilimitmax":25}}`,
+			args:     `{"fields":["cloudstackversion"]}`,
+			wantJSON: `{"capability":{"cloudstackversion":"4.19.1.0"}}`,
+		},
+		{
+			name:     "project flat object",
+			body:     `{"success":true,"displaytext":"done"}`,
+			args:     `{"fields":["success"]}`,
+			wantJSON: `{"success":true}`,
+		},
+		{
+			name:     "first listing by name",
+			body:     `{"zone":[{"id":"z1"}],"host":[{"id":"h1"}]}`,
+			args:     `{"summary":true}`,
+			wantText: "host: 1, zone: [{\"id\":\"z1\"}]\n\n| id |\n| --- |\n| h1 |\n",
+		},
+		{
+			name:     "summary",
+			body:     vms,
+			args:     `{"summary":true}`,
+			wantText: "virtualmachine: 2, count: 2\n\n| id | name | state |\n| --- | --- | --- |\n| vm1 | web | Running |\n| vm2 | db | Stopped |\n",
+		},
+		{
+			name:     "summary of fields",
+			body:     vms,
+			args:     `{"summary":true,"fields":["name","nic"]}`,
+			wantText: "virtualmachine: 2, count: 2\n\n| name | nic |\n| --- | --- |\n| web | [{\"id\":\"n1\"}] |\n| db | [{\"id\":\"n2\"}] |\n",
+		},
+		{
+			name:     "summary of object",
+			body:     `{"success":true,"displaytext":"a|b"}`,
+			args:     `{"summary":true}`,
+			wantText: "| key | value |\n| --- | --- |\n| displaytext | a\\|b |\n| success | true |\n",
+		},
+		{
+			name:     "truncate list",
+			body:     vms,
+			args:     `{"fields":["id"],"max_bytes":50}`,
+			wantJSON: `{"count":2,"virtualmachine":[{"id":"vm1"}],"truncated":"showing 1 of 2 items, request fewer fields or raise max_bytes to see more"}`,
+		},
+		{
+			name:     "truncate object",
+			body:     `{"displaytext":"abcdefghijklmnopqrstuvwxyz"}`,
+			args:     `{"max_bytes":20}`,
+			wantText: `{"displaytext":"abcd` + "\n... [truncated]",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body.Store(tt.body)
+
+			// parse several times, since a pick that depends on map order would vary
+			for range 5 {
+				text, isError := s.callToolText("listVirtualMachines", tt.args)
+				assert.False(t, isError, text)
+
+				if tt.wantJSON != "" {
+					assert.JSONEq(t, tt.wantJSON, text)
+				} else {
+					assert.Equal(t, tt.wantText, text)
+				}
+			}
+		})
+	}
+}
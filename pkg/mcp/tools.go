@@ -3,6 +3,7 @@ package mcp
 import (
 	"context"
 	"encoding/json"
+	"strings"
 
 	csgo "github.com/apache/cloudstack-go/v2/cloudstack"
 	"github.com/mark3labs/mcp-go/mcp"
@@ -16,39 +17,26 @@ import (
 
 func (me *Server) CreateToolForEachApi(ctx context.Context) ([]*mcp.Tool, error) {
 
-	logger := zerolog.Ctx(ctx)
-
-	listOfApisPtr, err := cloudstack.DoTypedCloudStackRequest[csgo.ListApisResponse](ctx, me.apiURL, "listApis", me.username, me.password, map[string]string{})
-	if err != nil {
-		return nil, errors.Errorf("getting list of APIs: %w", err)
+	me.mu.RLock()
+	listOfApis := make([]*csgo.Api, 0, len(me.apis))
+	for _, name := range sortedKeys(me.apis) {
+		listOfApis = append(listOfApis, me.apis[name])
 	}
-
-	listOfApis := listOfApisPtr.Apis
-
-	logger.Info().Msgf("List of APIs: %v", listOfApis)
+	me.mu.RUnlock()
 
 	tools := make([]*mcp.Tool, 0, len(listOfApis))
 
 	for _, api := range listOfApis {
-		_, err := getToolOptionsFromType(ctx, api)
-		if err != nil {
-			return nil, errors.Errorf("getting tool types: %w", err)
+		if !me.opts.Policy.Allows(api) {
+			continue
 		}
 
-		typ, err := CloudStackApiToJsonSchema(ctx, api)
+		tool, err := me.createTool(ctx, api)
 		if err != nil {
-			return nil, errors.Errorf("getting tool types: %w", err)
+			return nil, err
 		}
 
-		jsonSchema, err := json.Marshal(typ)
-		if err != nil {
-			return nil, errors.Errorf("marshalling tool types: %w", err)
-		}
-
-		// tool := mcp.NewTool(api.Name, sch...)
-		tool := mcp.NewToolWithRawSchema(api.Name, api.Description, jsonSchema)
-
-		tools = append(tools, &tool)
+		tools = append(tools, tool)
 	}
 
 	// fpr each named tool get the type from the csgo package and create a tool for each api
@@ -56,62 +44,89 @@ func (me *Server) CreateToolForEachApi(ctx context.Context) ([]*mcp.Tool, error)
 	return tools, nil
 }
 
+// listApis fetches the API catalog from CloudStack
+func (me *Server) listApis(ctx context.Context) ([]*csgo.Api, error) {
+
+	logger := zerolog.Ctx(ctx)
+
+	// the catalog is shared by every client, so it is always read as the server's user
+	ctx = cloudstack.WithCredentials(ctx, me.creds)
+
+	listOfApisPtr, err := cloudstack.DoTypedCloudStackRequest[csgo.ListApisResponse](ctx, me.apiURL, "listApis", me.creds, map[string]string{})
+	if err != nil {
+		return nil, errors.Errorf("getting list of APIs: %w", err)
+	}
+
+	logger.Info().Msgf("List of APIs: %v", listOfApisPtr.Apis)
+
+	return listOfApisPtr.Apis, nil
+}
+
+// inputSchema returns the input schema of the tool for api: its parameters
+// and the control arguments that apply to it
+func (me *Server) inputSchema(ctx context.Context, api *csgo.Api) (*jsonschema.Schema, error) {
+	typ, err := CloudStackApiToJsonSchema(ctx, api)
+	if err != nil {
+		return nil, errors.Errorf("getting tool types: %w", err)
+	}
+
+	addCallOptionsToSchema(typ, api, me.opts)
+	if me.opts.ResolveNames {
+		me.resolvableSchema(typ, api)
+	}
+
+	return typ, nil
+}
+
+// createTool builds the MCP tool for a single CloudStack API
+func (me *Server) createTool(ctx context.Context, api *csgo.Api) (*mcp.Tool, error) {
+	typ, err := me.inputSchema(ctx, api)
+	if err != nil {
+		return nil, err
+	}
+
+	jsonSchema, err := json.Marshal(typ)
+	if err != nil {
+		return nil, errors.Errorf("marshalling tool types: %w", err)
+	}
+
+	// tool := mcp.NewTool(api.Name, sch...)
+	tool := mcp.NewToolWithRawSchema(api.Name, api.Description, jsonSchema)
+	tool.Annotations = toolAnnotations(api)
+
+	return &tool, nil
+}
+
 func CloudStackApiToJsonSchema(ctx context.Context, api *csgo.Api) (*jsonschema.Schema, error) {
 
 	logger := zerolog.Ctx(ctx)
 
 	sch := &jsonschema.Schema{
 		Title:       api.Name + "InputParams",
-		Description: api.Description,
+		Description: strings.TrimSuffix(api.Description, ".") + " input params",
 		Required:    []string{},
 		Type:        "object",
 		Properties:  orderedmap.New[string, *jsonschema.Schema](),
 	}
 
+	if api.Since != "" {
+		sch.Extras = map[string]any{extraSince: api.Since}
+	}
+
 	for _, param := range api.Params {
-		prop := &jsonschema.Schema{}
-		logger.Info().Msgf("Param: %v", param)
-		prop.Type = param.Type
-		switch param.Type {
-		case "string":
-			prop.Type = "string"
-		case "number", "integer", "long", "short":
-			prop.Type = "number"
-			prop.Minimum = "0"
-		case "boolean":
-			prop.Type = "boolean"
-		case "object", "map":
-			prop.Type = "object"
-			prop.Properties = orderedmap.New[string, *jsonschema.Schema]()
-		case "array", "list":
-			prop.Type = "array"
-			prop.Items = &jsonschema.Schema{
-				Type: "string",
-			}
-		case "uuid":
-			prop.Type = "string"
-			prop.Format = "uuid"
-			// prop.Pattern = "[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}"
-		case "date":
-			prop.Type = "string"
-			prop.Format = "date"
-		case "datetime":
-			prop.Type = "string"
-			prop.Format = "date-time"
-		default:
-			return nil, errors.Errorf("unknown type: %s", param.Type)
+		logger.Trace().Str("api", api.Name).Str("param", param.Name).Str("type", param.Type).Msg("Converting param to JSON schema")
+
+		prop, err := paramSchema(param)
+		if err != nil {
+			return nil, errors.Errorf("converting param %s of %s: %w", param.Name, api.Name, err)
 		}
+
 		if param.Required {
 			sch.Required = append(sch.Required, param.Name)
 		}
 		sch.Properties.Set(param.Name, prop)
-
 	}
 
-	// get the type from the csgo package
-
-	// convert the type to raw jsonshcmea
-
 	return sch, nil
 }
 
@@ -141,55 +156,3 @@ func CloudStackApiToJsonSchema(ctx context.Context, api *csgo.Api) (*jsonschema.
 
 // func jsonSchemaFromType(ctx context.Context, typ *jsonschema.Schema) (*mcp.ToolInputSchema, error) {
 // }
-
-func getToolOptionsFromType(ctx context.Context, api *csgo.Api) ([]mcp.ToolOption, error) {
-
-	toolOpts := []mcp.ToolOption{}
-
-	for _, param := range api.Params {
-
-		propOpts := []mcp.PropertyOption{}
-
-		if param.Required {
-			propOpts = append(propOpts, mcp.Required())
-		}
-
-		propOpts = append(propOpts, mcp.Description(param.Description))
-
-		// if param.Length != nil {
-		// 	propOpts = append(propOpts, mcp.MaxLength(param.Length))
-		// }
-
-		switch param.Type {
-		case "string":
-			toolOpts = append(toolOpts, mcp.WithString(param.Name, propOpts...))
-		case "number", "integer", "long", "short":
-			toolOpts = append(toolOpts, mcp.WithNumber(param.Name, propOpts...))
-		case "boolean":
-			toolOpts = append(toolOpts, mcp.WithBoolean(param.Name, propOpts...))
-		case "object", "map":
-			propOpts = append(propOpts, mcp.Properties(map[string]any{}))
-			toolOpts = append(toolOpts, mcp.WithObject(param.Name, propOpts...))
-		case "array", "list":
-			propOpts = append(propOpts, mcp.Items(map[string]any{
-				"type": "string",
-			}))
-			toolOpts = append(toolOpts, mcp.WithArray(param.Name, propOpts...))
-			// pp.Println(param)
-		case "uuid":
-			propOpts = append(propOpts, mcp.Pattern("[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}"))
-			toolOpts = append(toolOpts, mcp.WithString(param.Name, propOpts...))
-		case "date":
-			propOpts = append(propOpts, mcp.Pattern("^\\d{4}-\\d{2}-\\d{2}$"))
-			toolOpts = append(toolOpts, mcp.WithString(param.Name, propOpts...))
-		case "datetime":
-			propOpts = append(propOpts, mcp.Pattern("^\\d{4}-\\d{2}-\\d{2}T\\d{2}:\\d{2}:\\d{2}Z$"))
-			toolOpts = append(toolOpts, mcp.WithString(param.Name, propOpts...))
-		default:
-			return nil, errors.Errorf("unknown type: %s", param.Type)
-		}
-
-	}
-
-	return toolOpts, nil
-}
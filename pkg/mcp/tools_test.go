@@ -1,17 +1,26 @@
 package mcp_test
 
 import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
 	"reflect"
 	"testing"
 
 	csgo "github.com/apache/cloudstack-go/v2/cloudstack"
 	"github.com/invopop/jsonschema"
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"github.com/walteh/cloudstack-mcp/pkg/diff"
 	"github.com/walteh/cloudstack-mcp/pkg/mcp"
 	orderedmap "github.com/wk8/go-ordered-map/v2"
 )
 
+var update = flag.Bool("update", false, "update golden files")
+
 func Test_CloudStackApiToJsonSchema(t *testing.T) {
 	type args struct {
 		api *csgo.Api
@@ -44,7 +53,7 @@ func Test_CloudStackApiToJsonSchema(t *testing.T) {
 				Type:        "object",
 				Properties: orderedmap.New[string, *jsonschema.Schema](orderedmap.WithInitialData(orderedmap.Pair[string, *jsonschema.Schema]{
 					Key:   "test",
-					Value: &jsonschema.Schema{Type: "string"},
+					Value: &jsonschema.Schema{Type: "string", Description: "test"},
 				})),
 			},
 		},
@@ -66,3 +75,100 @@ func Test_CloudStackApiToJsonSchema(t *testing.T) {
 		})
 	}
 }
+
+func Test_CloudStackApiToJsonSchema_Golden(t *testing.T) {
+	tests := []struct {
+		name string
+		api  *csgo.Api
+	}{
+		{
+			name: "deployVirtualMachine",
+			api: &csgo.Api{
+				Name:        "deployVirtualMachine",
+				Description: "Creates and automatically starts a virtual machine based on a service offering, disk offering, and template.",
+				Isasync:     true,
+				Since:       "4.0.0",
+				Params: []csgo.ApiParams{
+					{Name: "zoneid", Type: "uuid", Required: true, Related: "listZones", Description: "availability zone for the virtual machine"},
+					{Name: "serviceofferingid", Type: "uuid", Required: true, Related: "listServiceOfferings", Description: "the ID of the service offering for the virtual machine"},
+					{Name: "name", Type: "string", Length: 255, Description: "host name for the virtual machine"},
+					{Name: "rootdisksize", Type: "long", Since: "4.4", Description: "Optional field to resize root disk on deploy. Value is in GB"},
+					{Name: "networkids", Type: "list", Related: "createNetwork,listNetworks", Description: "list of network ids used by virtual machine"},
+					{Name: "details", Type: "map", Since: "4.3", Description: "used to specify the custom parameters."},
+					{Name: "bootmode", Type: "string", Since: "4.14.0.0", Description: "Boot Mode [Legacy] or [Secure] Applicable when Boot Type Selected is UEFI, otherwise Legacy only for BIOS. Not applicable with VMware if the template is marked as deploy-as-is, as we honour what is defined in the template."},
+					{Name: "startvm", Type: "boolean", Description: "true if start vm after creating; defaulted to true if not specified"},
+				},
+			},
+		},
+		{
+			name: "listTemplates",
+			api: &csgo.Api{
+				Name:        "listTemplates",
+				Description: "List all public, private, and privileged templates.",
+				Params: []csgo.ApiParams{
+					{Name: "templatefilter", Type: "string", Required: true, Description: "possible values are \"featured\", \"self\", \"selfexecutable\",\"sharedexecutable\",\"executable\", and \"community\"."},
+					{Name: "startdate", Type: "date", Description: "list templates created on or after this date"},
+					{Name: "page", Type: "integer"},
+					{Name: "pagesize", Type: "integer"},
+				},
+			},
+		},
+		{
+			name: "createTags",
+			api: &csgo.Api{
+				Name:        "createTags",
+				Description: "Creates resource tag(s)",
+				Isasync:     true,
+				Since:       "4.0.0",
+				Params: []csgo.ApiParams{
+					{Name: "resourceids", Type: "list", Required: true, Description: "list of resources to create the tags for"},
+					{Name: "resourcetype", Type: "string", Required: true, Description: "type of the resource"},
+					{Name: "tags", Type: "map", Required: true, Description: "Map of tags (key/value pairs)"},
+					{Name: "protocol", Type: "string", Description: "the protocol of the rule. Valid values are: tcp, udp or icmp"},
+				},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := mcp.CloudStackApiToJsonSchema(t.Context(), tt.api)
+			require.NoError(t, err)
+
+			marsh, err := json.MarshalIndent(got, "", "\t")
+			require.NoError(t, err)
+
+			golden := filepath.Join("testdata", "schema", tt.name+".golden.json")
+			if *update {
+				require.NoError(t, os.MkdirAll(filepath.Dir(golden), 0o755))
+				require.NoError(t, os.WriteFile(golden, append(marsh, '\n'), 0o644))
+			}
+
+			want, err := os.ReadFile(golden)
+			require.NoError(t, err)
+			assert.JSONEq(t, string(want), string(marsh))
+		})
+	}
+}
+
+func Test_CloudStackApiToJsonSchema_UnknownType(t *testing.T) {
+	_, err := mcp.CloudStackApiToJsonSchema(t.Context(), &csgo.Api{
+		Name:   "test",
+		Params: []csgo.ApiParams{{Name: "test", Type: "blob"}},
+	})
+	require.Error(t, err)
+}
+
+func Test_ApiErrorResult(t *testing.T) {
+	s := newTestServer(t, `[{"name":"createZone","params":[{"name":"name","type":"string","required":true},{"name":"dns1","type":"string"}]}]`, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(431)
+		fmt.Fprint(w, `{"createzoneresponse":{"errorcode":431,"cserrorcode":9999,"errortext":"Unable to execute API command createzone due to missing parameter dns1"}}`)
+	}, mcp.ServerOpts{})
+
+	// dns1 is only required in some zone setups, so listApis does not mark it required
+	result := s.callTool("createZone", `{"name":"z"}`)
+
+	assert.Contains(t, result, `"isError":true`)
+	assert.Contains(t, result, `\"kind\":\"missing_parameter\"`)
+	assert.Contains(t, result, `\"param\":\"dns1\"`)
+	assert.Contains(t, result, `\"requiredparams\":[\"name\"]`)
+}
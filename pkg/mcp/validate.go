@@ -0,0 +1,298 @@
+package mcp
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/invopop/jsonschema"
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/walteh/cloudstack-mcp/pkg/cloudstack"
+	errors "gitlab.com/tozd/go/errors"
+)
+
+// dateTimeLayouts are the forms CloudStack accepts for datetime parameters
+var dateTimeLayouts = []string{time.RFC3339, "2006-01-02T15:04:05-0700", "2006-01-02 15:04:05"}
+
+// validationIssue is a problem with one argument of a tool call
+type validationIssue struct {
+	Param      string `json:"param"`
+	Problem    string `json:"problem"`
+	Suggestion string `json:"suggestion,omitempty"`
+}
+
+// validateArguments checks args against the input schema of a tool. It is as
+// lenient as EncodeParams: numbers and booleans may be sent as strings, and
+// lists as comma separated strings.
+func validateArguments(sch *jsonschema.Schema, args map[string]any) []validationIssue {
+	issues := []validationIssue{}
+
+	names := []string{}
+	for pair := sch.Properties.Oldest(); pair != nil; pair = pair.Next() {
+		names = append(names, pair.Key)
+	}
+
+	for _, name := range sortedKeys(args) {
+		value := args[name]
+		prop, ok := sch.Properties.Get(name)
+		if !ok {
+			issue := validationIssue{Param: name, Problem: "unknown parameter"}
+			if match := closestName(name, names); match != "" {
+				issue.Suggestion = fmt.Sprintf("did you mean %s?", match)
+			}
+			issues = append(issues, issue)
+			continue
+		}
+		if value == nil {
+			continue
+		}
+
+		if problem, suggestion := validateValue(prop, value); problem != "" {
+			issues = append(issues, validationIssue{Param: name, Problem: problem, Suggestion: suggestion})
+		}
+	}
+
+	for _, name := range sch.Required {
+		if args[name] == nil || args[name] == "" {
+			issues = append(issues, validationIssue{Param: name, Problem: "required parameter is missing"})
+		}
+	}
+
+	return issues
+}
+
+// validateValue returns what is wrong with value, and how to fix it if that can be told
+func validateValue(prop *jsonschema.Schema, value any) (string, string) {
+	switch prop.Type {
+	case "string":
+		s, ok := value.(string)
+		if !ok {
+			if _, scalar := value.(float64); !scalar {
+				if _, scalar := value.(bool); !scalar {
+					return fmt.Sprintf("must be a string, not %s", jsonType(value)), ""
+				}
+			}
+			return "", ""
+		}
+		return validateString(prop, s)
+	case "integer":
+		f, ok := number(value)
+		if !ok || f != math.Trunc(f) {
+			return fmt.Sprintf("must be an integer, not %s", describeValue(value)), ""
+		}
+	case "number":
+		if _, ok := number(value); !ok {
+			return fmt.Sprintf("must be a number, not %s", describeValue(value)), ""
+		}
+	case "boolean":
+		switch v := value.(type) {
+		case bool:
+		case string:
+			if _, err := strconv.ParseBool(v); err != nil {
+				return fmt.Sprintf("must be true or false, not %q", v), ""
+			}
+		default:
+			return fmt.Sprintf("must be true or false, not %s", jsonType(value)), ""
+		}
+	case "array":
+		return validateArray(prop, value)
+	}
+
+	return "", ""
+}
+
+// validateString checks a string against the schema. Only the enum of known
+// values is enforced; the values found in a description are left to CloudStack
+// to check, as they are often incomplete or cased differently.
+func validateString(prop *jsonschema.Schema, s string) (string, string) {
+	if len(prop.Enum) > 0 {
+		allowed := []string{}
+		for _, e := range prop.Enum {
+			allowed = append(allowed, fmt.Sprint(e))
+			if fmt.Sprint(e) == s {
+				return "", ""
+			}
+		}
+		for _, a := range allowed {
+			if strings.EqualFold(a, s) {
+				return fmt.Sprintf("%q is not an allowed value", s), fmt.Sprintf("did you mean %s?", a)
+			}
+		}
+		return fmt.Sprintf("%q is not an allowed value", s), "use one of " + strings.Join(allowed, ", ")
+	}
+
+	switch prop.Format {
+	case "uuid":
+		if !cloudstack.IsUUID(s) {
+			return fmt.Sprintf("%q is not a uuid", s), "look up the id with the matching list API"
+		}
+	case "date-time":
+		for _, layout := range dateTimeLayouts {
+			if _, err := time.Parse(layout, s); err == nil {
+				return "", ""
+			}
+		}
+		return fmt.Sprintf("%q is not a date and time", s), "use the form 2006-01-02T15:04:05+0000"
+	}
+
+	if prop.Pattern != "" {
+		if re, err := regexp.Compile(prop.Pattern); err == nil && !re.MatchString(s) {
+			return fmt.Sprintf("%q does not match %s", s, prop.Pattern), ""
+		}
+	}
+
+	if prop.MaxLength != nil && uint64(len(s)) > *prop.MaxLength {
+		return fmt.Sprintf("is %d characters long, more than the maximum of %d", len(s), *prop.MaxLength), ""
+	}
+
+	return "", ""
+}
+
+func validateArray(prop *jsonschema.Schema, value any) (string, string) {
+	item := prop.Items
+	if item == nil {
+		return "", ""
+	}
+
+	if item.Type == "object" {
+		// maps may also be sent as a single object, or already encoded
+		switch v := value.(type) {
+		case map[string]any, string:
+			return "", ""
+		case []any:
+			for i, entry := range v {
+				if _, ok := entry.(map[string]any); !ok {
+					return fmt.Sprintf("item %d must be an object, not %s", i, jsonType(entry)), ""
+				}
+			}
+			return "", ""
+		default:
+			return fmt.Sprintf("must be a list of objects, not %s", jsonType(value)), ""
+		}
+	}
+
+	var items []any
+	switch v := value.(type) {
+	case []any:
+		items = v
+	case string:
+		for _, s := range strings.Split(v, ",") {
+			items = append(items, strings.TrimSpace(s))
+		}
+	default:
+		return fmt.Sprintf("must be a list, not %s", jsonType(value)), ""
+	}
+
+	for i, entry := range items {
+		if problem, suggestion := validateValue(item, entry); problem != "" {
+			return fmt.Sprintf("item %d %s", i, problem), suggestion
+		}
+	}
+
+	return "", ""
+}
+
+// number returns value as a number, accepting numeric strings
+func number(value any) (float64, bool) {
+	switch v := value.(type) {
+	case float64:
+		return v, true
+	case string:
+		f, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+		return f, err == nil
+	default:
+		return 0, false
+	}
+}
+
+func jsonType(value any) string {
+	switch value.(type) {
+	case string:
+		return "a string"
+	case float64:
+		return "a number"
+	case bool:
+		return "a boolean"
+	case []any:
+		return "a list"
+	case map[string]any:
+		return "an object"
+	default:
+		return fmt.Sprintf("%T", value)
+	}
+}
+
+func describeValue(value any) string {
+	if s, ok := value.(string); ok {
+		return strconv.Quote(s)
+	}
+	return jsonType(value)
+}
+
+// closestName returns the candidate name is most likely a misspelling of, or
+// "" if none is close enough
+func closestName(name string, candidates []string) string {
+	normalize := func(s string) string {
+		return strings.NewReplacer("_", "", "-", "", " ", "").Replace(strings.ToLower(s))
+	}
+
+	best := ""
+	bestDistance := 0
+	for _, c := range candidates {
+		if normalize(c) == normalize(name) {
+			return c
+		}
+
+		d := editDistance(normalize(name), normalize(c))
+		// allow about one typo per three characters
+		if d <= max(1, len(c)/3) && (best == "" || d < bestDistance) {
+			best, bestDistance = c, d
+		}
+	}
+
+	return best
+}
+
+// editDistance is the Levenshtein distance between a and b
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+
+	return prev[len(b)]
+}
+
+// validationErrorResult returns the issues found in a call as a tool error
+func validationErrorResult(apiName string, issues []validationIssue) (*mcp.CallToolResult, error) {
+	sort.SliceStable(issues, func(i, j int) bool { return issues[i].Param < issues[j].Param })
+
+	marsh, err := json.Marshal(map[string]any{
+		"api":    apiName,
+		"error":  "invalid arguments, nothing was sent to CloudStack",
+		"issues": issues,
+	})
+	if err != nil {
+		return nil, errors.Errorf("marshalling validation error: %w", err)
+	}
+
+	return mcp.NewToolResultError(string(marsh)), nil
+}
@@ -0,0 +1,120 @@
+package mcp_test
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/walteh/cloudstack-mcp/pkg/mcp"
+)
+
+func Test_ValidateArguments(t *testing.T) {
+	var deployCalls atomic.Int32
+
+	apis := `[{"name":"deployVirtualMachine","params":[
+		{"name":"zoneid","type":"uuid","required":true},
+		{"name":"serviceofferingid","type":"uuid","required":true},
+		{"name":"rootdisksize","type":"long"},
+		{"name":"startvm","type":"boolean"},
+		{"name":"networkids","type":"list"},
+		{"name":"boottype","type":"string","description":"Possible values are Legacy and UEFI"},
+		{"name":"details","type":"map"}
+	]}]`
+
+	s := newTestServer(t, apis, func(w http.ResponseWriter, r *http.Request) {
+		deployCalls.Add(1)
+		fmt.Fprint(w, `{"deployvirtualmachineresponse":{"id":"vm1"}}`)
+	}, mcp.ServerOpts{})
+
+	const zone = `"zoneid":"3d5f7bd6-4a1e-4c4f-9a2e-1b8e2c3d4f5a","serviceofferingid":"8c1e2f3a-5b6c-4d7e-8f9a-0b1c2d3e4f5a"`
+
+	tests := []struct {
+		name  string
+		args  string
+		wants []string
+	}{
+		{name: "valid", args: `{` + zone + `,"rootdisksize":"20","startvm":"false","networkids":"3d5f7bd6-4a1e-4c4f-9a2e-1b8e2c3d4f5a","details":[{"cpuNumber":"2"}]}`},
+		{name: "missing required", args: `{"zoneid":"3d5f7bd6-4a1e-4c4f-9a2e-1b8e2c3d4f5a"}`, wants: []string{`{\"param\":\"serviceofferingid\",\"problem\":\"required parameter is missing\"}`}},
+		{name: "misspelled key", args: `{` + zone + `,"root_disk_size":20,"statvm":true}`, wants: []string{`did you mean rootdisksize?`, `did you mean startvm?`}},
+		{name: "not a uuid", args: `{"zoneid":"zone1","serviceofferingid":"8c1e2f3a-5b6c-4d7e-8f9a-0b1c2d3e4f5a"}`, wants: []string{`\\\"zone1\\\" is not a uuid`}},
+		{name: "wrong types", args: `{` + zone + `,"rootdisksize":20.5,"startvm":"yes","networkids":[{"id":1}]}`, wants: []string{`must be an integer`, `must be true or false, not \\\"yes\\\"`, `item 0 must be a string, not an object`}},
+		// values found in a description are only a hint, CloudStack decides
+		{name: "described values", args: `{` + zone + `,"boottype":"uefi"}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before := deployCalls.Load()
+
+			result := s.callTool("deployVirtualMachine", tt.args)
+
+			if len(tt.wants) == 0 {
+				assert.NotContains(t, result, `"isError":true`)
+				assert.Equal(t, before+1, deployCalls.Load())
+				return
+			}
+
+			assert.Contains(t, result, `"isError":true`)
+			for _, want := range tt.wants {
+				assert.Contains(t, result, want)
+			}
+			assert.Equal(t, before, deployCalls.Load(), "invalid call reached CloudStack")
+		})
+	}
+}
+
+func Test_ValidateArguments_Values(t *testing.T) {
+	apis := `[
+		{"name":"listTemplates","params":[{"name":"templatefilter","type":"string","required":true}]},
+		{"name":"listVirtualMachines","params":[{"name":"state","type":"string","description":"state of the virtual machine. Possible values are: Running, Stopped, Present, Destroyed, Expunged."}]},
+		{"name":"listVolumes","params":[{"name":"state","type":"string","description":"state of the volume. Possible values are: Ready, Allocated, Destroy, Expunging, Expunged."}]},
+		{"name":"createPortForwardingRule","params":[{"name":"protocol","type":"string","description":"the protocol for the port forwarding rule. Valid values are TCP or UDP."}]}
+	]`
+
+	s := newTestServer(t, apis, func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		if q.Get("command") == "createPortForwardingRule" && q.Get("protocol") == "sctp" {
+			w.WriteHeader(431)
+			fmt.Fprint(w, `{"createportforwardingruleresponse":{"errorcode":431,"errortext":"Invalid parameter protocol value=sctp"}}`)
+			return
+		}
+		fmt.Fprintf(w, `{"%sresponse":{"count":0}}`, strings.ToLower(q.Get("command")))
+	}, mcp.ServerOpts{})
+
+	tests := []struct {
+		name  string
+		tool  string
+		args  string
+		wants []string
+	}{
+		// values listed in descriptions are not enforced, whatever their case or completeness
+		{name: "described lower case", tool: "createPortForwardingRule", args: `{"protocol":"tcp"}`},
+		{name: "undescribed state", tool: "listVirtualMachines", args: `{"state":"Error"}`},
+		{name: "undescribed transition", tool: "listVirtualMachines", args: `{"state":"Starting"}`},
+		{name: "undescribed volume state", tool: "listVolumes", args: `{"state":"Uploaded"}`},
+		{name: "rejected by CloudStack", tool: "createPortForwardingRule", args: `{"protocol":"sctp"}`, wants: []string{`"param":"protocol"`, `"documentedvalues":["TCP","UDP"]`}},
+		// known enums are enforced
+		{name: "known enum", tool: "listTemplates", args: `{"templatefilter":"featured"}`},
+		{name: "known enum case", tool: "listTemplates", args: `{"templatefilter":"Featured"}`, wants: []string{`did you mean featured?`}},
+		{name: "known enum value", tool: "listTemplates", args: `{"templatefilter":"mine"}`, wants: []string{`use one of featured, self`}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			text, isError := s.callToolText(tt.tool, tt.args)
+
+			if len(tt.wants) == 0 {
+				assert.False(t, isError, text)
+				return
+			}
+
+			assert.True(t, isError)
+			for _, want := range tt.wants {
+				assert.Contains(t, text, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,768 @@
+package mcp
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/rs/zerolog"
+	"github.com/walteh/cloudstack-mcp/pkg/cloudstack"
+	errors "gitlab.com/tozd/go/errors"
+)
+
+const (
+	deployVMToolName       = "cs_deploy_vm_by_names"
+	resizeVMToolName       = "cs_resize_vm"
+	snapshotVolumeToolName = "cs_snapshot_and_backup_volume"
+	exposePortToolName     = "cs_expose_port"
+)
+
+// listItems calls a list API and returns the listed items
+func (s *Server) listItems(ctx context.Context, command string, params map[string]string) ([]map[string]any, error) {
+	api, err := s.exposedApi(command)
+	if err != nil {
+		return nil, err
+	}
+
+	raw, err := s.call(ctx, command, cloudstack.ListQuery(api, params))
+	if err != nil {
+		return nil, err
+	}
+
+	page, err := parseListPage(raw)
+	if err != nil {
+		return nil, errors.Errorf("parsing %s response: %w", command, err)
+	}
+
+	items := make([]map[string]any, 0, len(page.items))
+	for _, raw := range page.items {
+		var item map[string]any
+		if err := json.Unmarshal(raw, &item); err != nil {
+			return nil, errors.Errorf("unmarshalling %s item: %w", command, err)
+		}
+		items = append(items, item)
+	}
+
+	return items, nil
+}
+
+// resolveItem returns the resource of the list API command that ref names, by
+// id or by name. Names are resolved with the same resolver as the name
+// parameters of the API tools, so param and the errors read the same.
+func (s *Server) resolveItem(ctx context.Context, param, command, ref string, scope map[string]string) (map[string]any, error) {
+	if ref == "" {
+		return nil, errors.Errorf("%s is required", param)
+	}
+
+	list, err := s.exposedApi(command)
+	if err != nil {
+		return nil, err
+	}
+
+	resolver, err := s.resolver(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	id, err := resolver.Resolve(ctx, list, param, ref, scope)
+	if err != nil {
+		return nil, err
+	}
+
+	query := map[string]string{}
+	for k, v := range scope {
+		query[k] = v
+	}
+	query["id"] = id
+
+	items, err := s.listItems(ctx, command, query)
+	if err != nil {
+		return nil, errors.Errorf("looking up %s %q: %w", param, ref, err)
+	}
+
+	// some list APIs ignore filters they do not know, so match the id here
+	for _, item := range items {
+		if str(item["id"]) == id {
+			return item, nil
+		}
+	}
+	return nil, errors.Errorf("%s: nothing with id %s found with %s", param, id, command)
+}
+
+// runApi makes a CloudStack call, waiting for the job of an async API, and
+// returns the resource it responded with
+func (s *Server) runApi(ctx context.Context, command string, params map[string]string) (map[string]any, error) {
+	api, err := s.exposedApi(command)
+	if err != nil {
+		return nil, err
+	}
+
+	raw, err := s.call(ctx, command, params)
+	if err != nil {
+		return nil, err
+	}
+
+	var body json.RawMessage
+	if jobID, ok := cloudstack.JobIDFromResponse(raw); ok && api.Isasync {
+		// a workflow cannot go on before the job is done, so it waits without a timeout
+		job, err := cloudstack.WaitForAsyncJob(ctx, s.call, jobID, cloudstack.WaitOptions{})
+		if err != nil {
+			return nil, err
+		}
+		body = job.JobResult
+	} else {
+		body, err = cloudstack.UnwrapResponse(raw)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return resultObject(body)
+}
+
+// resultObject returns the resource in a response like {"virtualmachine": {...}},
+// or the whole response if it does not hold a single resource
+func resultObject(body json.RawMessage) (map[string]any, error) {
+	var fields map[string]any
+	if len(body) == 0 {
+		return map[string]any{}, nil
+	}
+	if err := json.Unmarshal(body, &fields); err != nil {
+		return nil, errors.Errorf("unmarshalling result: %w", err)
+	}
+
+	if len(fields) == 1 {
+		for _, v := range fields {
+			if obj, ok := v.(map[string]any); ok {
+				return obj, nil
+			}
+		}
+	}
+
+	return fields, nil
+}
+
+func str(v any) string {
+	if v == nil {
+		return ""
+	}
+	if s, ok := v.(string); ok {
+		return s
+	}
+	return fmt.Sprint(v)
+}
+
+// workflow is the sequence of CloudStack calls a workflow tool makes. When a
+// call fails, the calls registered with onFailure undo the earlier ones.
+type workflow struct {
+	s     *Server
+	name  string
+	steps []string
+	undo  []workflowCall
+}
+
+type workflowCall struct {
+	command string
+	params  map[string]string
+}
+
+func (s *Server) newWorkflow(name string) *workflow {
+	return &workflow{s: s, name: name, steps: []string{}}
+}
+
+func (w *workflow) run(ctx context.Context, command string, params map[string]string) (map[string]any, error) {
+	res, err := w.s.runApi(ctx, command, params)
+	if err != nil {
+		return nil, errors.Errorf("%s: %w", command, err)
+	}
+	w.steps = append(w.steps, command)
+	return res, nil
+}
+
+// onFailure registers a call that undoes what the workflow did so far
+func (w *workflow) onFailure(command string, params map[string]string) {
+	w.undo = append(w.undo, workflowCall{command: command, params: params})
+}
+
+// fail rolls the workflow back and returns err as the tool result
+func (w *workflow) fail(ctx context.Context, err error) (*mcp.CallToolResult, error) {
+	logger := zerolog.Ctx(ctx)
+
+	// the rollback has to run even when the call was cancelled
+	ctx = context.WithoutCancel(ctx)
+
+	rolledBack := []string{}
+	rollbackErrors := []string{}
+	for i := len(w.undo) - 1; i >= 0; i-- {
+		call := w.undo[i]
+		if _, err := w.s.runApi(ctx, call.command, call.params); err != nil {
+			logger.Warn().Err(err).Str("workflow", w.name).Str("command", call.command).Msg("Workflow rollback failed")
+			rollbackErrors = append(rollbackErrors, fmt.Sprintf("%s: %s", call.command, err))
+			continue
+		}
+		rolledBack = append(rolledBack, call.command)
+	}
+
+	res := map[string]any{
+		"error":      err.Error(),
+		"steps":      w.steps,
+		"rolledback": rolledBack,
+	}
+	if len(rollbackErrors) > 0 {
+		res["rollbackerrors"] = rollbackErrors
+	}
+
+	var aerr *cloudstack.APIError
+	var ferr *cloudstack.JobFailedError
+	switch {
+	case errors.As(err, &aerr) && aerr.Hint() != "":
+		res["hint"] = aerr.Hint()
+	case errors.As(err, &ferr) && ferr.Hint != "":
+		res["hint"] = ferr.Hint
+	}
+
+	marsh, merr := json.Marshal(res)
+	if merr != nil {
+		return nil, errors.Errorf("marshalling workflow failure: %w", merr)
+	}
+	return mcp.NewToolResultError(string(marsh)), nil
+}
+
+// workflowArgs are the arguments every workflow tool takes besides its own
+type workflowArgs struct {
+	DryRun  bool `json:"dry_run"`
+	Confirm bool `json:"confirm"`
+}
+
+// checkWorkflow returns the plan of a workflow as the result of a dry run, and
+// enforces safe mode like for single calls: a workflow only runs once the
+// same plan was previewed and confirmed
+func (s *Server) checkWorkflow(ctx context.Context, name string, plan map[string]string, args workflowArgs) (*mcp.CallToolResult, error) {
+	key := previewKey(ctx, name, plan)
+
+	if args.DryRun {
+		if s.opts.SafeMode {
+			s.previews.record(key)
+		}
+		return jsonResult(map[string]any{
+			"workflow": name,
+			"plan":     plan,
+			"message":  fmt.Sprintf("dry run only, nothing was changed. To run this workflow, repeat the call with the same arguments without %s", argDryRun),
+		})
+	}
+
+	if !s.opts.SafeMode {
+		return nil, nil
+	}
+
+	if !args.Confirm {
+		return mcp.NewToolResultError(fmt.Sprintf("safe mode: call %s with %s: true first to preview its plan, then again with the same arguments and %s: true", name, argDryRun, argConfirm)), nil
+	}
+
+	if !s.previews.consume(key) {
+		return mcp.NewToolResultError(fmt.Sprintf("safe mode: no preview found for this %s call. Call it with %s: true and the same arguments before confirming", name, argDryRun)), nil
+	}
+
+	return nil, nil
+}
+
+func (s *Server) workflowOptions() []mcp.ToolOption {
+	return append([]mcp.ToolOption{
+		mcp.WithBoolean(argDryRun, mcp.Description("resolve the names and return the plan without changing anything")),
+		mcp.WithBoolean(argConfirm, mcp.Description("in safe mode, run a workflow that was previewed with dry_run and the same arguments")),
+		mcp.WithOpenWorldHintAnnotation(false),
+	}, s.profileToolOption()...)
+}
+
+// workflowApis are the APIs each workflow tool calls
+var workflowApis = map[string][]string{
+	deployVMToolName:       {"listZones", "listTemplates", "listServiceOfferings", "listNetworks", "listDiskOfferings", "deployVirtualMachine"},
+	resizeVMToolName:       {"listVirtualMachines", "listServiceOfferings", "scaleVirtualMachine", "stopVirtualMachine", "changeServiceForVirtualMachine", "startVirtualMachine"},
+	snapshotVolumeToolName: {"listVirtualMachines", "listVolumes", "listZones", "createSnapshot", "deleteSnapshot", "copySnapshot"},
+	exposePortToolName:     {"listVirtualMachines", "listPublicIpAddresses", "associateIpAddress", "disassociateIpAddress", "createPortForwardingRule"},
+}
+
+// addWorkflowTool registers a workflow tool if the policy exposes every API it calls
+func (s *Server) addWorkflowTool(ctx context.Context, tool mcp.Tool, run func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error)) {
+	for _, name := range workflowApis[tool.Name] {
+		if _, err := s.exposedApi(name); err != nil {
+			zerolog.Ctx(ctx).Debug().Str("tool", tool.Name).Str("api", name).Msg("Skipping workflow tool using an API excluded by policy")
+			return
+		}
+	}
+
+	s.mcpServer.AddTool(tool, s.workflowHandler(run))
+}
+
+func (s *Server) registerWorkflowTools(ctx context.Context) {
+	s.addWorkflowTool(ctx, mcp.NewTool(deployVMToolName, append([]mcp.ToolOption{
+		mcp.WithDescription("Deploy a virtual machine from the names of its zone, template, service offering and networks, resolving them to ids and checking they fit together. Waits for the VM and returns its id, state, IP address and password."),
+		mcp.WithString("zone", mcp.Required(), mcp.Description("zone name or id")),
+		mcp.WithString("template", mcp.Required(), mcp.Description("template name or id, must be ready in the zone")),
+		mcp.WithString("service_offering", mcp.Required(), mcp.Description("service offering name or id")),
+		mcp.WithArray("networks", mcp.WithStringItems(), mcp.Description("names or ids of the networks in the zone to attach, the first is the default")),
+		mcp.WithString("name", mcp.Description("host name of the VM")),
+		mcp.WithString("disk_offering", mcp.Description("disk offering name or id for an extra data disk")),
+		mcp.WithNumber("size", mcp.Description("size in GB of the data disk, for custom disk offerings"), mcp.Min(1)),
+		mcp.WithString("keypair", mcp.Description("name of the SSH key pair to install")),
+		mcp.WithTitleAnnotation("Deploy VM By Names"),
+		mcp.WithReadOnlyHintAnnotation(false),
+		mcp.WithDestructiveHintAnnotation(false),
+		mcp.WithIdempotentHintAnnotation(false),
+	}, s.workflowOptions()...)...), s.deployVMByNames)
+
+	s.addWorkflowTool(ctx, mcp.NewTool(resizeVMToolName, append([]mcp.ToolOption{
+		mcp.WithDescription("Change the service offering of a virtual machine. A running VM is scaled live when it supports it, otherwise it is stopped, resized and started again if allow_restart is set. A failed resize restores the VM's offering and state."),
+		mcp.WithString("vm", mcp.Required(), mcp.Description("VM name or id")),
+		mcp.WithString("service_offering", mcp.Required(), mcp.Description("new service offering name or id")),
+		mcp.WithBoolean("allow_restart", mcp.Description("stop and start a running VM that cannot be scaled live")),
+		mcp.WithTitleAnnotation("Resize VM"),
+		mcp.WithReadOnlyHintAnnotation(false),
+		mcp.WithDestructiveHintAnnotation(false),
+		mcp.WithIdempotentHintAnnotation(false),
+	}, s.workflowOptions()...)...), s.resizeVM)
+
+	s.addWorkflowTool(ctx, mcp.NewTool(snapshotVolumeToolName, append([]mcp.ToolOption{
+		mcp.WithDescription("Snapshot a volume, or the root volume of a VM, and wait until the snapshot is backed up to secondary storage, optionally copying it to other zones. The snapshot is deleted again if any step fails."),
+		mcp.WithString("volume", mcp.Description("volume name or id")),
+		mcp.WithString("vm", mcp.Description("VM name or id whose root volume is snapshotted, instead of volume")),
+		mcp.WithString("name", mcp.Description("name of the snapshot")),
+		mcp.WithArray("copy_to_zones", mcp.WithStringItems(), mcp.Description("names or ids of zones to copy the snapshot to")),
+		mcp.WithTitleAnnotation("Snapshot And Back Up Volume"),
+		mcp.WithReadOnlyHintAnnotation(false),
+		mcp.WithDestructiveHintAnnotation(false),
+		mcp.WithIdempotentHintAnnotation(false),
+	}, s.workflowOptions()...)...), s.snapshotAndBackupVolume)
+
+	s.addWorkflowTool(ctx, mcp.NewTool(exposePortToolName, append([]mcp.ToolOption{
+		mcp.WithDescription("Make a port of a virtual machine reachable from outside its network with a port forwarding rule and matching firewall rule, acquiring a public IP address unless one is given. A newly acquired address is released again if the rule cannot be created."),
+		mcp.WithString("vm", mcp.Required(), mcp.Description("VM name or id")),
+		mcp.WithNumber("public_port", mcp.Required(), mcp.Description("port on the public IP address"), mcp.Min(1), mcp.Max(65535)),
+		mcp.WithNumber("private_port", mcp.Description("port on the VM, defaults to public_port"), mcp.Min(1), mcp.Max(65535)),
+		mcp.WithString("protocol", mcp.Description("tcp or udp, defaults to tcp"), mcp.Enum("tcp", "udp")),
+		mcp.WithString("public_ip", mcp.Description("public IP address of the VM's network to use instead of acquiring a new one")),
+		mcp.WithArray("cidr_list", mcp.WithStringItems(), mcp.Description("source CIDRs allowed to connect, defaults to 0.0.0.0/0")),
+		mcp.WithTitleAnnotation("Expose VM Port"),
+		mcp.WithReadOnlyHintAnnotation(false),
+		mcp.WithDestructiveHintAnnotation(false),
+		mcp.WithIdempotentHintAnnotation(false),
+	}, s.workflowOptions()...)...), s.exposePort)
+}
+
+// workflowHandler applies the profile argument and turns errors resolving or
+// validating the arguments into tool errors
+func (s *Server) workflowHandler(run func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error)) func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	return func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		ctx, err := s.requestProfile(ctx, req)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+
+		res, err := run(ctx, req)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+		return res, nil
+	}
+}
+
+func (s *Server) deployVMByNames(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	var args struct {
+		workflowArgs
+		Zone            string   `json:"zone"`
+		Template        string   `json:"template"`
+		ServiceOffering string   `json:"service_offering"`
+		Networks        []string `json:"networks"`
+		Name            string   `json:"name"`
+		DiskOffering    string   `json:"disk_offering"`
+		Size            int      `json:"size"`
+		Keypair         string   `json:"keypair"`
+	}
+	if err := req.BindArguments(&args); err != nil {
+		return nil, errors.Errorf("invalid arguments: %w", err)
+	}
+
+	zone, err := s.resolveItem(ctx, "zone", "listZones", args.Zone, nil)
+	if err != nil {
+		return nil, err
+	}
+	zoneID := str(zone["id"])
+
+	template, err := s.resolveItem(ctx, "template", "listTemplates", args.Template, map[string]string{"templatefilter": "executable", "zoneid": zoneID})
+	if err != nil {
+		return nil, err
+	}
+	if ready, ok := template["isready"].(bool); ok && !ready {
+		return nil, errors.Errorf("template %s is not ready in zone %s", template["name"], zone["name"])
+	}
+
+	offering, err := s.resolveItem(ctx, "service_offering", "listServiceOfferings", args.ServiceOffering, nil)
+	if err != nil {
+		return nil, err
+	}
+	if custom, _ := offering["iscustomized"].(bool); custom {
+		return nil, errors.Errorf("service offering %s needs the CPU and memory to be set, call deployVirtualMachine with details instead", offering["name"])
+	}
+
+	plan := map[string]string{
+		"zoneid":            zoneID,
+		"templateid":        str(template["id"]),
+		"serviceofferingid": str(offering["id"]),
+	}
+
+	networkIDs := []string{}
+	for _, ref := range args.Networks {
+		network, err := s.resolveItem(ctx, "networks", "listNetworks", ref, map[string]string{"zoneid": zoneID})
+		if err != nil {
+			return nil, err
+		}
+		networkIDs = append(networkIDs, str(network["id"]))
+	}
+	if len(networkIDs) > 0 {
+		plan["networkids"] = strings.Join(networkIDs, ",")
+	}
+
+	if args.DiskOffering != "" {
+		disk, err := s.resolveItem(ctx, "disk_offering", "listDiskOfferings", args.DiskOffering, nil)
+		if err != nil {
+			return nil, err
+		}
+		custom, _ := disk["iscustomized"].(bool)
+		switch {
+		case custom && args.Size == 0:
+			return nil, errors.Errorf("disk offering %s is custom sized, size is required", disk["name"])
+		case !custom && args.Size != 0:
+			return nil, errors.Errorf("disk offering %s has a fixed size, size cannot be set", disk["name"])
+		}
+		plan["diskofferingid"] = str(disk["id"])
+		if args.Size != 0 {
+			plan["size"] = strconv.Itoa(args.Size)
+		}
+	}
+
+	if args.Name != "" {
+		plan["name"] = args.Name
+	}
+	if args.Keypair != "" {
+		plan["keypair"] = args.Keypair
+	}
+
+	if res, err := s.checkWorkflow(ctx, deployVMToolName, plan, args.workflowArgs); res != nil || err != nil {
+		return res, err
+	}
+
+	w := s.newWorkflow(deployVMToolName)
+	vm, err := w.run(ctx, "deployVirtualMachine", plan)
+	if err != nil {
+		return w.fail(ctx, err)
+	}
+
+	res := map[string]any{
+		"id":              vm["id"],
+		"name":            vm["name"],
+		"state":           vm["state"],
+		"zone":            zone["name"],
+		"template":        template["name"],
+		"serviceoffering": offering["name"],
+	}
+	if nics, ok := vm["nic"].([]any); ok && len(nics) > 0 {
+		if nic, ok := nics[0].(map[string]any); ok {
+			res["ipaddress"] = nic["ipaddress"]
+		}
+	}
+	if password, ok := vm["password"]; ok {
+		res["password"] = password
+	}
+
+	return jsonResult(res)
+}
+
+func (s *Server) resizeVM(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	var args struct {
+		workflowArgs
+		VM              string `json:"vm"`
+		ServiceOffering string `json:"service_offering"`
+		AllowRestart    bool   `json:"allow_restart"`
+	}
+	if err := req.BindArguments(&args); err != nil {
+		return nil, errors.Errorf("invalid arguments: %w", err)
+	}
+
+	vm, err := s.resolveItem(ctx, "vm", "listVirtualMachines", args.VM, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	offering, err := s.resolveItem(ctx, "service_offering", "listServiceOfferings", args.ServiceOffering, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	vmID := str(vm["id"])
+	oldOfferingID := str(vm["serviceofferingid"])
+	if oldOfferingID == str(offering["id"]) {
+		return nil, errors.Errorf("VM %s already has service offering %s", vm["name"], offering["name"])
+	}
+
+	// a running VM is scaled live if both it and the offering allow it
+	running := str(vm["state"]) == "Running"
+	scalable, _ := vm["isdynamicallyscalable"].(bool)
+	if dynamic, ok := offering["dynamicscalingenabled"].(bool); ok && !dynamic {
+		scalable = false
+	}
+
+	method := "changeServiceForVirtualMachine"
+	switch {
+	case running && scalable:
+		method = "scaleVirtualMachine"
+	case running && !args.AllowRestart:
+		return nil, errors.Errorf("VM %s is running and cannot be scaled live, set allow_restart to stop and start it", vm["name"])
+	case running:
+		method = "stop, changeServiceForVirtualMachine, start"
+	}
+
+	plan := map[string]string{"id": vmID, "serviceofferingid": str(offering["id"]), "method": method}
+	if res, err := s.checkWorkflow(ctx, resizeVMToolName, plan, args.workflowArgs); res != nil || err != nil {
+		return res, err
+	}
+
+	change := map[string]string{"id": vmID, "serviceofferingid": str(offering["id"])}
+	w := s.newWorkflow(resizeVMToolName)
+
+	var resized map[string]any
+	switch {
+	case running && scalable:
+		resized, err = w.run(ctx, "scaleVirtualMachine", change)
+	case running:
+		if _, err := w.run(ctx, "stopVirtualMachine", map[string]string{"id": vmID}); err != nil {
+			return w.fail(ctx, err)
+		}
+		w.onFailure("startVirtualMachine", map[string]string{"id": vmID})
+
+		if _, err := w.run(ctx, "changeServiceForVirtualMachine", change); err != nil {
+			return w.fail(ctx, err)
+		}
+		w.onFailure("changeServiceForVirtualMachine", map[string]string{"id": vmID, "serviceofferingid": oldOfferingID})
+
+		resized, err = w.run(ctx, "startVirtualMachine", map[string]string{"id": vmID})
+	default:
+		resized, err = w.run(ctx, "changeServiceForVirtualMachine", change)
+	}
+	if err != nil {
+		return w.fail(ctx, err)
+	}
+
+	return jsonResult(map[string]any{
+		"id":              vmID,
+		"name":            vm["name"],
+		"state":           resized["state"],
+		"serviceoffering": offering["name"],
+		"cpunumber":       resized["cpunumber"],
+		"memory":          resized["memory"],
+		"steps":           w.steps,
+	})
+}
+
+func (s *Server) snapshotAndBackupVolume(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	var args struct {
+		workflowArgs
+		Volume      string   `json:"volume"`
+		VM          string   `json:"vm"`
+		Name        string   `json:"name"`
+		CopyToZones []string `json:"copy_to_zones"`
+	}
+	if err := req.BindArguments(&args); err != nil {
+		return nil, errors.Errorf("invalid arguments: %w", err)
+	}
+
+	var volume map[string]any
+	switch {
+	case args.Volume != "" && args.VM != "":
+		return nil, errors.New("set either volume or vm, not both")
+	case args.VM != "":
+		vm, err := s.resolveItem(ctx, "vm", "listVirtualMachines", args.VM, nil)
+		if err != nil {
+			return nil, err
+		}
+		volumes, err := s.listItems(ctx, "listVolumes", map[string]string{"virtualmachineid": str(vm["id"]), "type": "ROOT"})
+		if err != nil {
+			return nil, errors.Errorf("looking up root volume of VM %s: %w", vm["name"], err)
+		}
+		if len(volumes) != 1 {
+			return nil, errors.Errorf("VM %s has %d root volumes", vm["name"], len(volumes))
+		}
+		volume = volumes[0]
+	default:
+		var err error
+		volume, err = s.resolveItem(ctx, "volume", "listVolumes", args.Volume, nil)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	plan := map[string]string{"volumeid": str(volume["id"])}
+	if args.Name != "" {
+		plan["name"] = args.Name
+	}
+
+	zoneIDs := []string{}
+	zoneNames := []string{}
+	for _, ref := range args.CopyToZones {
+		zone, err := s.resolveItem(ctx, "copy_to_zones", "listZones", ref, nil)
+		if err != nil {
+			return nil, err
+		}
+		if str(zone["id"]) == str(volume["zoneid"]) {
+			return nil, errors.Errorf("volume %s is already in zone %s", volume["name"], zone["name"])
+		}
+		zoneIDs = append(zoneIDs, str(zone["id"]))
+		zoneNames = append(zoneNames, str(zone["name"]))
+	}
+	if len(zoneIDs) > 0 {
+		plan["destzoneids"] = strings.Join(zoneIDs, ",")
+	}
+
+	if res, err := s.checkWorkflow(ctx, snapshotVolumeToolName, plan, args.workflowArgs); res != nil || err != nil {
+		return res, err
+	}
+
+	create := map[string]string{"volumeid": plan["volumeid"]}
+	if args.Name != "" {
+		create["name"] = args.Name
+	}
+
+	w := s.newWorkflow(snapshotVolumeToolName)
+	snapshot, err := w.run(ctx, "createSnapshot", create)
+	if err != nil {
+		return w.fail(ctx, err)
+	}
+	snapshotID := str(snapshot["id"])
+	w.onFailure("deleteSnapshot", map[string]string{"id": snapshotID})
+
+	if state := str(snapshot["state"]); state != "BackedUp" {
+		return w.fail(ctx, errors.Errorf("snapshot %s is %s instead of backed up", snapshotID, state))
+	}
+
+	if len(zoneIDs) > 0 {
+		if _, err := w.run(ctx, "copySnapshot", map[string]string{"id": snapshotID, "destzoneids": plan["destzoneids"]}); err != nil {
+			return w.fail(ctx, err)
+		}
+	}
+
+	return jsonResult(map[string]any{
+		"id":       snapshotID,
+		"name":     snapshot["name"],
+		"state":    snapshot["state"],
+		"volume":   volume["name"],
+		"zone":     volume["zonename"],
+		"copiedto": zoneNames,
+	})
+}
+
+func (s *Server) exposePort(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	var args struct {
+		workflowArgs
+		VM          string   `json:"vm"`
+		PublicPort  int      `json:"public_port"`
+		PrivatePort int      `json:"private_port"`
+		Protocol    string   `json:"protocol"`
+		PublicIP    string   `json:"public_ip"`
+		CIDRList    []string `json:"cidr_list"`
+	}
+	if err := req.BindArguments(&args); err != nil {
+		return nil, errors.Errorf("invalid arguments: %w", err)
+	}
+
+	if args.PrivatePort == 0 {
+		args.PrivatePort = args.PublicPort
+	}
+	if args.Protocol == "" {
+		args.Protocol = "tcp"
+	}
+	for _, port := range []int{args.PublicPort, args.PrivatePort} {
+		if port < 1 || port > 65535 {
+			return nil, errors.Errorf("port %d is out of range", port)
+		}
+	}
+	if args.Protocol != "tcp" && args.Protocol != "udp" {
+		return nil, errors.Errorf("protocol must be tcp or udp, not %q", args.Protocol)
+	}
+
+	vm, err := s.resolveItem(ctx, "vm", "listVirtualMachines", args.VM, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	networkID := ""
+	if nics, ok := vm["nic"].([]any); ok {
+		for _, n := range nics {
+			if nic, ok := n.(map[string]any); ok && nic["isdefault"] == true {
+				networkID = str(nic["networkid"])
+			}
+		}
+	}
+	if networkID == "" {
+		return nil, errors.Errorf("VM %s has no default network", vm["name"])
+	}
+
+	plan := map[string]string{
+		"virtualmachineid": str(vm["id"]),
+		"networkid":        networkID,
+		"publicport":       strconv.Itoa(args.PublicPort),
+		"privateport":      strconv.Itoa(args.PrivatePort),
+		"protocol":         args.Protocol,
+		"openfirewall":     "true",
+	}
+	if len(args.CIDRList) > 0 {
+		plan["cidrlist"] = strings.Join(args.CIDRList, ",")
+	}
+
+	if args.PublicIP != "" {
+		ips, err := s.listItems(ctx, "listPublicIpAddresses", map[string]string{"ipaddress": args.PublicIP})
+		if err != nil {
+			return nil, errors.Errorf("looking up public IP address %s: %w", args.PublicIP, err)
+		}
+		if len(ips) != 1 {
+			return nil, errors.Errorf("no public IP address %s", args.PublicIP)
+		}
+		if str(ips[0]["associatednetworkid"]) != networkID && (ips[0]["vpcid"] == nil || str(ips[0]["vpcid"]) != str(vm["vpcid"])) {
+			return nil, errors.Errorf("public IP address %s is not on the network of VM %s", args.PublicIP, vm["name"])
+		}
+		plan["ipaddressid"] = str(ips[0]["id"])
+	}
+
+	if res, err := s.checkWorkflow(ctx, exposePortToolName, plan, args.workflowArgs); res != nil || err != nil {
+		return res, err
+	}
+
+	w := s.newWorkflow(exposePortToolName)
+
+	ipAddress := args.PublicIP
+	if plan["ipaddressid"] == "" {
+		ip, err := w.run(ctx, "associateIpAddress", map[string]string{"networkid": networkID})
+		if err != nil {
+			return w.fail(ctx, err)
+		}
+		plan["ipaddressid"] = str(ip["id"])
+		ipAddress = str(ip["ipaddress"])
+		w.onFailure("disassociateIpAddress", map[string]string{"id": plan["ipaddressid"]})
+	}
+
+	rule, err := w.run(ctx, "createPortForwardingRule", plan)
+	if err != nil {
+		return w.fail(ctx, err)
+	}
+
+	return jsonResult(map[string]any{
+		"ruleid":      rule["id"],
+		"ipaddress":   ipAddress,
+		"publicport":  args.PublicPort,
+		"privateport": args.PrivatePort,
+		"protocol":    args.Protocol,
+		"vm":          vm["name"],
+		"steps":       w.steps,
+	})
+}
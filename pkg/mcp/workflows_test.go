@@ -0,0 +1,141 @@
+package mcp_test
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/walteh/cloudstack-mcp/pkg/mcp"
+)
+
+func Test_WorkflowTools(t *testing.T) {
+	var mu sync.Mutex
+	calls := []string{}
+	jobs := map[string]string{}
+
+	apis := `[
+		{"name":"listZones","params":[]},
+		{"name":"listTemplates","params":[{"name":"zoneid","type":"uuid"},{"name":"templatefilter","type":"string"}]},
+		{"name":"listServiceOfferings","params":[]},
+		{"name":"listNetworks","params":[]},
+		{"name":"listDiskOfferings","params":[]},
+		{"name":"listPublicIpAddresses","params":[]},
+		{"name":"listVirtualMachines","params":[{"name":"listall","type":"boolean"}]},
+		{"name":"deployVirtualMachine","isasync":true,"params":[]},
+		{"name":"associateIpAddress","isasync":true,"params":[]},
+		{"name":"disassociateIpAddress","isasync":true,"params":[]},
+		{"name":"createPortForwardingRule","isasync":true,"params":[]}
+	]`
+
+	s := newTestServer(t, apis, func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		command := q.Get("command")
+
+		mu.Lock()
+		defer mu.Unlock()
+		if command != "queryAsyncJobResult" {
+			calls = append(calls, command)
+		}
+
+		async := func(result string) {
+			jobID := fmt.Sprintf("job%d", len(jobs))
+			jobs[jobID] = result
+			fmt.Fprintf(w, `{"%sresponse":{"jobid":%q}}`, command, jobID)
+		}
+
+		switch command {
+		case "queryAsyncJobResult":
+			result := jobs[q.Get("jobid")]
+			if result == "" {
+				fmt.Fprintf(w, `{"queryasyncjobresultresponse":{"jobid":%q,"jobstatus":2,"jobresult":{"errorcode":530,"errortext":"no free ports"}}}`, q.Get("jobid"))
+				return
+			}
+			fmt.Fprintf(w, `{"queryasyncjobresultresponse":{"jobid":%q,"jobstatus":1,"jobresult":%s}}`, q.Get("jobid"), result)
+		case "listZones":
+			fmt.Fprint(w, `{"listzonesresponse":{"count":2,"zone":[{"id":"z1","name":"zone1"},{"id":"z2","name":"zone10"}]}}`)
+		case "listTemplates":
+			assert.Equal(t, "z1", q.Get("zoneid"))
+			fmt.Fprint(w, `{"listtemplatesresponse":{"count":1,"template":[{"id":"t1","name":"ubuntu","isready":true}]}}`)
+		case "listServiceOfferings":
+			fmt.Fprint(w, `{"listserviceofferingsresponse":{"count":1,"serviceoffering":[{"id":"so1","name":"small"}]}}`)
+		case "listVirtualMachines":
+			fmt.Fprint(w, `{"listvirtualmachinesresponse":{"count":1,"virtualmachine":[{"id":"vm1","name":"web","nic":[{"networkid":"n1","isdefault":true}]}]}}`)
+		case "deployVirtualMachine":
+			assert.Equal(t, "z1", q.Get("zoneid"))
+			assert.Equal(t, "t1", q.Get("templateid"))
+			assert.Equal(t, "so1", q.Get("serviceofferingid"))
+			async(`{"virtualmachine":{"id":"vm2","name":"db","state":"Running","nic":[{"ipaddress":"10.0.0.5"}]}}`)
+		case "associateIpAddress":
+			async(`{"ipaddress":{"id":"ip1","ipaddress":"192.0.2.10"}}`)
+		case "disassociateIpAddress":
+			async(`{"success":true}`)
+		case "createPortForwardingRule":
+			async("")
+		}
+	}, mcp.ServerOpts{})
+
+	deployed := s.callTool("cs_deploy_vm_by_names", `{"zone":"zone1","template":"ubuntu","service_offering":"small","name":"db"}`)
+	assert.Contains(t, deployed, `\"id\":\"vm2\"`)
+	assert.Contains(t, deployed, `\"ipaddress\":\"10.0.0.5\"`)
+
+	unknown := s.callTool("cs_deploy_vm_by_names", `{"zone":"zone","template":"ubuntu","service_offering":"small"}`)
+	assert.Contains(t, unknown, `"isError":true`)
+	assert.Contains(t, unknown, `zone: nothing named \"zone\" found with listZones, did you mean zone1, zone10?`)
+
+	calls = nil
+	exposed := s.callTool("cs_expose_port", `{"vm":"web","public_port":443}`)
+	assert.Contains(t, exposed, `"isError":true`)
+	assert.Contains(t, exposed, `no free ports`)
+	assert.Contains(t, exposed, `\"rolledback\":[\"disassociateIpAddress\"]`)
+	// the VM is listed by name to resolve it, then by id for its nics
+	assert.Equal(t, []string{"listVirtualMachines", "listVirtualMachines", "associateIpAddress", "createPortForwardingRule", "disassociateIpAddress"}, calls)
+}
+
+func Test_WorkflowTools_Policy(t *testing.T) {
+	names := []string{
+		"listZones", "listTemplates", "listServiceOfferings", "listNetworks", "listDiskOfferings", "listVirtualMachines",
+		"listVolumes", "listPublicIpAddresses", "deployVirtualMachine", "scaleVirtualMachine", "stopVirtualMachine",
+		"startVirtualMachine", "changeServiceForVirtualMachine", "createSnapshot", "deleteSnapshot", "copySnapshot",
+		"associateIpAddress", "disassociateIpAddress", "createPortForwardingRule",
+	}
+	apis := []string{}
+	for _, name := range names {
+		apis = append(apis, fmt.Sprintf(`{"name":%q,"params":[]}`, name))
+	}
+	catalog := "[" + strings.Join(apis, ",") + "]"
+
+	workflows := []string{"cs_deploy_vm_by_names", "cs_resize_vm", "cs_snapshot_and_backup_volume", "cs_expose_port"}
+
+	tests := []struct {
+		name   string
+		policy *mcp.Policy
+		want   []string
+	}{
+		{name: "full", policy: nil, want: workflows},
+		{name: "readonly", policy: &mcp.Policy{Include: mcp.PolicyRules{Verbs: []string{"list"}}}, want: nil},
+		{
+			name:   "one API excluded",
+			policy: &mcp.Policy{Exclude: mcp.PolicyRules{Names: []string{"deleteSnapshot"}}},
+			want:   []string{"cs_deploy_vm_by_names", "cs_resize_vm", "cs_expose_port"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t, catalog, func(w http.ResponseWriter, r *http.Request) {}, mcp.ServerOpts{Policy: tt.policy})
+
+			tools := s.request("tools/list", `{}`)
+			for _, name := range workflows {
+				if slices.Contains(tt.want, name) {
+					assert.Contains(t, tools, fmt.Sprintf(`"name":%q`, name))
+				} else {
+					assert.NotContains(t, tools, fmt.Sprintf(`"name":%q`, name))
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,71 @@
+// Package redact scrubs credentials from log output: passwords, session keys,
+// API keys, signatures and cookies, whether they appear in a query string, a
+// JSON document, a header or a curl command
+package redact
+
+import (
+	"io"
+	"regexp"
+
+	"github.com/rs/zerolog"
+)
+
+// Redacted replaces every scrubbed value
+const Redacted = "[REDACTED]"
+
+// secretNames are the names of the parameters, fields and cookies that hold credentials
+const secretNames = `password|sessionkey|apikey|api_key|secretkey|secret_key|signature|jsessionid|sessionid`
+
+var (
+	// queryPattern matches name=value pairs of query strings, form bodies and cookies
+	queryPattern = regexp.MustCompile(`(?i)\b(` + secretNames + `)=([^&;\s"'\\]+)`)
+	// jsonPattern matches string fields of JSON documents, also when the
+	// document is itself escaped inside a JSON string
+	jsonPattern = regexp.MustCompile(`(?i)(\\*"(?:` + secretNames + `)\\*"\s*:\s*\\*")((?:[^"\\]|\\[^"])*)(\\*")`)
+	// headerPattern matches the headers carrying credentials, up to the end of the header value
+	headerPattern = regexp.MustCompile(`(?i)\b(cookie|set-cookie|authorization|x-cloudstack-api-key|x-cloudstack-secret-key)(:\s*)([^\r\n"']+)`)
+)
+
+// String returns s with every credential it contains replaced by Redacted
+func String(s string) string {
+	s = headerPattern.ReplaceAllString(s, "${1}${2}"+Redacted)
+	s = jsonPattern.ReplaceAllString(s, "${1}"+Redacted+"${3}")
+	s = queryPattern.ReplaceAllString(s, "${1}="+Redacted)
+	return s
+}
+
+// Bytes is String for a byte slice, like a request body
+func Bytes(b []byte) []byte {
+	return []byte(String(string(b)))
+}
+
+// writer redacts every event before handing it to the writer it wraps
+type writer struct {
+	w zerolog.LevelWriter
+}
+
+// NewWriter returns a zerolog writer scrubbing the credentials of every event
+// written to w, so nothing logged through it can leak them
+func NewWriter(w io.Writer) zerolog.LevelWriter {
+	if lw, ok := w.(zerolog.LevelWriter); ok {
+		return &writer{w: lw}
+	}
+	return &writer{w: zerolog.LevelWriterAdapter{Writer: w}}
+}
+
+// Write implements io.Writer
+func (w *writer) Write(p []byte) (int, error) {
+	if _, err := w.w.Write(Bytes(p)); err != nil {
+		return 0, err
+	}
+	// the caller only needs to know its own bytes were taken
+	return len(p), nil
+}
+
+// WriteLevel implements zerolog.LevelWriter
+func (w *writer) WriteLevel(level zerolog.Level, p []byte) (int, error) {
+	if _, err := w.w.WriteLevel(level, Bytes(p)); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
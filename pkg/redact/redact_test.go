@@ -0,0 +1,78 @@
+package redact_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/walteh/cloudstack-mcp/pkg/redact"
+)
+
+func Test_String(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{
+			name: "query string",
+			in:   "http://cs/client/api?apiKey=abc&command=listZones&response=json&signature=a%2Bb%3D",
+			want: "http://cs/client/api?apiKey=[REDACTED]&command=listZones&response=json&signature=[REDACTED]",
+		},
+		{
+			name: "login form",
+			in:   "command=login&username=admin&password=hunter2&domain=%2F",
+			want: "command=login&username=admin&password=[REDACTED]&domain=%2F",
+		},
+		{
+			name: "cookie",
+			in:   "JSESSIONID=0123ABCD; Path=/client; HttpOnly",
+			want: "JSESSIONID=[REDACTED]; Path=/client; HttpOnly",
+		},
+		{
+			name: "curl command",
+			in:   `curl -X 'POST' -H 'Cookie: JSESSIONID=0123ABCD' 'http://cs/client/api?command=listZones&sessionkey=s3cr3t'`,
+			want: `curl -X 'POST' -H 'Cookie: [REDACTED]' 'http://cs/client/api?command=listZones&sessionkey=[REDACTED]'`,
+		},
+		{
+			name: "json",
+			in:   `{"registeruserkeysresponse":{"userkeys":{"apikey":"abc","secretkey":"def"}},"username":"admin"}`,
+			want: `{"registeruserkeysresponse":{"userkeys":{"apikey":"[REDACTED]","secretkey":"[REDACTED]"}},"username":"admin"}`,
+		},
+		{
+			name: "json in a json string",
+			in:   `{"message":"body {\"sessionkey\":\"s3cr3t\",\"account\":\"admin\"}"}`,
+			want: `{"message":"body {\"sessionkey\":\"[REDACTED]\",\"account\":\"admin\"}"}`,
+		},
+		{
+			name: "session credential headers",
+			in:   "X-CloudStack-API-Key: abc\r\nX-CloudStack-Secret-Key: def\r\nAccept: */*",
+			want: "X-CloudStack-API-Key: [REDACTED]\r\nX-CloudStack-Secret-Key: [REDACTED]\r\nAccept: */*",
+		},
+		{
+			name: "nothing secret",
+			in:   `{"name":"zone1","id":"123"} command=listZones`,
+			want: `{"name":"zone1","id":"123"} command=listZones`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, redact.String(tt.in))
+		})
+	}
+}
+
+func Test_NewWriter(t *testing.T) {
+	var buf bytes.Buffer
+	logger := zerolog.New(redact.NewWriter(&buf))
+
+	logger.Info().Str("password", "hunter2").RawJSON("body", []byte(`{"apikey":"abc"}`)).Msg("Making request to: http://cs/client/api?signature=xyz")
+
+	out := buf.String()
+	assert.NotContains(t, out, "hunter2")
+	assert.NotContains(t, out, "abc")
+	assert.NotContains(t, out, "xyz")
+	assert.Contains(t, out, `"password":"[REDACTED]"`)
+}